@@ -1,9 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
 	"github.com/joho/godotenv"
 )
 
@@ -38,6 +43,23 @@ func GetPort() string {
 	return "8080"
 }
 
+// GetStorageBackend 获取存储后端类型：redis（默认）、file（单文件嵌入式存储）或 memory（仅内存）
+func GetStorageBackend() string {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND")))
+	if backend == "" {
+		return "redis"
+	}
+	return backend
+}
+
+// GetStoragePath 获取 file 存储后端的数据文件路径
+func GetStoragePath() string {
+	if path := strings.TrimSpace(os.Getenv("STORAGE_PATH")); path != "" {
+		return path
+	}
+	return "data/stash-rule.db"
+}
+
 // GetRedisAddr 获取 Redis 地址
 func GetRedisAddr() string {
 	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
@@ -57,3 +79,408 @@ func GetRedisDB() int {
 	// 真正严谨应该 strconv.Atoi
 	return 0
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %d", key, raw, defaultValue)
+		return defaultValue
+	}
+	return v
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %t", key, raw, defaultValue)
+		return defaultValue
+	}
+	return v
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		log.Printf("Warning: invalid %s=%q, using default %s", key, raw, defaultValue)
+		return defaultValue
+	}
+	return v
+}
+
+// GetTrustProxyHeaders 是否信任 X-Forwarded-For / X-Real-IP 获取客户端 IP（部署在反向代理之后时开启）
+func GetTrustProxyHeaders() bool {
+	return getEnvBool("TRUST_PROXY_HEADERS", false)
+}
+
+// defaultTrustedProxies 未设置 TRUSTED_PROXIES 时视为反向代理的地址段：回环、私有与链路本地地址。
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7", "169.254.0.0/16", "fe80::/10"}
+
+// GetTrustedProxies 获取受信任的反向代理地址段（TRUSTED_PROXIES，逗号分隔的 CIDR 或 IP）。
+// 解析 X-Forwarded-For 时从右向左跳过这些地址，取第一个不受信任的地址作为客户端 IP。
+func GetTrustedProxies() []*net.IPNet {
+	entries := defaultTrustedProxies
+	if raw := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES")); raw != "" {
+		entries = strings.Split(raw, ",")
+	}
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Warning: invalid TRUSTED_PROXIES entry %q, ignored", entry)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// GetLeakDetectWindow 获取 token 泄露检测的统计窗口
+func GetLeakDetectWindow() time.Duration {
+	return getEnvDuration("LEAK_DETECT_WINDOW", 24*time.Hour)
+}
+
+// GetLeakDetectMaxIPs 获取窗口内允许的最大不同 IP 数，0 表示不检测
+func GetLeakDetectMaxIPs() int {
+	return getEnvInt("LEAK_DETECT_MAX_IPS", 5)
+}
+
+// GetLeakDetectMaxClients 获取窗口内允许的最大不同客户端指纹数，0 表示不检测
+func GetLeakDetectMaxClients() int {
+	return getEnvInt("LEAK_DETECT_MAX_CLIENTS", 3)
+}
+
+// GetLeakDetectAutoDisable 触发泄露告警时是否自动禁用订阅 token
+func GetLeakDetectAutoDisable() bool {
+	return getEnvBool("LEAK_DETECT_AUTO_DISABLE", false)
+}
+
+// RateLimit 表示一个限流规则：Window 内最多 Limit 次请求，Limit <= 0 表示不限流
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Enabled 是否启用该限流规则
+func (r RateLimit) Enabled() bool {
+	return r.Limit > 0 && r.Window > 0
+}
+
+// parseRateLimit 解析 "次数/时间窗口" 格式，例如 "60/1m"
+func parseRateLimit(raw string) (RateLimit, error) {
+	parts := strings.SplitN(strings.TrimSpace(raw), "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("expected <limit>/<window>")
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return RateLimit{}, err
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return RateLimit{}, err
+	}
+	return RateLimit{Limit: limit, Window: window}, nil
+}
+
+// GetRateLimit 获取指定路由的限流规则，读取环境变量 RATE_LIMIT_<ROUTE>（如 RATE_LIMIT_CONFIG=60/1m）
+func GetRateLimit(route string, defaultValue RateLimit) RateLimit {
+	key := "RATE_LIMIT_" + strings.ToUpper(route)
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	limit, err := parseRateLimit(raw)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q (%v), using default %d/%s", key, raw, err, defaultValue.Limit, defaultValue.Window)
+		return defaultValue
+	}
+	return limit
+}
+
+// GetLoginMaxFailures 获取触发锁定前允许的连续登录失败次数，0 表示不锁定
+func GetLoginMaxFailures() int {
+	return getEnvInt("LOGIN_MAX_FAILURES", 5)
+}
+
+// GetLoginLockoutDuration 获取登录失败后的锁定时长（同时作为失败计数窗口）
+func GetLoginLockoutDuration() time.Duration {
+	return getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// GetTOTPIssuer 获取两步验证认证器中显示的发行方名称
+func GetTOTPIssuer() string {
+	if issuer := strings.TrimSpace(os.Getenv("TOTP_ISSUER")); issuer != "" {
+		return issuer
+	}
+	return "Stash Rule"
+}
+
+// GetSessionIdleTimeout 获取管理员 session 的空闲超时（每次访问顺延）
+func GetSessionIdleTimeout() time.Duration {
+	return getEnvDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour)
+}
+
+// GetSessionMaxLifetime 获取管理员 session 的最长有效期（自创建起，不随访问顺延）
+func GetSessionMaxLifetime() time.Duration {
+	return getEnvDuration("SESSION_MAX_LIFETIME", 30*24*time.Hour)
+}
+
+// GetCookieSecure 获取 cookie Secure 策略：auto（默认，按请求是否为 HTTPS 判断）、true、false
+func GetCookieSecure() string {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("COOKIE_SECURE"))); v {
+	case "true", "false":
+		return v
+	default:
+		return "auto"
+	}
+}
+
+// OIDCConfig OIDC 单点登录配置
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	// RoleMapping 组名 -> 管理员角色（owner/editor/viewer）
+	RoleMapping map[string]string
+	// DefaultRole 未命中任何组映射时的角色，为空表示拒绝登录
+	DefaultRole string
+}
+
+// Enabled 是否启用 OIDC 登录
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != "" && c.RedirectURL != ""
+}
+
+// GetOIDCConfig 读取 OIDC 配置。
+// OIDC_ROLE_MAPPING 格式: "group1=owner,group2=editor"
+func GetOIDCConfig() OIDCConfig {
+	cfg := OIDCConfig{
+		Issuer:        strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/"),
+		ClientID:      strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL")),
+		Scopes:        []string{"openid", "profile", "email"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleMapping:   map[string]string{},
+		DefaultRole:   strings.TrimSpace(os.Getenv("OIDC_DEFAULT_ROLE")),
+	}
+	if raw := strings.TrimSpace(os.Getenv("OIDC_SCOPES")); raw != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(raw, ",", " "))
+	}
+	if claim := strings.TrimSpace(os.Getenv("OIDC_USERNAME_CLAIM")); claim != "" {
+		cfg.UsernameClaim = claim
+	}
+	if claim := strings.TrimSpace(os.Getenv("OIDC_GROUPS_CLAIM")); claim != "" {
+		cfg.GroupsClaim = claim
+	}
+	for _, pair := range strings.Split(os.Getenv("OIDC_ROLE_MAPPING"), ",") {
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if group != "" && role != "" {
+			cfg.RoleMapping[group] = role
+		}
+	}
+	return cfg
+}
+
+// GetLocalLoginEnabled 是否允许本地用户名密码登录（启用 OIDC 后可关闭）
+func GetLocalLoginEnabled() bool {
+	return getEnvBool("LOCAL_LOGIN_ENABLED", true)
+}
+
+// BackupConfig 定时备份配置。Dir 与 S3Bucket 均未设置时不启用。
+type BackupConfig struct {
+	Interval      time.Duration
+	Retention     int
+	IncludeTokens bool
+
+	Dir string
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
+}
+
+// Enabled 是否配置了备份目标
+func (c BackupConfig) Enabled() bool {
+	return c.Dir != "" || c.S3Bucket != ""
+}
+
+// GetBackupConfig 读取定时备份配置。
+// 设置 BACKUP_S3_BUCKET 时写入 S3 兼容存储，否则写入 BACKUP_DIR 目录。
+func GetBackupConfig() BackupConfig {
+	cfg := BackupConfig{
+		Interval:      getEnvDuration("BACKUP_INTERVAL", 24*time.Hour),
+		Retention:     getEnvInt("BACKUP_RETENTION", 7),
+		IncludeTokens: getEnvBool("BACKUP_INCLUDE_TOKENS", true),
+		Dir:           strings.TrimSpace(os.Getenv("BACKUP_DIR")),
+		S3Endpoint:    strings.TrimRight(strings.TrimSpace(os.Getenv("BACKUP_S3_ENDPOINT")), "/"),
+		S3Region:      strings.TrimSpace(os.Getenv("BACKUP_S3_REGION")),
+		S3Bucket:      strings.TrimSpace(os.Getenv("BACKUP_S3_BUCKET")),
+		S3Prefix:      strings.Trim(strings.TrimSpace(os.Getenv("BACKUP_S3_PREFIX")), "/"),
+		S3AccessKey:   strings.TrimSpace(os.Getenv("BACKUP_S3_ACCESS_KEY")),
+		S3SecretKey:   os.Getenv("BACKUP_S3_SECRET_KEY"),
+		S3PathStyle:   getEnvBool("BACKUP_S3_PATH_STYLE", true),
+	}
+	if cfg.S3Endpoint == "" {
+		cfg.S3Endpoint = "https://s3.amazonaws.com"
+	}
+	if cfg.S3Region == "" {
+		cfg.S3Region = "us-east-1"
+	}
+	if cfg.Retention < 1 {
+		cfg.Retention = 1
+	}
+	return cfg
+}
+
+// UpstreamConfig 拉取上游订阅链接的超时与重试配置。
+type UpstreamConfig struct {
+	// Timeout 单次请求超时，SourceTimeouts 可按订阅链接单独覆盖。
+	Timeout time.Duration
+	// SourceTimeouts 订阅链接的主机名（或链接前缀）-> 单次请求超时
+	SourceTimeouts map[string]time.Duration
+	// Retries 单次刷新中失败后的重试次数，0 表示不重试
+	Retries int
+	// RetryBackoff / RetryBackoffMax 重试等待的初始值与上限，每次翻倍并加随机抖动
+	RetryBackoff    time.Duration
+	RetryBackoffMax time.Duration
+	// FailedRetryInterval 刷新失败的链接在每日刷新之外再次尝试的间隔，连续失败时翻倍（不超过一天）
+	FailedRetryInterval time.Duration
+	// Proxy 拉取订阅使用的出站代理：http://、https://、socks5:// 链接，或 node:<节点名> 使用已缓存的
+	// socks5 / http 节点；为空时直连（仍遵循 HTTP_PROXY 等环境变量）。SourceProxies 可按订阅链接单独覆盖，
+	// 值为 direct 表示该链接不使用代理。
+	Proxy         string
+	SourceProxies map[string]string
+}
+
+// UpstreamDirect 按订阅链接设置为直连，忽略全局出站代理。
+const UpstreamDirect = "direct"
+
+// matchSource 查找订阅链接对应的设置：优先匹配主机名，其次匹配最长的链接前缀。
+// 前缀必须在边界处结束（之后是 /、?、: 或链接末尾），避免 https://a.com 匹配到 https://a.com.evil.net。
+func matchSource[T any](sources map[string]T, rawURL, host string) (T, bool) {
+	if value, ok := sources[host]; ok {
+		return value, true
+	}
+	var matched T
+	best := -1
+	for prefix, value := range sources {
+		if isSourcePrefix(rawURL, prefix) && len(prefix) > best {
+			best, matched = len(prefix), value
+		}
+	}
+	return matched, best >= 0
+}
+
+func isSourcePrefix(rawURL, prefix string) bool {
+	if prefix == "" || !strings.HasPrefix(rawURL, prefix) {
+		return false
+	}
+	if len(rawURL) == len(prefix) || strings.ContainsAny(prefix[len(prefix)-1:], "/?:") {
+		return true
+	}
+	return strings.ContainsAny(rawURL[len(prefix):len(prefix)+1], "/?:")
+}
+
+// TimeoutFor 返回订阅链接的单次请求超时。
+func (c UpstreamConfig) TimeoutFor(rawURL, host string) time.Duration {
+	if timeout, ok := matchSource(c.SourceTimeouts, rawURL, host); ok {
+		return timeout
+	}
+	return c.Timeout
+}
+
+// ProxyFor 返回订阅链接使用的出站代理设置，空字符串表示直连。
+func (c UpstreamConfig) ProxyFor(rawURL, host string) string {
+	proxy, ok := matchSource(c.SourceProxies, rawURL, host)
+	if !ok {
+		proxy = c.Proxy
+	}
+	if strings.EqualFold(proxy, UpstreamDirect) {
+		return ""
+	}
+	return proxy
+}
+
+// parseSourceSettings 解析 "source=value,source=value" 格式的按订阅链接设置。
+// source 为主机名或链接前缀，以第一个 "=" 分隔（前缀中不能包含 "="，值中可以）。
+func parseSourceSettings(key string) map[string]string {
+	settings := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		source, value, ok := strings.Cut(pair, "=")
+		source, value = strings.TrimSpace(source), strings.TrimSpace(value)
+		if !ok || source == "" || value == "" {
+			log.Printf("Warning: invalid %s entry %q, ignored", key, pair)
+			continue
+		}
+		settings[source] = value
+	}
+	return settings
+}
+
+// GetUpstreamConfig 读取上游拉取配置。
+// UPSTREAM_SOURCE_TIMEOUTS 格式: "sub.example.com=60s,https://other.example.com/api=10s"
+// UPSTREAM_SOURCE_PROXIES 格式: "sub.example.com=socks5://127.0.0.1:1080,other.example.com=node:HK 01,cn.example.com=direct"
+func GetUpstreamConfig() UpstreamConfig {
+	cfg := UpstreamConfig{
+		Timeout:             getEnvDuration("UPSTREAM_TIMEOUT", 30*time.Second),
+		SourceTimeouts:      map[string]time.Duration{},
+		Retries:             getEnvInt("UPSTREAM_RETRIES", 2),
+		RetryBackoff:        getEnvDuration("UPSTREAM_RETRY_BACKOFF", 2*time.Second),
+		RetryBackoffMax:     getEnvDuration("UPSTREAM_RETRY_BACKOFF_MAX", 30*time.Second),
+		FailedRetryInterval: getEnvDuration("UPSTREAM_FAILED_RETRY_INTERVAL", 30*time.Minute),
+		Proxy:               strings.TrimSpace(os.Getenv("UPSTREAM_PROXY")),
+		SourceProxies:       parseSourceSettings("UPSTREAM_SOURCE_PROXIES"),
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	for source, raw := range parseSourceSettings("UPSTREAM_SOURCE_TIMEOUTS") {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			log.Printf("Warning: invalid UPSTREAM_SOURCE_TIMEOUTS entry %q, ignored", source+"="+raw)
+			continue
+		}
+		cfg.SourceTimeouts[source] = timeout
+	}
+	return cfg
+}
@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestMatchSourcePrefixStopsAtBoundary(t *testing.T) {
+	sources := map[string]string{
+		"https://sub.example.com":          "host",
+		"https://sub.example.com/api":      "api",
+		"https://sub.example.com/private/": "private",
+	}
+	cases := []struct {
+		url, want string
+		ok        bool
+	}{
+		{"https://sub.example.com", "host", true},
+		{"https://sub.example.com/feed", "host", true},
+		{"https://sub.example.com:8443/feed", "host", true},
+		{"https://sub.example.com?token=1", "host", true},
+		{"https://sub.example.com/api?token=1", "api", true},
+		{"https://sub.example.com/api/v2", "api", true},
+		{"https://sub.example.com/private/feed", "private", true},
+		// 前缀后面不是边界字符时不算匹配。
+		{"https://sub.example.com.evil.net/feed", "", false},
+		{"https://sub.example.comx/feed", "", false},
+		{"https://sub.example.com/apix", "host", true},
+	}
+	for _, c := range cases {
+		got, ok := matchSource(sources, c.url, "")
+		if ok != c.ok || got != c.want {
+			t.Errorf("matchSource(%q) = %q, %v; want %q, %v", c.url, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -2,30 +2,44 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 	"text/template"
+	"time"
 
+	"my-stash-rule/internal/service"
 	"my-stash-rule/internal/store"
 )
 
 type adminPageData struct {
-	ActivePage string
-	PageTitle  string
+	ActivePage  string
+	PageTitle   string
+	CurrentUser string
+	CurrentRole string
+	IsOwner     bool
+	CSRFToken   string
 }
 
-func renderAdminPage(w http.ResponseWriter, page, title string) {
+func renderAdminPage(w http.ResponseWriter, r *http.Request, page, title string) {
 	tmpl, err := template.ParseFS(TemplatesFS, "templates/admin.html")
 	if err != nil {
 		http.Error(w, "Failed to load template", http.StatusInternalServerError)
 		log.Printf("Template error: %v", err)
 		return
 	}
-	_ = tmpl.Execute(w, adminPageData{
+	data := adminPageData{
 		ActivePage: page,
 		PageTitle:  title,
-	})
+		CSRFToken:  csrfTokenFromRequest(r),
+	}
+	if admin := currentAdmin(r); admin != nil {
+		data.CurrentUser = admin.Username
+		data.CurrentRole = string(admin.Role)
+		data.IsOwner = admin.Role == store.AdminRoleOwner
+	}
+	_ = tmpl.Execute(w, data)
 }
 
 // HandleAdminPage 入口，跳转到默认模块页面
@@ -43,7 +57,7 @@ func HandleAdminConfigPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	renderAdminPage(w, "config", "订阅链接配置")
+	renderAdminPage(w, r, "config", "订阅链接配置")
 }
 
 // HandleAdminProfilesPage 模板管理页
@@ -52,7 +66,7 @@ func HandleAdminProfilesPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	renderAdminPage(w, "profiles", "Stash 模板管理")
+	renderAdminPage(w, r, "profiles", "Stash 模板管理")
 }
 
 // HandleAdminSubscribersPage 订阅用户管理页
@@ -61,7 +75,7 @@ func HandleAdminSubscribersPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	renderAdminPage(w, "subscribers", "订阅用户管理")
+	renderAdminPage(w, r, "subscribers", "订阅用户管理")
 }
 
 // HandleAdminAccountPage 管理员账号设置页
@@ -70,67 +84,455 @@ func HandleAdminAccountPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	renderAdminPage(w, "account", "管理员账号设置")
+	renderAdminPage(w, r, "account", "管理员账号设置")
 }
 
-// HandleAdminProfileAPI 管理员资料接口
-// GET: 获取当前管理员用户名
-// POST: 更新管理员用户名/密码
+// HandleAdminUsersPage 管理员列表页（仅 owner）
+func HandleAdminUsersPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	renderAdminPage(w, r, "users", "管理员列表")
+}
+
+// HandleAdminProfileAPI 当前管理员资料接口
+// GET: 获取当前管理员用户名、角色与两步验证状态
+// POST: 按 action 执行操作：
+//   - 空 / "update_credentials": 更新当前管理员用户名/密码
+//   - "totp_setup": 生成待确认的 TOTP 密钥与扫码 URI（需当前密码）
+//   - "totp_enable": 校验验证码后启用两步验证，返回恢复码
+//   - "totp_disable": 关闭两步验证（需当前密码）
+//   - "recovery_codes": 重新生成恢复码（需当前密码）
 func HandleAdminProfileAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	admin := currentAdmin(r)
+	if admin == nil {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
 	if r.Method == http.MethodGet {
-		username, err := store.GetAdminUsername()
+		totp, err := store.GetAdminTOTP(admin.Username)
 		if err != nil {
 			http.Error(w, `{"error":"failed to load admin profile"}`, http.StatusInternalServerError)
 			return
 		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"username":            admin.Username,
+			"role":                admin.Role,
+			"totp_enabled":        totp.Enabled,
+			"recovery_codes_left": totp.RecoveryCodesLeft,
+		})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Action          string `json:"action"`
+		CurrentPassword string `json:"current_password"`
+		NewUsername     string `json:"new_username"`
+		NewPassword     string `json:"new_password"`
+		Code            string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	writeError := func(err error) {
+		statusCode := http.StatusBadRequest
+		if strings.Contains(err.Error(), "incorrect") {
+			statusCode = http.StatusUnauthorized
+		}
+		if strings.Contains(err.Error(), "already exists") {
+			statusCode = http.StatusConflict
+		}
+		writeJSONError(w, statusCode, err)
+	}
+
+	// requirePassword 校验当前密码，失败时写入错误响应并返回 false。
+	requirePassword := func() bool {
+		ok, err := store.AuthenticateAdmin(admin.Username, req.CurrentPassword)
+		if err != nil {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return false
+		}
+		if !ok {
+			http.Error(w, `{"error":"current password is incorrect"}`, http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	switch req.Action {
+	case "", "update_credentials":
+		newUsername := strings.TrimSpace(req.NewUsername)
+		if newUsername == "" {
+			http.Error(w, `{"error":"新用户名不能为空"}`, http.StatusBadRequest)
+			return
+		}
+
+		if err := store.UpdateAdminCredentials(admin.Username, req.CurrentPassword, newUsername, req.NewPassword); err != nil {
+			writeError(err)
+			return
+		}
+
+		// 凭据变更后旧 session 已全部撤销，为当前操作者签发新 session。
+		reloginRequired := false
+		csrfToken, err := startSession(w, r, newUsername)
+		if err != nil {
+			log.Printf("Failed to create session after credential change: %v", err)
+			reloginRequired = true
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":           "ok",
+			"username":         newUsername,
+			"relogin_required": reloginRequired,
+			"csrf_token":       csrfToken,
+		})
+	case "totp_setup":
+		if !requirePassword() {
+			return
+		}
+		enrollment, err := service.BeginTOTPEnrollment(admin.Username)
+		if err != nil {
+			writeError(err)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(enrollment)
+	case "totp_enable":
+		codes, err := service.ConfirmTOTPEnrollment(admin.Username, req.Code)
+		if err != nil {
+			writeError(err)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "ok",
+			"recovery_codes": codes,
+		})
+	case "totp_disable":
+		if !requirePassword() {
+			return
+		}
+		if err := store.DisableAdminTOTP(admin.Username); err != nil {
+			writeError(err)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	case "recovery_codes":
+		if !requirePassword() {
+			return
+		}
+		codes, err := service.RegenerateRecoveryCodes(admin.Username)
+		if err != nil {
+			writeError(err)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "ok",
+			"recovery_codes": codes,
+		})
+	default:
+		http.Error(w, `{"error":"unknown action"}`, http.StatusBadRequest)
+	}
+}
+
+// HandleAdminUsersAPI 管理员账号管理接口（仅 owner）
+// GET: 获取管理员列表
+// POST: 新增管理员
+// PUT: 修改管理员角色 / 重置密码 / 重置两步验证
+// DELETE: 删除管理员
+func HandleAdminUsersAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	writeError := func(err error) {
+		status := http.StatusBadRequest
+		if errors.Is(err, store.ErrAdminNotFound) {
+			status = http.StatusNotFound
+		}
+		if strings.Contains(err.Error(), "already exists") {
+			status = http.StatusConflict
+		}
+		writeJSONError(w, status, err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		users, err := store.ListAdminUsers()
+		if err != nil {
+			http.Error(w, `{"error":"failed to load admin users"}`, http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"users": users,
+			"roles": []store.AdminRole{store.AdminRoleOwner, store.AdminRoleEditor, store.AdminRoleViewer},
+		})
+		return
+	case http.MethodPost, http.MethodPut:
+		var req struct {
+			Username  string          `json:"username"`
+			Password  string          `json:"password"`
+			Role      store.AdminRole `json:"role"`
+			ResetTOTP bool            `json:"reset_totp"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		username := strings.TrimSpace(req.Username)
+		if username == "" {
+			http.Error(w, `{"error":"用户名不能为空"}`, http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if r.Method == http.MethodPost {
+			err = store.CreateAdminUser(username, req.Password, req.Role)
+		} else {
+			err = store.UpdateAdminUser(username, req.Role, req.Password)
+			if err == nil && req.ResetTOTP {
+				err = store.DisableAdminTOTP(username)
+			}
+		}
+		if err != nil {
+			writeError(err)
+			return
+		}
 
 		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status":   "ok",
 			"username": username,
+			"role":     string(req.Role),
 		})
 		return
+	case http.MethodDelete:
+		var req struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		username := strings.TrimSpace(req.Username)
+		if admin := currentAdmin(r); admin != nil && admin.Username == username {
+			http.Error(w, `{"error":"cannot delete yourself"}`, http.StatusBadRequest)
+			return
+		}
+
+		if err := store.DeleteAdminUser(username); err != nil {
+			writeError(err)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status":   "ok",
+			"username": username,
+		})
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+// HandleAdminSessionsAPI 管理员登录会话接口
+// GET: 列出会话（默认当前管理员；owner 可通过 ?username= 查看他人）
+// DELETE: 撤销会话 {"username": "...", "id": "..."} 或 {"all": true}（保留当前会话）
+func HandleAdminSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin := currentAdmin(r)
+	if admin == nil {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	currentToken := ""
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		currentToken = cookie.Value
+	}
+
+	// resolveTarget 返回要操作的管理员用户名，非 owner 只能操作自己的会话。
+	resolveTarget := func(username string) (string, bool) {
+		username = strings.TrimSpace(username)
+		if username == "" || username == admin.Username {
+			return admin.Username, true
+		}
+		if admin.Role != store.AdminRoleOwner {
+			http.Error(w, `{"error":"permission denied"}`, http.StatusForbidden)
+			return "", false
+		}
+		return username, true
 	}
 
-	if r.Method == http.MethodPost {
+	switch r.Method {
+	case http.MethodGet:
+		username, ok := resolveTarget(r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+		sessions, err := store.ListSessions(username)
+		if err != nil {
+			http.Error(w, `{"error":"failed to load sessions"}`, http.StatusInternalServerError)
+			return
+		}
+
+		currentID := ""
+		if currentToken != "" {
+			currentID = store.SessionID(currentToken)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"username":   username,
+			"sessions":   sessions,
+			"current_id": currentID,
+		})
+		return
+	case http.MethodDelete:
 		var req struct {
-			CurrentPassword string `json:"current_password"`
-			NewUsername     string `json:"new_username"`
-			NewPassword     string `json:"new_password"`
+			Username string `json:"username"`
+			ID       string `json:"id"`
+			All      bool   `json:"all"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
 			return
 		}
+		username, ok := resolveTarget(req.Username)
+		if !ok {
+			return
+		}
 
-		oldUsername, err := store.GetAdminUsername()
+		var err error
+		if req.All {
+			err = store.RevokeAllSessions(username, currentToken)
+		} else {
+			err = store.RevokeSession(username, req.ID)
+		}
 		if err != nil {
-			http.Error(w, `{"error":"failed to load admin profile"}`, http.StatusInternalServerError)
+			status := http.StatusBadRequest
+			if strings.Contains(err.Error(), "not found") {
+				status = http.StatusNotFound
+			}
+			writeJSONError(w, status, err)
 			return
 		}
+		w.Write([]byte(`{"status":"ok"}`))
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+}
 
-		newUsername := strings.TrimSpace(req.NewUsername)
-		if newUsername == "" {
-			http.Error(w, `{"error":"新用户名不能为空"}`, http.StatusBadRequest)
+// HandleAdminAPITokensAPI 管理 API Token 接口
+// GET: 列出 Token（默认当前管理员；owner 可通过 ?username= 查看他人，?username=* 查看全部）
+// POST: 创建 Token {"name": "...", "role": "viewer", "expires_in_days": 90}，明文仅在响应中返回一次
+// DELETE: 撤销 Token {"username": "...", "id": "..."}
+func HandleAdminAPITokensAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin := currentAdmin(r)
+	if admin == nil {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		username := strings.TrimSpace(r.URL.Query().Get("username"))
+		if username == "" {
+			username = admin.Username
+		}
+		if username != admin.Username && admin.Role != store.AdminRoleOwner {
+			http.Error(w, `{"error":"permission denied"}`, http.StatusForbidden)
 			return
 		}
+		if username == "*" {
+			username = ""
+		}
 
-		if err := store.UpdateAdminCredentials(req.CurrentPassword, newUsername, req.NewPassword); err != nil {
-			statusCode := http.StatusBadRequest
-			if strings.Contains(err.Error(), "incorrect") {
-				statusCode = http.StatusUnauthorized
-			}
-			http.Error(w, `{"error":"`+err.Error()+`"}`, statusCode)
+		tokens, err := store.ListAdminAPITokens(username)
+		if err != nil {
+			http.Error(w, `{"error":"failed to load api tokens"}`, http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"tokens": tokens,
+		})
+		return
+	case http.MethodPost:
+		var req struct {
+			Name          string `json:"name"`
+			Role          string `json:"role"`
+			ExpiresInDays int    `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
 			return
 		}
+		role := store.AdminRole(strings.TrimSpace(req.Role))
+		if role == "" {
+			role = store.AdminRoleViewer
+		}
+		if req.ExpiresInDays < 0 {
+			http.Error(w, `{"error":"expires_in_days must not be negative"}`, http.StatusBadRequest)
+			return
+		}
+
+		token, info, err := store.CreateAdminAPIToken(admin.Username, req.Name, role,
+			time.Duration(req.ExpiresInDays)*24*time.Hour)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("Admin %s created api token %s (%s, %s)", admin.Username, info.ID, info.Name, info.Role)
 
+		w.WriteHeader(http.StatusCreated)
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":           "ok",
-			"username":         newUsername,
-			"relogin_required": oldUsername != "" && oldUsername != newUsername,
+			"token": token,
+			"info":  info,
 		})
 		return
-	}
+	case http.MethodDelete:
+		var req struct {
+			Username string `json:"username"`
+			ID       string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		username := strings.TrimSpace(req.Username)
+		if username == "" {
+			username = admin.Username
+		}
+		if username != admin.Username && admin.Role != store.AdminRoleOwner {
+			http.Error(w, `{"error":"permission denied"}`, http.StatusForbidden)
+			return
+		}
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if err := store.RevokeAdminAPIToken(username, req.ID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrAdminAPITokenNotFound) {
+				status = http.StatusNotFound
+			}
+			writeJSONError(w, status, err)
+			return
+		}
+		log.Printf("Admin %s revoked api token %s of %s", admin.Username, req.ID, username)
+		w.Write([]byte(`{"status":"ok"}`))
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 }
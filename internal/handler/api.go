@@ -2,10 +2,12 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"my-stash-rule/internal/service"
 	"my-stash-rule/internal/store"
@@ -58,6 +60,7 @@ func HandleConfigAPI(w http.ResponseWriter, r *http.Request) {
 // GET: 列出模板
 // POST: 创建模板
 // PUT: 更新模板
+// DELETE: 删除模板 {"name": "xxx", "reassign_to": "yyy"}，模板仍被订阅用户使用时需指定 reassign_to 改绑
 func HandleStashProfilesAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -106,22 +109,54 @@ func HandleStashProfilesAPI(w http.ResponseWriter, r *http.Request) {
 
 		var err error
 		if r.Method == http.MethodPost {
-			err = store.CreateStashProfile(req.Name, req.Content)
+			err = store.CreateStashProfile(req.Name, req.Content, currentAdminName(r))
 		} else {
-			err = store.UpdateStashProfile(req.Name, req.Content)
+			err = store.UpdateStashProfile(req.Name, req.Content, currentAdminName(r))
 		}
 		if err != nil {
-			statusCode := http.StatusBadRequest
-			if strings.Contains(err.Error(), "already exists") {
-				statusCode = http.StatusConflict
-			}
-			if strings.Contains(err.Error(), "not found") {
-				statusCode = http.StatusNotFound
+			writeProfileError(w, err)
+			return
+		}
+
+		warnings, adjustments := profileWarnings(req.Name)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "ok",
+			"name":        req.Name,
+			"warnings":    warnings,
+			"adjustments": adjustments,
+		})
+		return
+	case http.MethodDelete:
+		var req struct {
+			Name       string `json:"name"`
+			ReassignTo string `json:"reassign_to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, `{"error":"模板名称不能为空"}`, http.StatusBadRequest)
+			return
+		}
+
+		if err := store.DeleteStashProfile(req.Name, req.ReassignTo); err != nil {
+			var inUse *store.StashProfileInUseError
+			if errors.As(err, &inUse) {
+				w.WriteHeader(http.StatusConflict)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":       err.Error(),
+					"subscribers": inUse.Subscribers,
+				})
+				return
 			}
-			http.Error(w, `{"error":"`+err.Error()+`"}`, statusCode)
+			writeProfileError(w, err)
 			return
 		}
 
+		log.Printf("Admin %s deleted stash profile %s (reassign to: %q, ip: %s)", currentAdminName(r), req.Name, req.ReassignTo, clientIP(r))
 		_ = json.NewEncoder(w).Encode(map[string]string{
 			"status": "ok",
 			"name":   req.Name,
@@ -133,6 +168,93 @@ func HandleStashProfilesAPI(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleStashProfileRenameAPI 重命名模板，绑定该模板的订阅用户自动改绑
+// POST: {"name": "old", "new_name": "new"}
+func HandleStashProfileRenameAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		NewName string `json:"new_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.NewName = strings.TrimSpace(req.NewName)
+	if req.Name == "" || req.NewName == "" {
+		http.Error(w, `{"error":"模板名称不能为空"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := store.RenameStashProfile(req.Name, req.NewName); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+
+	log.Printf("Admin %s renamed stash profile %s to %s (ip: %s)", currentAdminName(r), req.Name, req.NewName, clientIP(r))
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"name":   req.NewName,
+	})
+}
+
+// HandleStashProfileResolvedAPI 预览模板按 extends 继承链合并后的结果
+// GET ?name=xxx
+func HandleStashProfileResolvedAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := store.DefaultStashProfileNameIfEmpty(r.URL.Query().Get("name"))
+	content, chain, err := service.ResolveProfileYAML(name)
+	if err != nil {
+		writeProfileError(w, err)
+		return
+	}
+
+	warnings, adjustments := profileWarnings(name)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":        name,
+		"chain":       chain,
+		"content":     string(content),
+		"warnings":    warnings,
+		"adjustments": adjustments,
+	})
+}
+
+// profileWarnings 校验模板生成的完整配置，返回校验警告与生成时会自动修正的内容；
+// 校验本身失败时只记录日志，不影响保存。
+func profileWarnings(name string) ([]service.ConfigWarning, []service.ConfigChange) {
+	warnings, adjustments, err := service.ValidateProfileConfig(name)
+	if err != nil {
+		log.Printf("Failed to validate stash profile %s: %v", name, err)
+	}
+	return nonNilWarnings(warnings), nonNilChanges(adjustments)
+}
+
+// writeProfileError 按错误类型返回 404 / 409 / 400。
+func writeProfileError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusBadRequest
+	if strings.Contains(err.Error(), "already exists") || errors.Is(err, store.ErrStashProfileConflict) {
+		statusCode = http.StatusConflict
+	}
+	if errors.Is(err, store.ErrStashProfileNotFound) {
+		statusCode = http.StatusNotFound
+	}
+	writeJSONError(w, statusCode, err)
+}
+
 // HandleProxyCacheAPI 管理订阅链接缓存。
 // GET: 获取缓存状态
 // POST: 手动刷新缓存
@@ -163,12 +285,22 @@ func HandleProxyCacheAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleGetConfig 生成 Stash 配置
+// GET ?format=yaml|json，输出按 ETag / Last-Modified 支持条件请求，内容未变化时返回 304。
 func HandleGetConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = service.ConfigFormatYAML
+	}
+	if format != service.ConfigFormatYAML && format != service.ConfigFormatJSON {
+		http.Error(w, "format must be yaml or json", http.StatusBadRequest)
+		return
+	}
+
 	username, isAdmin, err := ResolveConfigRequester(r)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -179,6 +311,16 @@ func HandleGetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isAdmin {
+		leak, err := service.RecordConfigFetch(username, clientIP(r), clientFingerprint(r))
+		if err != nil {
+			log.Printf("Failed to record config fetch for %s: %v", username, err)
+		} else if leak.AutoDisabled {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Get URLs from Redis
 	urls, err := store.GetStoredSubscribeUrls()
 	if err != nil {
@@ -194,50 +336,57 @@ func HandleGetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("开始读取 %d 个订阅链接缓存...", len(urls))
-	proxies, err := service.BuildProxiesFromCache(urls)
-	if err != nil {
-		log.Printf("Failed to load proxies from cache: %v", err)
-		http.Error(w, "Failed to load proxies", http.StatusInternalServerError)
-		return
-	}
-
-	defaultProfileMap, err := store.GetStashProfileMap(store.DefaultStashProfileName)
+	rendered, err := service.RenderConfigCached(service.RenderRequest{Username: username, Subscriber: !isAdmin}, format)
 	if err != nil {
-		log.Printf("Failed to load default stash profile: %v", err)
-		http.Error(w, "Failed to load stash profile", http.StatusInternalServerError)
+		log.Printf("Failed to generate config for %s: %v", username, err)
+		http.Error(w, "Failed to generate config", http.StatusInternalServerError)
 		return
 	}
-
-	selectedProfileName := store.DefaultStashProfileName
-	if !isAdmin {
-		selectedProfileName, err = store.GetSubscriberProfile(username)
-		if err != nil {
-			log.Printf("Failed to load subscriber profile for %s: %v", username, err)
-			http.Error(w, "Failed to load subscriber profile", http.StatusInternalServerError)
-			return
+	if result := rendered.Result; result != nil {
+		log.Printf("共获取 %d 个代理节点，已生成配置（用户: %s, 模板: %s）", result.NodeCount, username, strings.Join(result.Chain, " -> "))
+		if len(result.Warnings) > 0 {
+			log.Printf("配置校验发现 %d 个问题（用户: %s）:", len(result.Warnings), username)
+			for _, warning := range result.Warnings {
+				log.Printf("  %s: %s", warning.Path, warning.Message)
+			}
 		}
-	}
-
-	overlays := []map[string]interface{}{defaultProfileMap}
-	if selectedProfileName != store.DefaultStashProfileName {
-		selectedProfileMap, err := store.GetStashProfileMap(selectedProfileName)
-		if err != nil {
-			log.Printf("Failed to load stash profile %s: %v", selectedProfileName, err)
-			http.Error(w, "Failed to load stash profile", http.StatusInternalServerError)
-			return
+		if len(result.Changes) > 0 {
+			log.Printf("生成配置时自动修正 %d 处失效引用（用户: %s）:", len(result.Changes), username)
+			for _, change := range result.Changes {
+				log.Printf("  %s: %s", change.Path, change.Message)
+			}
 		}
-		overlays = append(overlays, selectedProfileMap)
 	}
 
-	log.Printf("共获取 %d 个代理节点，开始生成配置（用户: %s, 模板: %s）...", len(proxies), username, selectedProfileName)
-	configBytes, err := service.GenerateConfig(proxies, overlays...)
-	if err != nil {
-		log.Printf("Failed to generate config: %v", err)
-		http.Error(w, "Failed to generate config", http.StatusInternalServerError)
+	if format == service.ConfigFormatJSON {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	}
+	w.Header().Set("ETag", rendered.ETag)
+	w.Header().Set("Last-Modified", rendered.ModifiedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	if configNotModified(r, rendered.ETag, rendered.ModifiedAt) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	w.Write(rendered.Body)
+}
 
-	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
-	w.Write(configBytes)
+// configNotModified 判断条件请求是否命中：If-None-Match 优先，未携带时再看 If-Modified-Since。
+func configNotModified(r *http.Request, etag string, modifiedAt time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		return err == nil && !modifiedAt.Truncate(time.Second).After(since)
+	}
+	return false
 }
@@ -0,0 +1,622 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"my-stash-rule/internal/service"
+	"my-stash-rule/internal/store"
+)
+
+func TestStashProfilesAPICRUD(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: global"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: rule"}), http.StatusConflict)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "", "content": "mode: rule"}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "broken", "content": "mode: [rule"}), http.StatusBadRequest)
+
+	expectStatus(t, admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: direct"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "missing", "content": "mode: direct"}), http.StatusNotFound)
+
+	rec := admin.do(http.MethodGet, "/api/stash/profiles", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var resp struct {
+		Profiles    []store.StashProfile `json:"profiles"`
+		DefaultName string               `json:"default_name"`
+	}
+	decodeJSON(t, rec, &resp)
+	if resp.DefaultName != store.DefaultStashProfileName {
+		t.Errorf("default_name = %q", resp.DefaultName)
+	}
+	if len(resp.Profiles) != 2 || !resp.Profiles[0].IsDefault || resp.Profiles[1].Name != "mobile" {
+		t.Fatalf("unexpected profiles: %+v", resp.Profiles)
+	}
+	if resp.Profiles[1].Content != "mode: direct\n" {
+		t.Errorf("mobile content = %q", resp.Profiles[1].Content)
+	}
+
+	if err := store.CreateAdminUser("viewer", "viewer-pass", store.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	viewer := login(t, mux, "viewer", "viewer-pass")
+	expectStatus(t, viewer.do(http.MethodGet, "/api/stash/profiles", nil), http.StatusOK)
+	expectStatus(t, viewer.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: rule"}), http.StatusForbidden)
+}
+
+func TestStashProfileHistoryDiffRollback(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: global\nlog-level: info"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: rule\nlog-level: info"}), http.StatusOK)
+	// 内容未变化时不产生新版本。
+	expectStatus(t, admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: rule\nlog-level: info\n"}), http.StatusOK)
+
+	rec := admin.do(http.MethodGet, "/api/stash/profiles/history?name=mobile", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var history struct {
+		Revisions []stashProfileRevisionSummary `json:"revisions"`
+	}
+	decodeJSON(t, rec, &history)
+	if len(history.Revisions) != 2 || history.Revisions[0].Version != 2 || history.Revisions[0].Author != "admin" {
+		t.Fatalf("unexpected history: %+v", history.Revisions)
+	}
+
+	rec = admin.do(http.MethodGet, "/api/stash/profiles/diff?name=mobile&from=1&to=2", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var diff struct {
+		Lines   []service.DiffLine `json:"lines"`
+		Added   int                `json:"added"`
+		Removed int                `json:"removed"`
+	}
+	decodeJSON(t, rec, &diff)
+	want := []service.DiffLine{
+		{Op: service.DiffDelete, Text: "mode: global"},
+		{Op: service.DiffInsert, Text: "mode: rule"},
+		{Op: service.DiffEqual, Text: "log-level: info"},
+	}
+	if diff.Added != 1 || diff.Removed != 1 || len(diff.Lines) != len(want) {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	for i := range want {
+		if diff.Lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, diff.Lines[i], want[i])
+		}
+	}
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles/rollback", map[string]interface{}{"name": "mobile", "version": 1}), http.StatusOK)
+	if content, _ := store.GetStashProfileYAML("mobile"); content != "mode: global\nlog-level: info\n" {
+		t.Errorf("content after rollback = %q", content)
+	}
+	revision, err := store.GetStashProfileRevision("mobile", 3)
+	if err != nil || revision.Note != "rollback to v1" {
+		t.Errorf("rollback revision = %+v, %v", revision, err)
+	}
+
+	expectStatus(t, admin.do(http.MethodGet, "/api/stash/profiles/history?name=mobile&version=9", nil), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodGet, "/api/stash/profiles/history?name=missing", nil), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles/rollback", map[string]interface{}{"name": "mobile", "version": 0}), http.StatusBadRequest)
+
+	if err := store.CreateAdminUser("viewer", "viewer-pass", store.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	viewer := login(t, mux, "viewer", "viewer-pass")
+	expectStatus(t, viewer.do(http.MethodGet, "/api/stash/profiles/diff?name=mobile&from=1", nil), http.StatusOK)
+	expectStatus(t, viewer.do(http.MethodPost, "/api/stash/profiles/rollback", map[string]interface{}{"name": "mobile", "version": 2}), http.StatusForbidden)
+}
+
+func TestStashProfileDeleteAndRename(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: global"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "desktop", "content": "mode: rule"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "mobile"}), http.StatusOK)
+
+	expectStatus(t, admin.do(http.MethodDelete, "/api/stash/profiles", map[string]string{"name": "default"}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodDelete, "/api/stash/profiles", map[string]string{"name": "missing"}), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles/rename", map[string]string{"name": "default", "new_name": "base"}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles/rename", map[string]string{"name": "mobile", "new_name": "desktop"}), http.StatusConflict)
+
+	// 重命名后订阅用户与历史版本跟随迁移。
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles/rename", map[string]string{"name": "mobile", "new_name": "phone"}), http.StatusOK)
+	if profile, _ := store.GetSubscriberProfile("alice"); profile != "phone" {
+		t.Errorf("alice profile after rename = %q", profile)
+	}
+	if revisions, err := store.ListStashProfileRevisions("phone"); err != nil || len(revisions) != 1 {
+		t.Errorf("history after rename = %+v, %v", revisions, err)
+	}
+	if exists, _ := store.ValidateStashProfileExists("mobile"); exists {
+		t.Error("old profile name should be gone")
+	}
+
+	// 仍被使用时拒绝删除，并返回绑定的订阅用户。
+	rec := admin.do(http.MethodDelete, "/api/stash/profiles", map[string]string{"name": "phone"})
+	expectStatus(t, rec, http.StatusConflict)
+	var conflict struct {
+		Subscribers []string `json:"subscribers"`
+	}
+	decodeJSON(t, rec, &conflict)
+	if len(conflict.Subscribers) != 1 || conflict.Subscribers[0] != "alice" {
+		t.Errorf("conflict subscribers = %v", conflict.Subscribers)
+	}
+	expectStatus(t, admin.do(http.MethodDelete, "/api/stash/profiles", map[string]string{"name": "phone", "reassign_to": "missing"}), http.StatusNotFound)
+
+	expectStatus(t, admin.do(http.MethodDelete, "/api/stash/profiles", map[string]string{"name": "phone", "reassign_to": "desktop"}), http.StatusOK)
+	if profile, _ := store.GetSubscriberProfile("alice"); profile != "desktop" {
+		t.Errorf("alice profile after reassign = %q", profile)
+	}
+	if exists, _ := store.ValidateStashProfileExists("phone"); exists {
+		t.Error("deleted profile still exists")
+	}
+
+	if err := store.CreateAdminUser("viewer", "viewer-pass", store.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	viewer := login(t, mux, "viewer", "viewer-pass")
+	expectStatus(t, viewer.do(http.MethodDelete, "/api/stash/profiles", map[string]string{"name": "desktop", "reassign_to": "default"}), http.StatusForbidden)
+	expectStatus(t, viewer.do(http.MethodPost, "/api/stash/profiles/rename", map[string]string{"name": "desktop", "new_name": "pc"}), http.StatusForbidden)
+}
+
+// newFakeUpstream 模拟机场订阅地址，返回 base64 编码的节点 URI 列表。
+func newFakeUpstream(t *testing.T, uris ...string) (*httptest.Server, *int32) {
+	t.Helper()
+	var hits int32
+	body := base64.StdEncoding.EncodeToString([]byte(strings.Join(uris, "\n")))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+type generatedConfig struct {
+	Mode    string `yaml:"mode"`
+	Proxies []struct {
+		Name   string `yaml:"name"`
+		Type   string `yaml:"type"`
+		Server string `yaml:"server"`
+	} `yaml:"proxies"`
+	ProxyGroups []struct {
+		Name    string   `yaml:"name"`
+		Proxies []string `yaml:"proxies"`
+	} `yaml:"proxy-groups"`
+}
+
+func parseGeneratedConfig(t *testing.T, rec *httptest.ResponseRecorder) generatedConfig {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/yaml") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var cfg generatedConfig
+	if err := yaml.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatalf("parse generated config: %v\n%s", err, rec.Body.String())
+	}
+	return cfg
+}
+
+func TestGetConfigEndToEnd(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, hits := newFakeUpstream(t,
+		"trojan://secret@hk1.example.com:443?sni=hk1.example.com#HK%2001",
+		"trojan://secret@jp1.example.com:8443#JP%2001",
+	)
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "mobile", "content": "mode: global"}), http.StatusOK)
+
+	rec := admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "mobile"})
+	expectStatus(t, rec, http.StatusOK)
+	var created struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &created)
+
+	anon := &testClient{t: t, mux: mux}
+	expectStatus(t, anon.do(http.MethodGet, "/", nil), http.StatusUnauthorized)
+	expectStatus(t, anon.do(http.MethodGet, "/?token=invalid", nil), http.StatusUnauthorized)
+
+	// 订阅用户：首次请求时拉取上游并写入缓存，应用绑定的模板。
+	rec = anon.do(http.MethodGet, "/?token="+created.Token, nil)
+	expectStatus(t, rec, http.StatusOK)
+	cfg := parseGeneratedConfig(t, rec)
+	if cfg.Mode != "global" {
+		t.Errorf("mode = %q, want global from subscriber profile", cfg.Mode)
+	}
+	if len(cfg.Proxies) != 2 || cfg.Proxies[0].Name != "HK 01" || cfg.Proxies[0].Type != "trojan" || cfg.Proxies[1].Server != "jp1.example.com" {
+		t.Fatalf("unexpected proxies: %+v", cfg.Proxies)
+	}
+	hkGroup := false
+	for _, group := range cfg.ProxyGroups {
+		if group.Name == "香港节点" && len(group.Proxies) == 1 && group.Proxies[0] == "HK 01" {
+			hkGroup = true
+		}
+	}
+	if !hkGroup {
+		t.Errorf("expected HK 01 in region group, got %+v", cfg.ProxyGroups)
+	}
+
+	stats, err := store.GetSubscriberFetchStats("alice")
+	if err != nil || stats.Count != 1 {
+		t.Errorf("fetch stats = %+v, %v; want count 1", stats, err)
+	}
+
+	// 管理员 session：使用默认模板，并命中节点缓存。
+	rec = admin.do(http.MethodGet, "/", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if cfg := parseGeneratedConfig(t, rec); cfg.Mode != "rule" || len(cfg.Proxies) != 2 {
+		t.Errorf("admin config: mode = %q, %d proxies", cfg.Mode, len(cfg.Proxies))
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("upstream hits = %d, want 1 (second request should use cache)", n)
+	}
+
+	// 禁用后 token 立即失效。
+	if err := store.SetSubscriberDisabled("alice", true, "manual"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, anon.do(http.MethodGet, "/?token="+created.Token, nil), http.StatusUnauthorized)
+}
+
+func TestStashProfileExtendsResolvedAndConfig(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, _ := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "base-cn", "content": "extends: []\nmode: direct\nlog-level: debug"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "gaming", "content": "extends: [base-cn]\nmode: global"}), http.StatusOK)
+	rec := admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "base-cn", "content": "extends: gaming"})
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), "extends cycle") {
+		t.Errorf("expected cycle error, got %s", rec.Body.String())
+	}
+
+	rec = admin.do(http.MethodGet, "/api/stash/profiles/resolved?name=gaming", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var resolved struct {
+		Chain   []string `json:"chain"`
+		Content string   `json:"content"`
+	}
+	decodeJSON(t, rec, &resolved)
+	if strings.Join(resolved.Chain, ",") != "base-cn,gaming" {
+		t.Errorf("chain = %v", resolved.Chain)
+	}
+	var merged map[string]interface{}
+	if err := yaml.Unmarshal([]byte(resolved.Content), &merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged["mode"] != "global" || merged["log-level"] != "debug" || merged["extends"] != nil {
+		t.Errorf("unexpected resolved profile: %v", merged)
+	}
+	expectStatus(t, admin.do(http.MethodGet, "/api/stash/profiles/resolved?name=missing", nil), http.StatusNotFound)
+
+	rec = admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "gaming"})
+	expectStatus(t, rec, http.StatusOK)
+	var created struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &created)
+
+	anon := &testClient{t: t, mux: mux}
+	rec = anon.do(http.MethodGet, "/?token="+created.Token, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "extends") {
+		t.Error("generated config must not contain the extends directive")
+	}
+	var cfg struct {
+		Mode     string `yaml:"mode"`
+		LogLevel string `yaml:"log-level"`
+	}
+	if err := yaml.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Mode != "global" || cfg.LogLevel != "debug" {
+		t.Errorf("config mode = %q, log-level = %q", cfg.Mode, cfg.LogLevel)
+	}
+}
+
+func TestGetConfigWithoutSubscribeURLs(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+
+	rec := admin.do(http.MethodGet, "/", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "未配置订阅链接") {
+		t.Errorf("unexpected body: %s", rec.Body.String())
+	}
+}
+
+func TestTemplatedStashProfileConfig(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, _ := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001\ntrojan://secret@jp1.example.com:443#JP%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+
+	rec := admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "broken", "content": "mode: {{ .Vars.mode"})
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), "invalid template") {
+		t.Errorf("expected template error, got %s", rec.Body.String())
+	}
+
+	content := `mixed-port: {{ .Vars.port | default "7890" }}
+{{- if .HasTag "vip" }}
+mode: global
+{{- end }}
+log-level: {{ if gt .NodeCount 1 }}debug{{ else }}info{{ end }}
+comment: {{ .Username }}-{{ join "-" .Regions }}
+`
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "personal", "content": content}), http.StatusOK)
+
+	rec = admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "personal"})
+	expectStatus(t, rec, http.StatusOK)
+	var created struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &created)
+
+	rec = admin.do(http.MethodPost, "/api/subscribers/attributes", map[string]interface{}{"username": "alice", "vars": map[string]string{"bad-name": "x"}})
+	expectStatus(t, rec, http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers/attributes", map[string]interface{}{"username": "nobody"}), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers/attributes", map[string]interface{}{
+		"username": "alice",
+		"tags":     []string{"vip"},
+		"vars":     map[string]string{"port": "7891"},
+	}), http.StatusOK)
+
+	anon := &testClient{t: t, mux: mux}
+	rec = anon.do(http.MethodGet, "/?token="+created.Token, nil)
+	expectStatus(t, rec, http.StatusOK)
+	var cfg struct {
+		MixedPort int    `yaml:"mixed-port"`
+		Mode      string `yaml:"mode"`
+		LogLevel  string `yaml:"log-level"`
+		Comment   string `yaml:"comment"`
+	}
+	if err := yaml.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MixedPort != 7891 || cfg.Mode != "global" || cfg.LogLevel != "debug" || cfg.Comment != "alice-HK-JP" {
+		t.Errorf("unexpected rendered config: %+v", cfg)
+	}
+}
+
+func TestStashProfileSaveReturnsValidationWarnings(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, _ := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+
+	type saveResponse struct {
+		Warnings []service.ConfigWarning `json:"warnings"`
+	}
+
+	rec := admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "clean", "content": "rules+: [\"MATCH,Final\"]"})
+	expectStatus(t, rec, http.StatusOK)
+	var clean saveResponse
+	decodeJSON(t, rec, &clean)
+	if clean.Warnings == nil || len(clean.Warnings) != 0 {
+		t.Errorf("expected empty warnings, got %+v", clean.Warnings)
+	}
+
+	rec = admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": "clean", "content": "mode: fast\nrules+: [\"DOMAIN,example.com,Nowhere\"]"})
+	expectStatus(t, rec, http.StatusOK)
+	var dirty saveResponse
+	decodeJSON(t, rec, &dirty)
+	if len(dirty.Warnings) != 2 || dirty.Warnings[0].Path != "mode" || dirty.Warnings[1].Path != "rules[0]" {
+		t.Errorf("unexpected warnings: %+v", dirty.Warnings)
+	}
+
+	rec = admin.do(http.MethodGet, "/api/stash/profiles/resolved?name=clean", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var resolved saveResponse
+	decodeJSON(t, rec, &resolved)
+	if len(resolved.Warnings) != 2 {
+		t.Errorf("resolved warnings = %+v", resolved.Warnings)
+	}
+}
+
+func TestGetConfigPrunesDanglingReferences(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, _ := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+
+	content := `proxy-groups+:
+  - {name: Gaming, type: select, proxies: [Removed Node]}
+rules+:
+  - "DOMAIN,steam.com,Gaming"
+  - "DOMAIN,example.com,Deleted Group"
+`
+	rec := admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": store.DefaultStashProfileName, "content": content})
+	expectStatus(t, rec, http.StatusOK)
+	var saved struct {
+		Adjustments []service.ConfigChange `json:"adjustments"`
+	}
+	decodeJSON(t, rec, &saved)
+	if len(saved.Adjustments) != 3 {
+		t.Errorf("adjustments = %+v", saved.Adjustments)
+	}
+
+	rec = admin.do(http.MethodGet, "/", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var cfg struct {
+		ProxyGroups []struct {
+			Name    string   `yaml:"name"`
+			Proxies []string `yaml:"proxies"`
+		} `yaml:"proxy-groups"`
+		Rules []string `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	last := cfg.ProxyGroups[len(cfg.ProxyGroups)-1]
+	if last.Name != "Gaming" || strings.Join(last.Proxies, ",") != "DIRECT" {
+		t.Errorf("Gaming group = %+v", last)
+	}
+	if strings.Join(cfg.Rules, "|") != "DOMAIN,steam.com,Gaming" {
+		t.Errorf("rules = %v", cfg.Rules)
+	}
+}
+
+func TestPreviewAPI(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, _ := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/stash/profiles", map[string]string{"name": "personal", "content": "mode: rule\n"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "personal"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers/attributes", map[string]interface{}{
+		"username": "alice",
+		"vars":     map[string]string{"level": "debug"},
+	}), http.StatusOK)
+
+	type previewResponse struct {
+		Subscriber     string              `json:"subscriber"`
+		Profile        string              `json:"profile"`
+		Chain          []string            `json:"chain"`
+		NodeCount      int                 `json:"node_count"`
+		Content        string              `json:"content"`
+		Current        string              `json:"current"`
+		CurrentProfile string              `json:"current_profile"`
+		Diff           *service.DiffResult `json:"diff"`
+	}
+
+	// 未保存的模板内容按订阅用户的变量渲染，并与当前下发的配置对比
+	rec := admin.do(http.MethodPost, "/api/preview", map[string]string{
+		"subscriber": "alice",
+		"profile":    "personal",
+		"content":    "mode: global\nlog-level: {{ .Vars.level }}\n",
+	})
+	expectStatus(t, rec, http.StatusOK)
+	var preview previewResponse
+	decodeJSON(t, rec, &preview)
+	if preview.Subscriber != "alice" || preview.Profile != "personal" || preview.CurrentProfile != "personal" || preview.NodeCount != 1 {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+	if !strings.Contains(preview.Content, "mode: global") || !strings.Contains(preview.Content, "log-level: debug") {
+		t.Errorf("preview content = %s", preview.Content)
+	}
+	if !strings.Contains(preview.Current, "mode: rule") {
+		t.Errorf("current content = %s", preview.Current)
+	}
+	if preview.Diff == nil || preview.Diff.Added == 0 || preview.Diff.Removed == 0 {
+		t.Errorf("diff = %+v", preview.Diff)
+	}
+
+	// 草稿不会被保存，当前下发的配置保持不变
+	rec = admin.do(http.MethodGet, "/api/preview?subscriber=alice&format=json", nil)
+	expectStatus(t, rec, http.StatusOK)
+	decodeJSON(t, rec, &preview)
+	if !strings.HasPrefix(strings.TrimSpace(preview.Content), "{") || !strings.Contains(preview.Content, `"mode": "rule"`) {
+		t.Errorf("json preview = %s", preview.Content)
+	}
+	if preview.Diff == nil || preview.Diff.Added != 0 || preview.Diff.Removed != 0 {
+		t.Errorf("expected identical preview, diff = %+v", preview.Diff)
+	}
+
+	expectStatus(t, admin.do(http.MethodGet, "/api/preview?subscriber=nobody", nil), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodGet, "/api/preview?profile=missing", nil), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodGet, "/api/preview?format=toml", nil), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPost, "/api/preview", map[string]string{"profile": "personal", "content": "mode: [broken"}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPost, "/api/preview", map[string]string{"content": "mode: global"}), http.StatusBadRequest)
+}
+
+func TestGetConfigConditionalRequests(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, hits := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+
+	rec := admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice"})
+	expectStatus(t, rec, http.StatusOK)
+	var created struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &created)
+
+	fetch := func(query string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/?token="+created.Token+query, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := fetch("", nil)
+	expectStatus(t, first, http.StatusOK)
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("missing validators: %v", first.Header())
+	}
+	fetchesAfterFirst := atomic.LoadInt32(hits)
+
+	rec = fetch("", http.Header{"If-None-Match": {etag}})
+	expectStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("unexpected 304 response: %q %v", rec.Body.String(), rec.Header())
+	}
+	expectStatus(t, fetch("", http.Header{"If-None-Match": {`"other", W/` + etag}}), http.StatusNotModified)
+	expectStatus(t, fetch("", http.Header{"If-Modified-Since": {lastModified}}), http.StatusNotModified)
+	expectStatus(t, fetch("", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}}), http.StatusOK)
+	if got := atomic.LoadInt32(hits); got != fetchesAfterFirst {
+		t.Errorf("upstream fetched again: %d -> %d", fetchesAfterFirst, got)
+	}
+
+	rec = fetch("&format=json", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("ETag") == etag || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("unexpected json response headers: %v", rec.Header())
+	}
+	expectStatus(t, fetch("&format=toml", nil), http.StatusBadRequest)
+
+	// 修改模板或订阅用户后输出变化，旧 ETag 不再命中
+	expectStatus(t, admin.do(http.MethodPut, "/api/stash/profiles", map[string]string{"name": store.DefaultStashProfileName, "content": "mode: global\n"}), http.StatusOK)
+	rec = fetch("", http.Header{"If-None-Match": {etag}})
+	expectStatus(t, rec, http.StatusOK)
+	profileETag := rec.Header().Get("ETag")
+	if profileETag == etag || !strings.Contains(rec.Body.String(), "mode: global") {
+		t.Errorf("expected new config after profile change, etag %s", profileETag)
+	}
+
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "alice", "override": "mixed-port: 7891"}), http.StatusOK)
+	rec = fetch("", http.Header{"If-None-Match": {profileETag}})
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "mixed-port: 7891") {
+		t.Errorf("expected override in config, got %s", rec.Body.String())
+	}
+}
+
+func TestWriteProfileErrorStatus(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("delete: %w", store.ErrStashProfileConflict), http.StatusConflict},
+		{fmt.Errorf("reassign target x: %w", store.ErrStashProfileNotFound), http.StatusNotFound},
+		{errors.New("stash profile already exists"), http.StatusConflict},
+		{errors.New("invalid yaml"), http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		writeProfileError(rec, c.err)
+		if rec.Code != c.want {
+			t.Errorf("%v: status %d, want %d", c.err, rec.Code, c.want)
+		}
+	}
+}
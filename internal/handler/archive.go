@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-stash-rule/internal/service"
+)
+
+// maxArchiveSize 导入备份的最大字节数。
+const maxArchiveSize = 10 << 20
+
+// HandleAdminExportAPI 导出服务状态备份（仅 owner）
+// GET ?format=json|yaml&include_tokens=true|false
+func HandleAdminExportAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	includeTokens := true
+	if raw := r.URL.Query().Get("include_tokens"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, `{"error":"invalid include_tokens"}`, http.StatusBadRequest)
+			return
+		}
+		includeTokens = v
+	}
+
+	archive, err := service.ExportArchive(includeTokens)
+	if err != nil {
+		log.Printf("Failed to export archive: %v", err)
+		http.Error(w, `{"error":"failed to export"}`, http.StatusInternalServerError)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	data, contentType, err := service.EncodeArchive(archive, format)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	ext := "json"
+	if contentType != "application/json" {
+		ext = "yaml"
+	}
+
+	if admin := currentAdmin(r); admin != nil {
+		log.Printf("Admin %s exported archive (tokens: %t, ip: %s)", admin.Username, includeTokens, clientIP(r))
+	}
+
+	filename := fmt.Sprintf("stash-rule-backup-%s.%s", time.Unix(archive.ExportedAt, 0).UTC().Format("20060102-150405"), ext)
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// HandleAdminImportAPI 导入服务状态备份（仅 owner）
+// POST ?mode=merge|replace&dry_run=true|false，请求体为 JSON 或 YAML 格式的备份
+func HandleAdminImportAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	mode := service.ImportMode(strings.ToLower(q.Get("mode")))
+	dryRun := false
+	if raw := q.Get("dry_run"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, `{"error":"invalid dry_run"}`, http.StatusBadRequest)
+			return
+		}
+		dryRun = v
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxArchiveSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, `{"error":"archive too large"}`, http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	archive, err := service.DecodeArchive(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := service.ImportArchive(archive, mode, dryRun)
+	if err != nil {
+		if report == nil {
+			// 校验失败，未写入任何数据。
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("Archive import failed partway: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  err.Error(),
+			"report": report,
+		})
+		return
+	}
+
+	if admin := currentAdmin(r); admin != nil && !dryRun {
+		log.Printf("Admin %s imported archive (mode: %s, ip: %s)", admin.Username, report.Mode, clientIP(r))
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// writeJSONError 输出 {"error": "..."}，错误信息经 JSON 转义。
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// HandleAdminBackupsAPI 定时备份管理（仅 owner）
+// GET: 列出已保存的备份
+// POST: 立即执行一次备份
+func HandleAdminBackupsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := service.ListBackups()
+		if errors.Is(err, service.ErrBackupNotConfigured) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"enabled": false,
+				"backups": []service.BackupInfo{},
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to list backups: %v", err)
+			http.Error(w, `{"error":"failed to list backups"}`, http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled": true,
+			"backups": backups,
+		})
+		return
+	case http.MethodPost:
+		info, err := service.RunBackup()
+		if errors.Is(err, service.ErrBackupNotConfigured) {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			log.Printf("Manual backup failed: %v", err)
+			http.Error(w, `{"error":"backup failed"}`, http.StatusBadGateway)
+			return
+		}
+		if admin := currentAdmin(r); admin != nil {
+			log.Printf("Admin %s created backup %s (ip: %s)", admin.Username, info.Name, clientIP(r))
+		}
+		_ = json.NewEncoder(w).Encode(info)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+// HandleAdminBackupRestoreAPI 从备份恢复（仅 owner）
+// POST: {"name": "...", "mode": "merge|replace", "dry_run": true}，name 为空时使用最新备份
+func HandleAdminBackupRestoreAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name   string             `json:"name"`
+		Mode   service.ImportMode `json:"mode"`
+		DryRun bool               `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	name, report, err := service.RestoreBackup(strings.TrimSpace(req.Name), req.Mode, req.DryRun)
+	if err != nil {
+		if report == nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("Restore from backup %s failed partway: %v", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  err.Error(),
+			"name":   name,
+			"report": report,
+		})
+		return
+	}
+
+	if admin := currentAdmin(r); admin != nil && !req.DryRun {
+		log.Printf("Admin %s restored backup %s (mode: %s, ip: %s)", admin.Username, name, report.Mode, clientIP(r))
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":   name,
+		"report": report,
+	})
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"my-stash-rule/internal/service"
+	"my-stash-rule/internal/store"
+)
+
+// seedArchiveState 准备一份包含订阅链接、模板和订阅用户的状态。
+func seedArchiveState(t *testing.T) (aliceToken string) {
+	t.Helper()
+	if err := store.SaveSubscribeUrls([]string{"https://a.example.com/sub", "https://b.example.com/sub"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateStashProfile("mobile", "mode: global", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	aliceToken, err := store.AddSubscriber("alice", "mobile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddSubscriber("bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetSubscriberDisabled("bob", true, "leak"); err != nil {
+		t.Fatal(err)
+	}
+	return aliceToken
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	mux := newTestMux(t)
+	aliceToken := seedArchiveState(t)
+	admin := login(t, mux, "admin", "admin")
+
+	rec := admin.do(http.MethodGet, "/api/admin/export", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, ".json") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	exported := rec.Body.Bytes()
+
+	// 导入到一个全新的实例。
+	mux = newTestMux(t)
+	admin = login(t, mux, "admin", "admin")
+	rec = admin.doRaw(http.MethodPost, "/api/admin/import", "application/json", exported)
+	expectStatus(t, rec, http.StatusOK)
+
+	var report service.ImportReport
+	decodeJSON(t, rec, &report)
+	if report.Mode != service.ImportModeMerge || report.DryRun {
+		t.Errorf("unexpected report header: %+v", report)
+	}
+	if len(report.Sources.Created) != 2 || len(report.Profiles.Created) != 1 || len(report.Subscribers.Created) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if username, _ := store.ValidateAPIToken(aliceToken); username != "alice" {
+		t.Errorf("alice token not restored, got %q", username)
+	}
+	if profile, _ := store.GetSubscriberProfile("alice"); profile != "mobile" {
+		t.Errorf("alice profile = %q", profile)
+	}
+	if disabled, _ := store.IsSubscriberDisabled("bob"); !disabled {
+		t.Error("bob should stay disabled")
+	}
+	if urls, _ := store.GetStoredSubscribeUrls(); len(urls) != 2 {
+		t.Errorf("urls = %v", urls)
+	}
+
+	// 再次导入同一份备份不产生任何变更。
+	rec = admin.doRaw(http.MethodPost, "/api/admin/import", "application/json", exported)
+	expectStatus(t, rec, http.StatusOK)
+	decodeJSON(t, rec, &report)
+	if len(report.Subscribers.Created)+len(report.Subscribers.Updated)+len(report.Profiles.Updated) != 0 {
+		t.Errorf("re-import should be a no-op: %+v", report)
+	}
+}
+
+func TestExportYAMLWithoutTokens(t *testing.T) {
+	mux := newTestMux(t)
+	aliceToken := seedArchiveState(t)
+	admin := login(t, mux, "admin", "admin")
+
+	rec := admin.do(http.MethodGet, "/api/admin/export?format=yaml&include_tokens=false", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/yaml") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if strings.Contains(rec.Body.String(), aliceToken) {
+		t.Fatal("export must not contain tokens when include_tokens=false")
+	}
+
+	archive, err := service.DecodeArchive(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Subscribers) != 2 || archive.Subscribers[0].Token != "" {
+		t.Errorf("unexpected subscribers: %+v", archive.Subscribers)
+	}
+
+	// 导入到新实例时重新生成 token，并给出提示。
+	mux = newTestMux(t)
+	admin = login(t, mux, "admin", "admin")
+	rec = admin.doRaw(http.MethodPost, "/api/admin/import", "application/yaml", rec.Body.Bytes())
+	expectStatus(t, rec, http.StatusOK)
+	var report service.ImportReport
+	decodeJSON(t, rec, &report)
+	if len(report.Warnings) != 2 {
+		t.Errorf("expected token warnings, got %v", report.Warnings)
+	}
+	if token, _ := store.GetAPIToken("alice"); token == "" || token == aliceToken {
+		t.Errorf("expected a freshly generated token, got %q", token)
+	}
+}
+
+func TestImportDryRunAndReplace(t *testing.T) {
+	mux := newTestMux(t)
+	seedArchiveState(t)
+	admin := login(t, mux, "admin", "admin")
+
+	archive := map[string]interface{}{
+		"version": service.ArchiveVersion,
+		"sources": []string{"https://c.example.com/sub"},
+		"profiles": []map[string]string{
+			{"name": "default", "content": "{}"},
+			{"name": "desktop", "content": "mode: rule"},
+		},
+		"subscribers": []map[string]interface{}{
+			{"username": "carol", "token": "carol-token", "profile_name": "desktop"},
+			{"username": "alice", "profile_name": "desktop"},
+		},
+	}
+	body, _ := json.Marshal(archive)
+
+	rec := admin.doRaw(http.MethodPost, "/api/admin/import?mode=replace&dry_run=true", "application/json", body)
+	expectStatus(t, rec, http.StatusOK)
+	var report service.ImportReport
+	decodeJSON(t, rec, &report)
+	if !report.DryRun || len(report.Subscribers.Deleted) != 1 || report.Subscribers.Deleted[0] != "bob" {
+		t.Errorf("unexpected dry-run report: %+v", report)
+	}
+	if len(report.Profiles.Deleted) != 1 || report.Profiles.Deleted[0] != "mobile" {
+		t.Errorf("unexpected profile changes: %+v", report.Profiles)
+	}
+	if subscribers, _ := store.ListSubscribers(); len(subscribers) != 2 {
+		t.Fatalf("dry run must not write, subscribers = %+v", subscribers)
+	}
+
+	rec = admin.doRaw(http.MethodPost, "/api/admin/import?mode=replace", "application/json", body)
+	expectStatus(t, rec, http.StatusOK)
+
+	subscribers, _ := store.ListSubscribers()
+	if len(subscribers) != 2 || subscribers[0].Username != "alice" || subscribers[1].Username != "carol" {
+		t.Fatalf("subscribers after replace = %+v", subscribers)
+	}
+	if subscribers[0].ProfileName != "desktop" || subscribers[0].Token == "" {
+		t.Errorf("alice should keep her token and move to desktop: %+v", subscribers[0])
+	}
+	if username, _ := store.ValidateAPIToken("carol-token"); username != "carol" {
+		t.Errorf("carol token = %q", username)
+	}
+	if exists, _ := store.ValidateStashProfileExists("mobile"); exists {
+		t.Error("mobile profile should be removed by replace import")
+	}
+	if urls, _ := store.GetStoredSubscribeUrls(); len(urls) != 1 || urls[0] != "https://c.example.com/sub" {
+		t.Errorf("urls = %v", urls)
+	}
+}
+
+func TestImportRejectsInvalidArchive(t *testing.T) {
+	mux := newTestMux(t)
+	seedArchiveState(t)
+	admin := login(t, mux, "admin", "admin")
+
+	cases := map[string]string{
+		"future version":  `{"version": 99}`,
+		"missing version": `{"sources": []}`,
+		"unknown profile": `{"version": 1, "subscribers": [{"username": "dave", "profile_name": "missing"}]}`,
+		"invalid yaml":    `{"version": 1, "profiles": [{"name": "x", "content": "a: [b"}]}`,
+		"token conflict":  `{"version": 1, "subscribers": [{"username": "dave", "token": "t1"}, {"username": "erin", "token": "t1"}]}`,
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := admin.doRaw(http.MethodPost, "/api/admin/import", "application/json", []byte(body))
+			expectStatus(t, rec, http.StatusBadRequest)
+		})
+	}
+	expectStatus(t, admin.doRaw(http.MethodPost, "/api/admin/import?mode=overwrite", "application/json", []byte(`{"version": 1}`)), http.StatusBadRequest)
+
+	if subscribers, _ := store.ListSubscribers(); len(subscribers) != 2 {
+		t.Errorf("rejected imports must not write, subscribers = %+v", subscribers)
+	}
+}
+
+func TestArchiveEndpointsRequireOwner(t *testing.T) {
+	mux := newTestMux(t)
+	if err := store.CreateAdminUser("editor", "editor-pass", store.AdminRoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	editor := login(t, mux, "editor", "editor-pass")
+
+	expectStatus(t, editor.do(http.MethodGet, "/api/admin/export", nil), http.StatusForbidden)
+	expectStatus(t, editor.doRaw(http.MethodPost, "/api/admin/import", "application/json", []byte(`{"version": 1}`)), http.StatusForbidden)
+}
+
+func TestExportRejectsUnknownFormatWithJSONError(t *testing.T) {
+	mux := newTestMux(t)
+	owner := login(t, mux, "admin", "admin")
+
+	// 错误信息原样回显格式参数，其中的引号不能破坏 JSON。
+	rec := owner.do(http.MethodGet, `/api/admin/export?format=x%22y`, nil)
+	expectStatus(t, rec, http.StatusBadRequest)
+	var resp struct {
+		Error string `json:"error"`
+	}
+	decodeJSON(t, rec, &resp)
+	if !strings.Contains(resp.Error, `x"y`) {
+		t.Errorf("error = %q, want the requested format", resp.Error)
+	}
+}
@@ -2,59 +2,135 @@ package handler
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"text/template"
 	"time"
 
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/service"
 	"my-stash-rule/internal/store"
 )
 
+// oidcStateCookie 绑定授权请求与发起登录的浏览器，防止登录 CSRF。
+const oidcStateCookie = "oidc_state"
+
+type loginPageData struct {
+	OIDCEnabled       bool
+	LocalLoginEnabled bool
+	Error             string
+}
+
+func renderLoginPage(w http.ResponseWriter, status int, errMsg string) {
+	tmpl, err := template.ParseFS(TemplatesFS, "templates/login.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, loginPageData{
+		OIDCEnabled:       config.GetOIDCConfig().Enabled(),
+		LocalLoginEnabled: config.GetLocalLoginEnabled(),
+		Error:             errMsg,
+	})
+}
+
 // HandleLogin 处理登录请求
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFS(TemplatesFS, "templates/login.html")
-		if err != nil {
-			http.Error(w, "Failed to load template", http.StatusInternalServerError)
-			return
-		}
-		tmpl.Execute(w, nil)
+		renderLoginPage(w, http.StatusOK, "")
 		return
 	}
 
 	if r.Method == http.MethodPost {
+		if !config.GetLocalLoginEnabled() {
+			http.Error(w, `{"error": "本地账号登录已关闭，请使用单点登录"}`, http.StatusForbidden)
+			return
+		}
+
 		var req struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
+			Code     string `json:"code"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, `{"error": "invalid request"}`, http.StatusBadRequest)
 			return
 		}
 
+		ip := clientIP(r)
+		lockedFor, err := store.GetLoginLockout(req.Username, ip)
+		if err != nil {
+			http.Error(w, `{"error": "internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		if lockedFor > 0 {
+			writeTooManyRequests(w, lockedFor)
+			return
+		}
+
+		// recordFailure 记录失败次数，触发锁定时直接返回 429。
+		recordFailure := func() bool {
+			lockout := config.GetLoginLockoutDuration()
+			locked, err := store.RecordLoginFailure(req.Username, ip, config.GetLoginMaxFailures(), lockout)
+			if err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+			if locked {
+				log.Printf("Admin login locked for %s after repeated failures (ip: %s)", req.Username, ip)
+				writeTooManyRequests(w, lockout)
+			}
+			return locked
+		}
+
 		valid, err := store.AuthenticateAdmin(req.Username, req.Password)
 		if err != nil {
 			http.Error(w, `{"error": "internal error"}`, http.StatusInternalServerError)
 			return
 		}
 		if !valid {
+			if recordFailure() {
+				return
+			}
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(`{"error": "用户名或密码错误"}`))
 			return
 		}
 
-		token, err := store.CreateSession(req.Username)
+		totp, err := store.GetAdminTOTP(req.Username)
 		if err != nil {
-			http.Error(w, `{"error": "failed to create session"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error": "internal error"}`, http.StatusInternalServerError)
 			return
 		}
+		if totp.Enabled {
+			if req.Code == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "请输入两步验证码", "totp_required": true}`))
+				return
+			}
+			ok, err := service.VerifyAdminSecondFactor(req.Username, req.Code)
+			if err != nil {
+				http.Error(w, `{"error": "internal error"}`, http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				if recordFailure() {
+					return
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "两步验证码错误", "totp_required": true}`))
+				return
+			}
+		}
+		if err := store.ClearLoginFailures(req.Username, ip); err != nil {
+			log.Printf("Failed to clear login failures: %v", err)
+		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_token",
-			Value:    token,
-			Expires:  time.Now().Add(24 * time.Hour),
-			HttpOnly: true,
-			Path:     "/",
-		})
+		if _, err := startSession(w, r, req.Username); err != nil {
+			http.Error(w, `{"error": "failed to create session"}`, http.StatusInternalServerError)
+			return
+		}
 
 		w.Write([]byte(`{"status": "ok"}`))
 		return
@@ -63,6 +139,115 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// HandleOIDCLogin 跳转到身份提供方发起单点登录
+func HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !config.GetOIDCConfig().Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, state, err := service.BeginOIDCLogin()
+	if err != nil {
+		log.Printf("Failed to start OIDC login: %v", err)
+		renderLoginPage(w, http.StatusBadGateway, "无法连接单点登录服务，请稍后再试")
+		return
+	}
+
+	// 回调是跨站顶级导航，state cookie 需使用 Lax 才能随回调请求带上。
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/login/oidc",
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback 处理身份提供方回调，校验通过后创建管理员 session
+func HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !config.GetOIDCConfig().Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/login/oidc",
+	})
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		log.Printf("OIDC provider returned error: %s %s", errCode, q.Get("error_description"))
+		renderLoginPage(w, http.StatusUnauthorized, "单点登录已取消或失败")
+		return
+	}
+
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		renderLoginPage(w, http.StatusBadRequest, "登录请求已失效，请重新发起单点登录")
+		return
+	}
+
+	identity, err := service.CompleteOIDCLogin(state, q.Get("code"))
+	if err != nil {
+		log.Printf("OIDC login failed (ip: %s): %v", clientIP(r), err)
+		renderLoginPage(w, http.StatusForbidden, "单点登录失败，或该账号无权访问后台")
+		return
+	}
+
+	if _, err := startSession(w, r, identity.Username); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin %s logged in via OIDC as %s", identity.Username, identity.Role)
+
+	// session cookie 为 SameSite=Strict，跨站回调后的直接重定向不会携带它；
+	// 这里返回一个页面，由同站导航进入后台。
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(`<!doctype html><meta charset="utf-8"><title>登录成功</title>` +
+		`<script>window.location.replace("/admin");</script>` +
+		`<noscript><a href="/admin">进入管理后台</a></noscript>`))
+}
+
+// startSession 为管理员创建 session 并写入 cookie，返回新 session 对应的 CSRF token。
+func startSession(w http.ResponseWriter, r *http.Request, username string) (string, error) {
+	token, err := store.CreateSession(username, clientIP(r), clientFingerprint(r))
+	if err != nil {
+		return "", err
+	}
+
+	maxLifetime := config.GetSessionMaxLifetime()
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		Expires:  time.Now().Add(maxLifetime),
+		MaxAge:   int(maxLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+	return csrfTokenForSession(token), nil
+}
+
 // HandleLogout 清理登录态
 func HandleLogout(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -70,12 +255,20 @@ func HandleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if cookie, err := r.Cookie("session_token"); err == nil && cookie.Value != "" {
+		if err := store.DeleteSession(cookie.Value); err != nil {
+			log.Printf("Failed to delete session: %v", err)
+		}
+	}
+
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    "",
 		Expires:  time.Unix(0, 0),
 		MaxAge:   -1,
 		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
 		Path:     "/",
 	})
 
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/service"
+	"my-stash-rule/internal/store"
+)
+
+func TestLoginSuccessSetsSessionCookie(t *testing.T) {
+	mux := newTestMux(t)
+
+	rec := (&testClient{t: t, mux: mux}).do(http.MethodPost, "/login", map[string]string{
+		"username": "admin",
+		"password": "admin",
+	})
+	expectStatus(t, rec, http.StatusOK)
+
+	var session *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			session = cookie
+		}
+	}
+	if session == nil || session.Value == "" {
+		t.Fatal("expected session_token cookie")
+	}
+	if !session.HttpOnly || session.SameSite != http.SameSiteStrictMode {
+		t.Errorf("session cookie should be HttpOnly and SameSite=Strict, got %+v", session)
+	}
+
+	username, err := store.ValidateSession(session.Value)
+	if err != nil || username != "admin" {
+		t.Errorf("ValidateSession = %q, %v; want admin", username, err)
+	}
+}
+
+func TestLoginRejectsWrongPassword(t *testing.T) {
+	mux := newTestMux(t)
+
+	rec := (&testClient{t: t, mux: mux}).do(http.MethodPost, "/login", map[string]string{
+		"username": "admin",
+		"password": "wrong",
+	})
+	expectStatus(t, rec, http.StatusUnauthorized)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			t.Fatal("failed login must not set a session cookie")
+		}
+	}
+}
+
+func TestLoginLocksAccountAfterRepeatedFailures(t *testing.T) {
+	mux := newTestMux(t)
+	t.Setenv("LOGIN_MAX_FAILURES", "2")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "10m")
+
+	anon := &testClient{t: t, mux: mux}
+	wrong := map[string]string{"username": "admin", "password": "wrong"}
+	expectStatus(t, anon.do(http.MethodPost, "/login", wrong), http.StatusUnauthorized)
+	expectStatus(t, anon.do(http.MethodPost, "/login", wrong), http.StatusTooManyRequests)
+
+	// 锁定期间即使密码正确也拒绝登录。
+	rec := anon.do(http.MethodPost, "/login", map[string]string{"username": "admin", "password": "admin"})
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
+
+func TestLoginRejectedWhenLocalLoginDisabled(t *testing.T) {
+	mux := newTestMux(t)
+	t.Setenv("LOCAL_LOGIN_ENABLED", "false")
+
+	rec := (&testClient{t: t, mux: mux}).do(http.MethodPost, "/login", map[string]string{
+		"username": "admin",
+		"password": "admin",
+	})
+	expectStatus(t, rec, http.StatusForbidden)
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+
+	// 缺少 CSRF token 的登出请求被拒绝，session 保持有效。
+	noCSRF := &testClient{t: t, mux: mux, session: admin.session, omitCSRF: true}
+	expectStatus(t, noCSRF.do(http.MethodPost, "/logout", nil), http.StatusForbidden)
+	expectStatus(t, admin.do(http.MethodGet, "/api/config", nil), http.StatusOK)
+
+	expectStatus(t, admin.do(http.MethodPost, "/logout", nil), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodGet, "/api/config", nil), http.StatusFound)
+}
+
+func TestLoginLockoutIsPerClientIP(t *testing.T) {
+	mux := newTestMux(t)
+	t.Setenv("LOGIN_MAX_FAILURES", "2")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "10m")
+
+	attacker := &testClient{t: t, mux: mux}
+	wrong := map[string]string{"username": "admin", "password": "wrong"}
+	expectStatus(t, attacker.do(http.MethodPost, "/login", wrong), http.StatusUnauthorized)
+	expectStatus(t, attacker.do(http.MethodPost, "/login", wrong), http.StatusTooManyRequests)
+
+	// 其他 IP 上的管理员仍可正常登录。
+	body := strings.NewReader(`{"username":"admin","password":"admin"}`)
+	req := httptest.NewRequest(http.MethodPost, "/login", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "198.51.100.7:40000"
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusOK)
+
+	// 攻击者所在 IP 仍处于锁定中。
+	expectStatus(t, attacker.do(http.MethodPost, "/login", map[string]string{"username": "admin", "password": "admin"}), http.StatusTooManyRequests)
+}
+
+// enableTOTP 为已登录的管理员完成两步验证绑定，返回密钥、绑定所用时间步之后的时刻与恢复码。
+func enableTOTP(t *testing.T, client *testClient, password string) (secret string, next time.Time, recoveryCodes []string) {
+	t.Helper()
+
+	rec := client.do(http.MethodPost, "/api/admin/profile", map[string]string{"action": "totp_setup", "current_password": password})
+	expectStatus(t, rec, http.StatusOK)
+	var enrollment service.TOTPEnrollment
+	decodeJSON(t, rec, &enrollment)
+
+	// 错误验证码不能完成绑定。
+	rec = client.do(http.MethodPost, "/api/admin/profile", map[string]string{"action": "totp_enable", "code": "000000"})
+	if rec.Code == http.StatusOK {
+		t.Fatal("totp_enable accepted a wrong code")
+	}
+
+	now := time.Now()
+	code, err := service.TOTPCode(enrollment.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = client.do(http.MethodPost, "/api/admin/profile", map[string]string{"action": "totp_enable", "code": code})
+	expectStatus(t, rec, http.StatusOK)
+	var resp struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeJSON(t, rec, &resp)
+	if len(resp.RecoveryCodes) == 0 {
+		t.Fatal("expected recovery codes after enabling totp")
+	}
+	return enrollment.Secret, now.Add(30 * time.Second), resp.RecoveryCodes
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	mux := newTestMux(t)
+	secret, next, _ := enableTOTP(t, login(t, mux, "admin", "admin"), "admin")
+
+	anon := &testClient{t: t, mux: mux}
+	loginWith := func(code string) *httptest.ResponseRecorder {
+		return anon.do(http.MethodPost, "/login", map[string]string{"username": "admin", "password": "admin", "code": code})
+	}
+
+	rec := loginWith("")
+	expectStatus(t, rec, http.StatusUnauthorized)
+	if !strings.Contains(rec.Body.String(), "totp_required") {
+		t.Errorf("expected totp_required, got %s", rec.Body.String())
+	}
+	expectStatus(t, loginWith("000000"), http.StatusUnauthorized)
+
+	// 绑定时用过的时间步之后的验证码可以登录，但同一验证码不能重放。
+	code, err := service.TOTPCode(secret, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, loginWith(code), http.StatusOK)
+	expectStatus(t, loginWith(code), http.StatusUnauthorized)
+}
+
+func TestLoginRecoveryCodeIsSingleUse(t *testing.T) {
+	mux := newTestMux(t)
+	_, _, recoveryCodes := enableTOTP(t, login(t, mux, "admin", "admin"), "admin")
+
+	anon := &testClient{t: t, mux: mux}
+	body := map[string]string{"username": "admin", "password": "admin", "code": recoveryCodes[0]}
+	expectStatus(t, anon.do(http.MethodPost, "/login", body), http.StatusOK)
+	expectStatus(t, anon.do(http.MethodPost, "/login", body), http.StatusUnauthorized)
+
+	totp, err := store.GetAdminTOTP("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totp.RecoveryCodesLeft != len(recoveryCodes)-1 {
+		t.Errorf("recovery codes left = %d, want %d", totp.RecoveryCodesLeft, len(recoveryCodes)-1)
+	}
+}
+
+func TestTOTPDisableAndRegenerateRequirePassword(t *testing.T) {
+	mux := newTestMux(t)
+	client := login(t, mux, "admin", "admin")
+	_, _, recoveryCodes := enableTOTP(t, client, "admin")
+
+	for _, action := range []string{"recovery_codes", "totp_disable"} {
+		rec := client.do(http.MethodPost, "/api/admin/profile", map[string]string{"action": action, "current_password": "wrong"})
+		expectStatus(t, rec, http.StatusUnauthorized)
+	}
+
+	rec := client.do(http.MethodPost, "/api/admin/profile", map[string]string{"action": "recovery_codes", "current_password": "admin"})
+	expectStatus(t, rec, http.StatusOK)
+	// 重新生成后旧恢复码作废。
+	anon := &testClient{t: t, mux: mux}
+	expectStatus(t, anon.do(http.MethodPost, "/login", map[string]string{"username": "admin", "password": "admin", "code": recoveryCodes[0]}), http.StatusUnauthorized)
+
+	expectStatus(t, client.do(http.MethodPost, "/api/admin/profile", map[string]string{"action": "totp_disable", "current_password": "admin"}), http.StatusOK)
+	totp, err := store.GetAdminTOTP("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totp.Enabled {
+		t.Error("totp should be disabled")
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"my-stash-rule/internal/service"
+	"my-stash-rule/internal/store"
+)
+
+// newTestMux 使用内存存储初始化 store，并用 RegisterRoutes 注册与 main.go 相同的路由。
+// 默认管理员为 admin / admin（owner）。
+func newTestMux(t *testing.T) *http.ServeMux {
+	t.Helper()
+	t.Setenv("LOCAL_LOGIN_ENABLED", "true")
+	t.Setenv("LEAK_DETECT_AUTO_DISABLE", "false")
+
+	store.UseBackend(store.NewMemoryBackend())
+	t.Cleanup(func() { _ = store.CloseStorage() })
+	service.InvalidateRenderedConfigs()
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux)
+	return mux
+}
+
+// testClient 以某个管理员 session（或 API Token）身份发起请求。
+type testClient struct {
+	t       *testing.T
+	mux     http.Handler
+	session string
+	bearer  string
+
+	// omitCSRF 不携带 X-CSRF-Token，用于模拟跨站请求。
+	omitCSRF bool
+}
+
+func (c *testClient) do(method, path string, body interface{}) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	if body == nil {
+		return c.doRaw(method, path, "", nil)
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatalf("marshal request body: %v", err)
+	}
+	return c.doRaw(method, path, "application/json", encoded)
+}
+
+// doRaw 发送原始请求体，contentType 为空时不设置 Content-Type。
+func (c *testClient) doRaw(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.RemoteAddr = "192.0.2.10:40000"
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.session != "" {
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: c.session})
+		if !c.omitCSRF {
+			req.Header.Set(csrfHeaderName, csrfTokenForSession(c.session))
+		}
+	}
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	}
+
+	rec := httptest.NewRecorder()
+	c.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// login 通过 POST /login 登录并返回携带 session 的客户端。
+func login(t *testing.T, mux http.Handler, username, password string) *testClient {
+	t.Helper()
+
+	anon := &testClient{t: t, mux: mux}
+	rec := anon.do(http.MethodPost, "/login", map[string]string{
+		"username": username,
+		"password": password,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login %s: status %d, body %s", username, rec.Code, rec.Body.String())
+	}
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "session_token" && cookie.Value != "" {
+			return &testClient{t: t, mux: mux, session: cookie.Value}
+		}
+	}
+	t.Fatalf("login %s: no session cookie", username)
+	return nil
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d (body: %s)", rec.Code, want, rec.Body.String())
+	}
+}
+
+func TestSubscribersAPIHidesTokensFromViewers(t *testing.T) {
+	mux := newTestMux(t)
+	token, err := store.AddSubscriber("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range []struct {
+		username string
+		role     store.AdminRole
+	}{{"viewer", store.AdminRoleViewer}, {"editor", store.AdminRoleEditor}} {
+		if err := store.CreateAdminUser(account.username, account.username+"-pass", account.role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listTokens := func(c *testClient) []string {
+		rec := c.do(http.MethodGet, "/api/subscribers", nil)
+		expectStatus(t, rec, http.StatusOK)
+		var resp struct {
+			Subscribers []store.Subscriber `json:"subscribers"`
+		}
+		decodeJSON(t, rec, &resp)
+		tokens := make([]string, 0, len(resp.Subscribers))
+		for _, subscriber := range resp.Subscribers {
+			tokens = append(tokens, subscriber.Token)
+		}
+		return tokens
+	}
+
+	if got := listTokens(login(t, mux, "viewer", "viewer-pass")); len(got) != 1 || got[0] != "" {
+		t.Errorf("viewer tokens = %q, want one redacted entry", got)
+	}
+	for _, username := range []string{"editor", "admin"} {
+		password := username + "-pass"
+		if username == "admin" {
+			password = "admin"
+		}
+		if got := listTokens(login(t, mux, username, password)); len(got) != 1 || got[0] != token {
+			t.Errorf("%s tokens = %q, want [%q]", username, got, token)
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package handler
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
 	"my-stash-rule/internal/store"
 )
 
+type contextKey string
+
+const (
+	adminContextKey    contextKey = "admin"
+	apiTokenContextKey contextKey = "api_token"
+)
+
 func getSessionUsername(r *http.Request) (string, error) {
 	cookie, err := r.Cookie("session_token")
 	if err != nil {
@@ -14,37 +23,98 @@ func getSessionUsername(r *http.Request) (string, error) {
 	return store.ValidateSession(cookie.Value)
 }
 
-func isAdminUsername(username string) (bool, error) {
-	adminUsername, err := store.GetAdminUsername()
-	if err != nil {
-		return false, err
+// getSessionAdmin 返回当前 session 对应的管理员账号，未登录或账号不存在时返回 nil。
+func getSessionAdmin(r *http.Request) (*store.AdminUser, error) {
+	username, err := getSessionUsername(r)
+	if err != nil || username == "" {
+		return nil, err
+	}
+	return store.GetAdminUser(username)
+}
+
+// currentAdmin 返回经 AdminAuthMiddleware 校验后的管理员账号。
+func currentAdmin(r *http.Request) *store.AdminUser {
+	admin, _ := r.Context().Value(adminContextKey).(*store.AdminUser)
+	return admin
+}
+
+// currentAdminName 返回当前管理员用户名，未登录时为空。
+func currentAdminName(r *http.Request) string {
+	if admin := currentAdmin(r); admin != nil {
+		return admin.Username
 	}
-	return username != "" && adminUsername != "" && username == adminUsername, nil
+	return ""
 }
 
-// AdminAuthMiddleware 仅允许管理员 session 访问
-func AdminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// currentAPIToken 返回通过 Bearer 认证的 API Token，session 登录时为 nil。
+func currentAPIToken(r *http.Request) *store.AdminAPIToken {
+	token, _ := r.Context().Value(apiTokenContextKey).(*store.AdminAPIToken)
+	return token
+}
+
+// bearerToken 读取 Authorization: Bearer 头，未携带时返回空。
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// getAPITokenAdmin 校验 API Token 并返回对应管理员，角色取 Token 与账号当前角色中较低者。
+func getAPITokenAdmin(r *http.Request, raw string) (*store.AdminUser, *store.AdminAPIToken, error) {
+	token, err := store.ValidateAdminAPIToken(raw, clientIP(r))
+	if err != nil || token == nil {
+		return nil, nil, err
+	}
+	admin, err := store.GetAdminUser(token.Username)
+	if err != nil || admin == nil {
+		return nil, nil, err
+	}
+	if admin.Role.Allows(token.Role) {
+		admin.Role = token.Role
+	}
+	return admin, token, nil
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// AdminAuthMiddleware 仅允许管理员 session 或管理 API Token（Authorization: Bearer）访问。
+// 读请求（GET/HEAD）要求角色不低于 readRole，其他请求要求角色不低于 writeRole；
+// session 请求还必须携带有效的 CSRF token，Bearer 请求不依赖 cookie，无需 CSRF token。
+func AdminAuthMiddleware(readRole, writeRole store.AdminRole, next http.HandlerFunc) http.HandlerFunc {
+	withCSRF := CSRFMiddleware(next)
 	return func(w http.ResponseWriter, r *http.Request) {
-		username, err := getSessionUsername(r)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		if username == "" {
-			if r.Method == http.MethodGet {
-				http.Redirect(w, r, "/login", http.StatusFound)
+		if raw := bearerToken(r); raw != "" {
+			admin, token, err := getAPITokenAdmin(r, raw)
+			if err != nil {
+				http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
 				return
 			}
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if admin == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, `{"error":"invalid api token"}`, http.StatusUnauthorized)
+				return
+			}
+			if !admin.Role.Allows(requiredRole(r, readRole, writeRole)) {
+				http.Error(w, `{"error":"permission denied"}`, http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), adminContextKey, admin)
+			ctx = context.WithValue(ctx, apiTokenContextKey, token)
+			next(w, r.WithContext(ctx))
 			return
 		}
 
-		ok, err := isAdminUsername(username)
+		admin, err := getSessionAdmin(r)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		if !ok {
+		if admin == nil {
 			if r.Method == http.MethodGet {
 				http.Redirect(w, r, "/login", http.StatusFound)
 				return
@@ -53,6 +123,30 @@ func AdminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if !admin.Role.Allows(requiredRole(r, readRole, writeRole)) {
+			http.Error(w, `{"error":"permission denied"}`, http.StatusForbidden)
+			return
+		}
+
+		withCSRF(w, r.WithContext(context.WithValue(r.Context(), adminContextKey, admin)))
+	}
+}
+
+func requiredRole(r *http.Request, readRole, writeRole store.AdminRole) store.AdminRole {
+	if isReadMethod(r.Method) {
+		return readRole
+	}
+	return writeRole
+}
+
+// SessionOnly 拒绝 API Token 访问，用于账号、会话、Token 管理等敏感接口，
+// 需放在 AdminAuthMiddleware 内层。
+func SessionOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if currentAPIToken(r) != nil {
+			http.Error(w, `{"error":"this endpoint requires a browser session"}`, http.StatusForbidden)
+			return
+		}
 		next(w, r)
 	}
 }
@@ -82,20 +176,12 @@ func ResolveConfigRequester(r *http.Request) (string, bool, error) {
 		}
 	}
 
-	username, err := getSessionUsername(r)
-	if err != nil {
-		return "", false, err
-	}
-	if username == "" {
-		return "", false, nil
-	}
-
-	isAdmin, err := isAdminUsername(username)
+	admin, err := getSessionAdmin(r)
 	if err != nil {
 		return "", false, err
 	}
-	if !isAdmin {
+	if admin == nil {
 		return "", false, nil
 	}
-	return username, true, nil
+	return admin.Username, true, nil
 }
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/store"
+)
+
+func TestAdminAuthMiddlewareRequiresLogin(t *testing.T) {
+	mux := newTestMux(t)
+	anon := &testClient{t: t, mux: mux}
+
+	rec := anon.do(http.MethodGet, "/admin", nil)
+	expectStatus(t, rec, http.StatusFound)
+	if loc := rec.Header().Get("Location"); loc != "/login" {
+		t.Errorf("Location = %q, want /login", loc)
+	}
+
+	expectStatus(t, anon.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{}}), http.StatusUnauthorized)
+
+	// 伪造的 session token 与未登录等价。
+	forged := &testClient{t: t, mux: mux, session: "not-a-session"}
+	expectStatus(t, forged.do(http.MethodGet, "/admin", nil), http.StatusFound)
+}
+
+func TestAdminAuthMiddlewareRequiresCSRFForWrites(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	body := map[string]interface{}{"urls": []string{"https://example.com/sub"}}
+
+	noCSRF := &testClient{t: t, mux: mux, session: admin.session, omitCSRF: true}
+	expectStatus(t, noCSRF.do(http.MethodGet, "/api/config", nil), http.StatusOK)
+	expectStatus(t, noCSRF.do(http.MethodPost, "/api/config", body), http.StatusForbidden)
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", body), http.StatusOK)
+	urls, err := store.GetStoredSubscribeUrls()
+	if err != nil || len(urls) != 1 {
+		t.Fatalf("stored urls = %v, %v", urls, err)
+	}
+}
+
+func TestAdminAuthMiddlewareEnforcesRoles(t *testing.T) {
+	mux := newTestMux(t)
+	if err := store.CreateAdminUser("viewer", "viewer-pass", store.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateAdminUser("editor", "editor-pass", store.AdminRoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]interface{}{"urls": []string{}}
+
+	viewer := login(t, mux, "viewer", "viewer-pass")
+	expectStatus(t, viewer.do(http.MethodGet, "/api/config", nil), http.StatusOK)
+	expectStatus(t, viewer.do(http.MethodPost, "/api/config", body), http.StatusForbidden)
+	expectStatus(t, viewer.do(http.MethodGet, "/api/admin/users", nil), http.StatusForbidden)
+
+	editor := login(t, mux, "editor", "editor-pass")
+	expectStatus(t, editor.do(http.MethodPost, "/api/config", body), http.StatusOK)
+	expectStatus(t, editor.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice"}), http.StatusForbidden)
+
+	// 删除账号后，已有 session 立即失效。
+	if err := store.DeleteAdminUser("editor"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, editor.do(http.MethodGet, "/api/config", nil), http.StatusFound)
+}
+
+func TestAdminAuthMiddlewareAcceptsAPIToken(t *testing.T) {
+	mux := newTestMux(t)
+	if err := store.CreateAdminUser("editor", "editor-pass", store.AdminRoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := store.CreateAdminAPIToken("editor", "ci", store.AdminRoleEditor, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]interface{}{"urls": []string{"https://example.com/sub"}}
+
+	// Bearer 请求不依赖 cookie，无需 CSRF token。
+	client := &testClient{t: t, mux: mux, bearer: raw}
+	expectStatus(t, client.do(http.MethodPost, "/api/config", body), http.StatusOK)
+
+	// 账号管理等接口只接受浏览器 session，owner Token 也不例外。
+	ownerRaw, _, err := store.CreateAdminAPIToken("admin", "ops", store.AdminRoleOwner, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := (&testClient{t: t, mux: mux, bearer: ownerRaw}).do(http.MethodGet, "/api/admin/users", nil)
+	expectStatus(t, rec, http.StatusForbidden)
+	if !strings.Contains(rec.Body.String(), "browser session") {
+		t.Errorf("unexpected body: %s", rec.Body.String())
+	}
+
+	// 账号降级后，Token 的有效角色随之降低。
+	if err := store.UpdateAdminUser("editor", store.AdminRoleViewer, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, client.do(http.MethodGet, "/api/config", nil), http.StatusOK)
+	expectStatus(t, client.do(http.MethodPost, "/api/config", body), http.StatusForbidden)
+
+	invalid := &testClient{t: t, mux: mux, bearer: "sra_invalid"}
+	rec = invalid.do(http.MethodGet, "/api/config", nil)
+	expectStatus(t, rec, http.StatusUnauthorized)
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected WWW-Authenticate header")
+	}
+}
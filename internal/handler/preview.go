@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"my-stash-rule/internal/service"
+	"my-stash-rule/internal/store"
+)
+
+// HandlePreviewAPI 使用节点缓存预览订阅用户（或管理员）实际拿到的最终配置，可带未保存的模板内容，
+// 并与当前下发的配置按行对比。
+// GET ?subscriber=alice&profile=xxx&format=yaml|json
+// POST {"subscriber": "alice", "profile": "xxx", "format": "yaml", "content": "未保存的模板内容"}
+// subscriber 为空时按管理员预览（无标签、变量与覆盖配置）；profile 为空时使用订阅用户绑定的模板。
+func HandlePreviewAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	req := struct {
+		Subscriber string  `json:"subscriber"`
+		Profile    string  `json:"profile"`
+		Format     string  `json:"format"`
+		Content    *string `json:"content"`
+	}{
+		Subscriber: query.Get("subscriber"),
+		Profile:    query.Get("profile"),
+		Format:     query.Get("format"),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscriber := strings.TrimSpace(req.Subscriber)
+	format := strings.ToLower(strings.TrimSpace(req.Format))
+	if format == "" {
+		format = service.ConfigFormatYAML
+	}
+	if format != service.ConfigFormatYAML && format != service.ConfigFormatJSON {
+		http.Error(w, `{"error":"format must be yaml or json"}`, http.StatusBadRequest)
+		return
+	}
+
+	username := subscriber
+	if username == "" {
+		username = currentAdminName(r)
+	}
+	base := service.RenderRequest{Username: username, Subscriber: subscriber != ""}
+
+	preview := base
+	preview.ProfileName = strings.TrimSpace(req.Profile)
+	if req.Content != nil {
+		if preview.ProfileName == "" {
+			http.Error(w, `{"error":"profile is required when previewing draft content"}`, http.StatusBadRequest)
+			return
+		}
+		if _, err := store.ParseStashProfileContent(*req.Content); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		preview.Drafts = map[string]string{preview.ProfileName: *req.Content}
+	}
+
+	result, err := service.RenderConfig(preview)
+	if err != nil {
+		writePreviewError(w, err)
+		return
+	}
+	content, err := service.ConvertConfigFormat(result.Output, format)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 当前下发的配置：订阅用户绑定的模板（管理员预览时为所选模板）的已保存内容。
+	if !base.Subscriber {
+		base.ProfileName = result.ProfileName
+	}
+	var current []byte
+	currentProfile := ""
+	if served, err := service.RenderConfig(base); err == nil {
+		currentProfile = served.ProfileName
+		if current, err = service.ConvertConfigFormat(served.Output, format); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+	} else if !errors.Is(err, store.ErrStashProfileNotFound) {
+		writePreviewError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"subscriber":      subscriber,
+		"profile":         result.ProfileName,
+		"format":          format,
+		"chain":           result.Chain,
+		"node_count":      result.NodeCount,
+		"content":         string(content),
+		"current":         string(current),
+		"current_profile": currentProfile,
+		"warnings":        nonNilWarnings(result.Warnings),
+		"adjustments":     nonNilChanges(result.Changes),
+	}
+	if diff, err := service.DiffLines(string(current), string(content)); err == nil {
+		response["diff"] = diff
+	} else {
+		response["diff_error"] = err.Error()
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func writePreviewError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, store.ErrStashProfileNotFound) || errors.Is(err, service.ErrSubscriberNotFound) {
+		status = http.StatusNotFound
+	}
+	writeJSONError(w, status, err)
+}
+
+func nonNilWarnings(warnings []service.ConfigWarning) []service.ConfigWarning {
+	if warnings == nil {
+		return []service.ConfigWarning{}
+	}
+	return warnings
+}
+
+func nonNilChanges(changes []service.ConfigChange) []service.ConfigChange {
+	if changes == nil {
+		return []service.ConfigChange{}
+	}
+	return changes
+}
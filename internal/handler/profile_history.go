@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"my-stash-rule/internal/service"
+	"my-stash-rule/internal/store"
+)
+
+// stashProfileRevisionSummary 历史列表中的版本摘要（不含内容）。
+type stashProfileRevisionSummary struct {
+	Version   int    `json:"version"`
+	Author    string `json:"author"`
+	CreatedAt int64  `json:"created_at"`
+	Note      string `json:"note,omitempty"`
+	Size      int    `json:"size"`
+}
+
+// writeProfileHistoryError 模板或版本不存在时返回 404，其余返回 500。
+func writeProfileHistoryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrStashProfileNotFound) || errors.Is(err, store.ErrStashProfileRevisionNotFound) {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	log.Printf("Failed to load stash profile history: %v", err)
+	http.Error(w, `{"error":"failed to load stash profile history"}`, http.StatusInternalServerError)
+}
+
+// parseRevisionParam 解析版本号参数，未传时返回 0。
+func parseRevisionParam(raw string) (int, bool) {
+	if raw == "" {
+		return 0, true
+	}
+	v, err := strconv.Atoi(raw)
+	return v, err == nil && v > 0
+}
+
+// HandleStashProfileHistoryAPI 模板历史版本
+// GET ?name=xxx: 列出历史版本（最新在前）
+// GET ?name=xxx&version=N: 获取指定版本内容
+func HandleStashProfileHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	version, ok := parseRevisionParam(r.URL.Query().Get("version"))
+	if !ok {
+		http.Error(w, `{"error":"invalid version"}`, http.StatusBadRequest)
+		return
+	}
+
+	if version > 0 {
+		revision, err := store.GetStashProfileRevision(name, version)
+		if err != nil {
+			writeProfileHistoryError(w, err)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(revision)
+		return
+	}
+
+	revisions, err := store.ListStashProfileRevisions(name)
+	if err != nil {
+		writeProfileHistoryError(w, err)
+		return
+	}
+	summaries := make([]stashProfileRevisionSummary, 0, len(revisions))
+	for _, revision := range revisions {
+		summaries = append(summaries, stashProfileRevisionSummary{
+			Version:   revision.Version,
+			Author:    revision.Author,
+			CreatedAt: revision.CreatedAt,
+			Note:      revision.Note,
+			Size:      len(revision.Content),
+		})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":      store.DefaultStashProfileNameIfEmpty(name),
+		"revisions": summaries,
+	})
+}
+
+// HandleStashProfileDiffAPI 对比模板的两个版本
+// GET ?name=xxx&from=N&to=M，to 省略时与当前内容对比
+func HandleStashProfileDiffAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("name"))
+	from, okFrom := parseRevisionParam(q.Get("from"))
+	to, okTo := parseRevisionParam(q.Get("to"))
+	if !okFrom || !okTo || from == 0 {
+		http.Error(w, `{"error":"invalid version"}`, http.StatusBadRequest)
+		return
+	}
+
+	fromRevision, err := store.GetStashProfileRevision(name, from)
+	if err != nil {
+		writeProfileHistoryError(w, err)
+		return
+	}
+	var toContent string
+	if to > 0 {
+		toRevision, err := store.GetStashProfileRevision(name, to)
+		if err != nil {
+			writeProfileHistoryError(w, err)
+			return
+		}
+		toContent = toRevision.Content
+	} else {
+		toContent, err = store.GetStashProfileYAML(name)
+		if err != nil {
+			writeProfileHistoryError(w, err)
+			return
+		}
+	}
+
+	diff, err := service.DiffLines(fromRevision.Content, toContent)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":    store.DefaultStashProfileNameIfEmpty(name),
+		"from":    from,
+		"to":      to,
+		"lines":   diff.Lines,
+		"added":   diff.Added,
+		"removed": diff.Removed,
+	})
+}
+
+// HandleStashProfileRollbackAPI 回滚模板到指定历史版本
+// POST: {"name": "xxx", "version": N}
+func HandleStashProfileRollbackAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version <= 0 {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	req.Name = store.DefaultStashProfileNameIfEmpty(req.Name)
+
+	if err := store.RollbackStashProfile(req.Name, req.Version, currentAdminName(r)); err != nil {
+		writeProfileHistoryError(w, err)
+		return
+	}
+
+	log.Printf("Admin %s rolled back stash profile %s to v%d (ip: %s)", currentAdminName(r), req.Name, req.Version, clientIP(r))
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "ok",
+		"name":    req.Name,
+		"version": req.Version,
+	})
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+// RateLimitKeyFunc 返回请求的限流 key，返回空字符串表示该请求不限流。
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitByTokenOrIP 订阅 token 有效时按对应的订阅用户限流，否则（无 token、伪造或已禁用的 token）
+// 按客户端 IP 限流，避免轮换随意编造的 token 绕过 IP 限流。
+func RateLimitByTokenOrIP(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		username, err := store.ValidateAPIToken(token)
+		if err != nil {
+			log.Printf("Rate limit token lookup failed: %v", err)
+		} else if username != "" {
+			return "subscriber:" + username
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// RateLimitLoginAttempts 仅对登录提交（非 GET）按客户端 IP 限流。
+func RateLimitLoginAttempts(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ""
+	}
+	return "ip:" + clientIP(r)
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, `{"error":"too many requests"}`, http.StatusTooManyRequests)
+}
+
+// RateLimitMiddleware 基于 Redis 滑动窗口的限流中间件。
+// 限流规则读取环境变量 RATE_LIMIT_<ROUTE>，未配置时使用 defaultLimit；Redis 异常时放行。
+func RateLimitMiddleware(route string, defaultLimit config.RateLimit, keyFunc RateLimitKeyFunc, next http.HandlerFunc) http.HandlerFunc {
+	limit := config.GetRateLimit(route, defaultLimit)
+	if !limit.Enabled() {
+		return next
+	}
+	log.Printf("Rate limit enabled for %s: %d/%s", route, limit.Limit, limit.Window)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := keyFunc(r)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		allowed, retryAfter, err := store.AllowRequest(route, key, limit.Limit, limit.Window, time.Now())
+		if err != nil {
+			log.Printf("Rate limit check failed for %s: %v", route, err)
+			next(w, r)
+			return
+		}
+		if !allowed {
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+func TestRateLimitByTokenOrIPIgnoresUnknownTokens(t *testing.T) {
+	store.UseBackend(store.NewMemoryBackend())
+	t.Cleanup(func() { _ = store.CloseStorage() })
+
+	token, err := store.AddSubscriber("alice", "")
+	if err != nil {
+		t.Fatalf("AddSubscriber: %v", err)
+	}
+
+	limited := RateLimitMiddleware("test", config.RateLimit{Limit: 2, Window: time.Minute}, RateLimitByTokenOrIP,
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	fetch := func(query, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		limited(rec, req)
+		return rec.Code
+	}
+
+	// 每次编造不同的 token 仍与无 token 请求共享同一 IP 的额度。
+	for i, query := range []string{"token=made-up-1", "token=made-up-2", "token=made-up-3", ""} {
+		want := http.StatusOK
+		if i >= 2 {
+			want = http.StatusTooManyRequests
+		}
+		if code := fetch(query, "192.0.2.10:40000"); code != want {
+			t.Errorf("request %d (%q): status %d, want %d", i, query, code, want)
+		}
+	}
+
+	// 有效 token 按订阅用户单独计数，不受该 IP 已耗尽的额度影响。
+	if code := fetch("token="+token, "192.0.2.10:40000"); code != http.StatusOK {
+		t.Errorf("valid token: status %d, want %d", code, http.StatusOK)
+	}
+	// 其他 IP 不受影响。
+	if code := fetch("token=made-up-4", "198.51.100.7:40000"); code != http.StatusOK {
+		t.Errorf("other IP: status %d, want %d", code, http.StatusOK)
+	}
+}
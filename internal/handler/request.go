@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"my-stash-rule/internal/config"
+)
+
+// clientIP 获取请求方 IP。开启 TRUST_PROXY_HEADERS 时直连方视为反向代理，按 X-Forwarded-For
+// 从右向左跳过受信任的代理（TRUSTED_PROXIES），取第一个不受信任的地址；最左侧的条目由客户端
+// 自行填写，不可信，只在整条链都是受信任代理时才使用。
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !config.GetTrustProxyHeaders() {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		var chain []string
+		for _, header := range forwarded {
+			for _, entry := range strings.Split(header, ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					chain = append(chain, entry)
+				}
+			}
+		}
+		trusted := config.GetTrustedProxies()
+		for i := len(chain) - 1; i >= 0; i-- {
+			if !isTrustedProxy(chain[i], trusted) {
+				return chain[i]
+			}
+		}
+		if len(chain) > 0 {
+			return chain[0]
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return host
+}
+
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientFingerprint 返回用于区分客户端的指纹（目前为 User-Agent）。
+func clientFingerprint(r *http.Request) string {
+	return strings.TrimSpace(r.UserAgent())
+}
+
+// isSecureRequest 判断请求是否经由 HTTPS 到达（直连 TLS 或受信任代理透传的 X-Forwarded-Proto）。
+func isSecureRequest(r *http.Request) bool {
+	switch config.GetCookieSecure() {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if r.TLS != nil {
+		return true
+	}
+	return config.GetTrustProxyHeaders() && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
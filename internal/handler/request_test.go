@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPUsesRightmostUntrustedForwardedEntry(t *testing.T) {
+	cases := []struct {
+		name      string
+		trust     string
+		trusted   string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{name: "headers ignored when not trusted", trust: "false", forwarded: []string{"203.0.113.9"}, want: "10.0.0.2"},
+		{name: "spoofed left-most entry ignored", trust: "true", forwarded: []string{"1.1.1.1, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "trusted proxies skipped", trust: "true", forwarded: []string{"1.1.1.1, 198.51.100.7, 10.0.0.5"}, want: "198.51.100.7"},
+		{name: "multiple headers", trust: "true", forwarded: []string{"1.1.1.1", "198.51.100.7"}, want: "198.51.100.7"},
+		{name: "custom trusted proxies", trust: "true", trusted: "198.51.100.0/24", forwarded: []string{"1.1.1.1, 203.0.113.4, 198.51.100.7"}, want: "203.0.113.4"},
+		{name: "all trusted uses left-most", trust: "true", forwarded: []string{"192.168.1.20, 10.0.0.5"}, want: "192.168.1.20"},
+		{name: "x-real-ip fallback", trust: "true", realIP: "203.0.113.8", want: "203.0.113.8"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY_HEADERS", tc.trust)
+			t.Setenv("TRUSTED_PROXIES", tc.trusted)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "10.0.0.2:34567"
+			for _, value := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if got := clientIP(req); got != tc.want {
+				t.Errorf("clientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+// RegisterRoutes 在 mux 上注册全部 HTTP 路由，main.go 与测试共用。
+func RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/", RateLimitMiddleware("config",
+		config.RateLimit{Limit: 60, Window: time.Minute},
+		RateLimitByTokenOrIP, HandleGetConfig))
+	mux.HandleFunc("/health", HandleHealthCheck)
+
+	// Auth routes
+	mux.HandleFunc("/login", RateLimitMiddleware("login",
+		config.RateLimit{Limit: 10, Window: time.Minute},
+		RateLimitLoginAttempts, HandleLogin))
+	mux.HandleFunc("/login/oidc", RateLimitMiddleware("oidc",
+		config.RateLimit{Limit: 20, Window: time.Minute},
+		RateLimitByTokenOrIP, HandleOIDCLogin))
+	mux.HandleFunc("/login/oidc/callback", RateLimitMiddleware("oidc",
+		config.RateLimit{Limit: 20, Window: time.Minute},
+		RateLimitByTokenOrIP, HandleOIDCCallback))
+	mux.HandleFunc("/logout", CSRFMiddleware(HandleLogout))
+
+	// Protected routes
+	// 读请求允许全部角色；写请求按路由要求 editor / owner。
+	viewer, editor, owner := store.AdminRoleViewer, store.AdminRoleEditor, store.AdminRoleOwner
+	mux.HandleFunc("/admin", AdminAuthMiddleware(viewer, viewer, HandleAdminPage))
+	mux.HandleFunc("/admin/config", AdminAuthMiddleware(viewer, viewer, HandleAdminConfigPage))
+	mux.HandleFunc("/admin/profiles", AdminAuthMiddleware(viewer, viewer, HandleAdminProfilesPage))
+	mux.HandleFunc("/admin/subscribers", AdminAuthMiddleware(viewer, viewer, HandleAdminSubscribersPage))
+	mux.HandleFunc("/admin/account", AdminAuthMiddleware(viewer, viewer, HandleAdminAccountPage))
+	mux.HandleFunc("/admin/users", AdminAuthMiddleware(owner, owner, HandleAdminUsersPage))
+	mux.HandleFunc("/api/config", AdminAuthMiddleware(viewer, editor, HandleConfigAPI))
+	mux.HandleFunc("/api/proxy/cache", AdminAuthMiddleware(viewer, editor, HandleProxyCacheAPI))
+	mux.HandleFunc("/api/stash/profiles", AdminAuthMiddleware(viewer, editor, HandleStashProfilesAPI))
+	mux.HandleFunc("/api/stash/profiles/history", AdminAuthMiddleware(viewer, editor, HandleStashProfileHistoryAPI))
+	mux.HandleFunc("/api/stash/profiles/diff", AdminAuthMiddleware(viewer, editor, HandleStashProfileDiffAPI))
+	mux.HandleFunc("/api/stash/profiles/rollback", AdminAuthMiddleware(viewer, editor, HandleStashProfileRollbackAPI))
+	mux.HandleFunc("/api/stash/profiles/rename", AdminAuthMiddleware(viewer, editor, HandleStashProfileRenameAPI))
+	mux.HandleFunc("/api/stash/profiles/resolved", AdminAuthMiddleware(viewer, editor, HandleStashProfileResolvedAPI))
+	mux.HandleFunc("/api/preview", AdminAuthMiddleware(viewer, viewer, HandlePreviewAPI))
+	mux.HandleFunc("/api/admin/profile", AdminAuthMiddleware(viewer, viewer, SessionOnly(HandleAdminProfileAPI)))
+	mux.HandleFunc("/api/admin/users", AdminAuthMiddleware(owner, owner, SessionOnly(HandleAdminUsersAPI)))
+	mux.HandleFunc("/api/admin/sessions", AdminAuthMiddleware(viewer, viewer, SessionOnly(HandleAdminSessionsAPI)))
+	mux.HandleFunc("/api/admin/tokens", AdminAuthMiddleware(viewer, viewer, SessionOnly(HandleAdminAPITokensAPI)))
+	mux.HandleFunc("/api/admin/export", AdminAuthMiddleware(owner, owner, HandleAdminExportAPI))
+	mux.HandleFunc("/api/admin/import", AdminAuthMiddleware(owner, owner, HandleAdminImportAPI))
+	mux.HandleFunc("/api/admin/backups", AdminAuthMiddleware(owner, owner, HandleAdminBackupsAPI))
+	mux.HandleFunc("/api/admin/backups/restore", AdminAuthMiddleware(owner, owner, HandleAdminBackupRestoreAPI))
+	mux.HandleFunc("/api/subscribers", AdminAuthMiddleware(viewer, owner, HandleSubscribersAPI))
+	mux.HandleFunc("/api/subscribers/status", AdminAuthMiddleware(viewer, owner, HandleSubscriberStatusAPI))
+	mux.HandleFunc("/api/subscribers/attributes", AdminAuthMiddleware(viewer, owner, HandleSubscriberAttributesAPI))
+	mux.HandleFunc("/api/subscribers/alerts", AdminAuthMiddleware(viewer, owner, HandleLeakAlertsAPI))
+	mux.HandleFunc("/api/user/info", AdminAuthMiddleware(viewer, viewer, HandleGetUserInfo))
+}
@@ -30,22 +30,12 @@ func HandleGetUserInfo(w http.ResponseWriter, r *http.Request) {
 
 	// 管理员 session：仅返回管理员用户名
 	if username == "" {
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		sessionUsername, err := store.ValidateSession(cookie.Value)
-		if err != nil || sessionUsername == "" {
+		admin, err := getSessionAdmin(r)
+		if err != nil || admin == nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		adminUsername, err := store.GetAdminUsername()
-		if err != nil || adminUsername == "" || adminUsername != sessionUsername {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		username = adminUsername
+		username = admin.Username
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -58,7 +48,7 @@ func HandleGetUserInfo(w http.ResponseWriter, r *http.Request) {
 // HandleSubscribersAPI 订阅用户管理接口
 // GET: 获取订阅用户列表
 // POST: 新增订阅用户（自动生成随机 token）
-// PUT: 更新订阅用户绑定模板
+// PUT: 更新订阅用户绑定模板和 / 或覆盖配置（{"username", "profile_name", "override"}）
 // DELETE: 删除订阅用户
 func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -69,6 +59,12 @@ func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error":"failed to load subscribers"}`, http.StatusInternalServerError)
 			return
 		}
+		// 订阅 token 即订阅凭据，只读角色不返回。
+		if admin := currentAdmin(r); admin == nil || !admin.Role.Allows(store.AdminRoleEditor) {
+			for i := range subscribers {
+				subscribers[i].Token = ""
+			}
+		}
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{
 			"subscribers": subscribers,
 		})
@@ -101,7 +97,7 @@ func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 			if strings.Contains(err.Error(), "not found") {
 				status = http.StatusNotFound
 			}
-			http.Error(w, `{"error":"`+err.Error()+`"}`, status)
+			writeJSONError(w, status, err)
 			return
 		}
 
@@ -116,8 +112,9 @@ func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodPut {
 		var req struct {
-			Username    string `json:"username"`
-			ProfileName string `json:"profile_name"`
+			Username    string  `json:"username"`
+			ProfileName *string `json:"profile_name"`
+			Override    *string `json:"override"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
@@ -125,28 +122,35 @@ func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 		}
 
 		username := strings.TrimSpace(req.Username)
-		profileName := strings.TrimSpace(req.ProfileName)
 		if username == "" {
 			http.Error(w, `{"error":"订阅用户名不能为空"}`, http.StatusBadRequest)
 			return
 		}
 
-		if err := store.UpdateSubscriberProfile(username, profileName); err != nil {
+		// 只更新覆盖配置时保留当前绑定的模板；两者都未提供时改回默认模板。
+		profileName := req.ProfileName
+		if profileName == nil && req.Override == nil {
+			profileName = new(string)
+		}
+		if err := store.UpdateSubscriberProfileAndOverride(username, profileName, req.Override); err != nil {
 			status := http.StatusBadRequest
-			if strings.Contains(err.Error(), "subscriber not found") {
-				status = http.StatusNotFound
-			}
-			if strings.Contains(err.Error(), "stash profile not found") {
+			if strings.Contains(err.Error(), "subscriber not found") || strings.Contains(err.Error(), "stash profile not found") {
 				status = http.StatusNotFound
 			}
-			http.Error(w, `{"error":"`+err.Error()+`"}`, status)
+			writeJSONError(w, status, err)
 			return
 		}
 
+		subscriber, err := store.GetSubscriber(username)
+		if err != nil || subscriber == nil {
+			http.Error(w, `{"error":"failed to load subscriber"}`, http.StatusInternalServerError)
+			return
+		}
 		_ = json.NewEncoder(w).Encode(map[string]string{
 			"status":       "ok",
 			"username":     username,
-			"profile_name": store.DefaultStashProfileNameIfEmpty(profileName),
+			"profile_name": store.DefaultStashProfileNameIfEmpty(subscriber.ProfileName),
+			"override":     subscriber.Override,
 		})
 		return
 	}
@@ -171,7 +175,7 @@ func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 			if strings.Contains(err.Error(), "subscriber not found") {
 				status = http.StatusNotFound
 			}
-			http.Error(w, `{"error":"`+err.Error()+`"}`, status)
+			writeJSONError(w, status, err)
 			return
 		}
 
@@ -184,3 +188,122 @@ func HandleSubscribersAPI(w http.ResponseWriter, r *http.Request) {
 
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
+
+// HandleSubscriberStatusAPI 启用/禁用订阅用户 token
+// POST: {"username": "...", "disabled": true|false}
+func HandleSubscriberStatusAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Disabled bool   `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	username := strings.TrimSpace(req.Username)
+	if username == "" {
+		http.Error(w, `{"error":"订阅用户名不能为空"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := store.SetSubscriberDisabled(username, req.Disabled, "manual"); err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "subscriber not found") {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, status, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "ok",
+		"username": username,
+		"disabled": req.Disabled,
+	})
+}
+
+// HandleSubscriberAttributesAPI 设置订阅用户的标签与自定义变量（模板渲染时可用）
+// POST: {"username": "...", "tags": ["vip"], "vars": {"dns": "1.1.1.1"}}，整体覆盖
+func HandleSubscriberAttributesAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string            `json:"username"`
+		Tags     []string          `json:"tags"`
+		Vars     map[string]string `json:"vars"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	username := strings.TrimSpace(req.Username)
+	if username == "" {
+		http.Error(w, `{"error":"订阅用户名不能为空"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := store.UpdateSubscriberAttributes(username, req.Tags, req.Vars); err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "subscriber not found") {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, status, err)
+		return
+	}
+
+	subscriber, err := store.GetSubscriber(username)
+	if err != nil || subscriber == nil {
+		http.Error(w, `{"error":"failed to load subscriber"}`, http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "ok",
+		"username": username,
+		"tags":     subscriber.Tags,
+		"vars":     subscriber.Vars,
+	})
+}
+
+// HandleLeakAlertsAPI 订阅 token 泄露告警
+// GET: 获取告警列表
+// DELETE: 清空告警
+func HandleLeakAlertsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		alerts, err := store.ListLeakAlerts()
+		if err != nil {
+			http.Error(w, `{"error":"failed to load leak alerts"}`, http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"alerts": alerts,
+		})
+		return
+	case http.MethodDelete:
+		if err := store.ClearLeakAlerts(); err != nil {
+			http.Error(w, `{"error":"failed to clear leak alerts"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"my-stash-rule/internal/store"
+)
+
+func TestSubscribersAPICRUD(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+
+	// 新增
+	rec := admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice"})
+	expectStatus(t, rec, http.StatusOK)
+	var created struct {
+		Username    string `json:"username"`
+		Token       string `json:"token"`
+		ProfileName string `json:"profile_name"`
+	}
+	decodeJSON(t, rec, &created)
+	if created.Username != "alice" || len(created.Token) != 64 || created.ProfileName != store.DefaultStashProfileName {
+		t.Fatalf("unexpected create response: %+v", created)
+	}
+
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice"}), http.StatusConflict)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "  "}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "bob", "profile_name": "missing"}), http.StatusNotFound)
+
+	// 查询
+	rec = admin.do(http.MethodGet, "/api/subscribers", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var list struct {
+		Subscribers []store.Subscriber `json:"subscribers"`
+	}
+	decodeJSON(t, rec, &list)
+	if len(list.Subscribers) != 1 || list.Subscribers[0].Token != created.Token {
+		t.Fatalf("unexpected subscriber list: %+v", list.Subscribers)
+	}
+
+	// 修改绑定模板
+	if err := store.CreateStashProfile("mobile", "mode: global\n", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "mobile"}), http.StatusOK)
+	if name, _ := store.GetSubscriberProfile("alice"); name != "mobile" {
+		t.Errorf("profile = %q, want mobile", name)
+	}
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "nobody", "profile_name": "mobile"}), http.StatusNotFound)
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "missing"}), http.StatusNotFound)
+
+	// 禁用 / 启用
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers/status", map[string]interface{}{"username": "alice", "disabled": true}), http.StatusOK)
+	if username, _ := store.ValidateAPIToken(created.Token); username != "" {
+		t.Error("disabled subscriber token should be rejected")
+	}
+	expectStatus(t, admin.do(http.MethodPost, "/api/subscribers/status", map[string]interface{}{"username": "alice", "disabled": false}), http.StatusOK)
+	if username, _ := store.ValidateAPIToken(created.Token); username != "alice" {
+		t.Error("re-enabled subscriber token should be accepted")
+	}
+
+	// 删除
+	expectStatus(t, admin.do(http.MethodDelete, "/api/subscribers", map[string]string{"username": "alice"}), http.StatusOK)
+	expectStatus(t, admin.do(http.MethodDelete, "/api/subscribers", map[string]string{"username": "alice"}), http.StatusNotFound)
+	if username, _ := store.ValidateAPIToken(created.Token); username != "" {
+		t.Error("deleted subscriber token should be rejected")
+	}
+}
+
+func TestSubscribersAPIRequiresOwnerForWrites(t *testing.T) {
+	mux := newTestMux(t)
+	if err := store.CreateAdminUser("viewer", "viewer-pass", store.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	viewer := login(t, mux, "viewer", "viewer-pass")
+
+	expectStatus(t, viewer.do(http.MethodGet, "/api/subscribers", nil), http.StatusOK)
+	expectStatus(t, viewer.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice"}), http.StatusForbidden)
+
+	subscribers, err := store.ListSubscribers()
+	if err != nil || len(subscribers) != 0 {
+		t.Fatalf("subscribers = %+v, %v; want none", subscribers, err)
+	}
+}
+
+func TestSubscriberOverrideMergedLast(t *testing.T) {
+	mux := newTestMux(t)
+	admin := login(t, mux, "admin", "admin")
+	upstream, _ := newFakeUpstream(t, "trojan://secret@hk1.example.com:443#HK%2001")
+	expectStatus(t, admin.do(http.MethodPost, "/api/config", map[string]interface{}{"urls": []string{upstream.URL}}), http.StatusOK)
+	if err := store.CreateStashProfile("mobile", "mode: global\nmixed-port: 7000\n", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := admin.do(http.MethodPost, "/api/subscribers", map[string]string{"username": "alice", "profile_name": "mobile"})
+	expectStatus(t, rec, http.StatusOK)
+	var created struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &created)
+
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "alice", "override": "mixed-port: ["}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "alice", "override": "extends: [default]"}), http.StatusBadRequest)
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "nobody", "override": "mixed-port: 1"}), http.StatusNotFound)
+
+	// 模板不存在时整个请求失败，覆盖配置也不写入。
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{
+		"username": "alice", "profile_name": "missing", "override": "mixed-port: 1",
+	}), http.StatusNotFound)
+	if subscriber, _ := store.GetSubscriber("alice"); subscriber == nil || subscriber.Override != "" || subscriber.ProfileName != "mobile" {
+		t.Fatalf("subscriber changed by failed update: %+v", subscriber)
+	}
+
+	// 只更新覆盖配置时保留绑定的模板
+	rec = admin.do(http.MethodPut, "/api/subscribers", map[string]string{
+		"username": "alice",
+		"override": "mixed-port: 7891\nexternal-controller: {{ .Vars.controller | default \"127.0.0.1:9090\" }}",
+	})
+	expectStatus(t, rec, http.StatusOK)
+	if name, _ := store.GetSubscriberProfile("alice"); name != "mobile" {
+		t.Errorf("profile = %q, want mobile", name)
+	}
+
+	anon := &testClient{t: t, mux: mux}
+	fetch := func() (cfg struct {
+		Mode               string `yaml:"mode"`
+		MixedPort          int    `yaml:"mixed-port"`
+		ExternalController string `yaml:"external-controller"`
+	}) {
+		rec := anon.do(http.MethodGet, "/?token="+created.Token, nil)
+		expectStatus(t, rec, http.StatusOK)
+		if err := yaml.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	if cfg := fetch(); cfg.Mode != "global" || cfg.MixedPort != 7891 || cfg.ExternalController != "127.0.0.1:9090" {
+		t.Errorf("unexpected config with override: %+v", cfg)
+	}
+
+	// 清除覆盖配置后恢复模板中的值
+	expectStatus(t, admin.do(http.MethodPut, "/api/subscribers", map[string]string{"username": "alice", "override": ""}), http.StatusOK)
+	if cfg := fetch(); cfg.MixedPort != 7000 {
+		t.Errorf("mixed-port = %d after clearing override, want 7000", cfg.MixedPort)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+const recoveryCodeCount = 10
+
+// TOTPEnrollment 表示一次两步验证绑定的待确认信息。
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes 生成一组恢复码，返回明文（仅展示一次）与存储用的哈希。
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := hex.EncodeToString(b)
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// BeginTOTPEnrollment 为管理员生成新的待确认 TOTP 密钥。
+func BeginTOTPEnrollment(username string) (TOTPEnrollment, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := store.SetAdminTOTPPending(username, secret); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(config.GetTOTPIssuer(), username, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment 校验待确认密钥的验证码并启用两步验证，返回恢复码明文。
+func ConfirmTOTPEnrollment(username, code string) ([]string, error) {
+	state, err := store.GetAdminTOTP(username)
+	if err != nil {
+		return nil, err
+	}
+	if state.PendingSecret == "" {
+		return nil, fmt.Errorf("totp setup not started")
+	}
+
+	step, ok := ValidateTOTP(state.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("totp code is incorrect")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.EnableAdminTOTP(username, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧恢复码全部作废。
+func RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.ReplaceAdminRecoveryCodes(username, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyAdminSecondFactor 校验登录时的两步验证码（TOTP 或一次性恢复码）。
+func VerifyAdminSecondFactor(username, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+
+	state, err := store.GetAdminTOTP(username)
+	if err != nil {
+		return false, err
+	}
+	if !state.Enabled {
+		return true, nil
+	}
+
+	if step, ok := ValidateTOTP(state.Secret, code, time.Now()); ok {
+		return store.MarkAdminTOTPStepUsed(username, step)
+	}
+
+	return store.ConsumeAdminRecoveryCode(username, hashRecoveryCode(code))
+}
@@ -0,0 +1,490 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"my-stash-rule/internal/store"
+)
+
+// ArchiveVersion 当前备份格式版本，导入时拒绝更高版本的备份。
+const ArchiveVersion = 1
+
+// importRevisionAuthor 导入产生的模板历史版本记录的作者。
+const importRevisionAuthor = "(import)"
+
+// Archive 服务状态备份：订阅链接、配置模板（含其中的代理组 / 规则）与订阅用户。
+// 代理组只存在于模板中，没有单独的分组数据；运行参数均来自环境变量，不持久化到存储，也不在备份中。
+// 管理员账号、会话和节点缓存不在备份范围内。
+type Archive struct {
+	Version     int                 `json:"version" yaml:"version"`
+	ExportedAt  int64               `json:"exported_at" yaml:"exported_at"`
+	Sources     []string            `json:"sources" yaml:"sources"`
+	Profiles    []ArchiveProfile    `json:"profiles" yaml:"profiles"`
+	Subscribers []ArchiveSubscriber `json:"subscribers" yaml:"subscribers"`
+}
+
+// ArchiveProfile 备份中的配置模板。
+type ArchiveProfile struct {
+	Name    string `json:"name" yaml:"name"`
+	Content string `json:"content" yaml:"content"`
+}
+
+// ArchiveSubscriber 备份中的订阅用户，Token 为空表示导出时未包含 token。
+type ArchiveSubscriber struct {
+	Username       string            `json:"username" yaml:"username"`
+	Token          string            `json:"token,omitempty" yaml:"token,omitempty"`
+	ProfileName    string            `json:"profile_name" yaml:"profile_name"`
+	Disabled       bool              `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	DisabledReason string            `json:"disabled_reason,omitempty" yaml:"disabled_reason,omitempty"`
+	Tags           []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Vars           map[string]string `json:"vars,omitempty" yaml:"vars,omitempty"`
+	Override       string            `json:"override,omitempty" yaml:"override,omitempty"`
+}
+
+// ImportMode 导入方式。
+type ImportMode string
+
+const (
+	// ImportModeMerge 合并：新增或覆盖备份中的条目，保留备份中没有的现有条目。
+	ImportModeMerge ImportMode = "merge"
+	// ImportModeReplace 替换：导入后的状态与备份完全一致。
+	ImportModeReplace ImportMode = "replace"
+)
+
+// ImportChanges 某一类数据的变更明细。
+type ImportChanges struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// ImportReport 导入结果；DryRun 时仅包含计划执行的变更。
+type ImportReport struct {
+	Mode        ImportMode    `json:"mode"`
+	DryRun      bool          `json:"dry_run"`
+	Sources     ImportChanges `json:"sources"`
+	Profiles    ImportChanges `json:"profiles"`
+	Subscribers ImportChanges `json:"subscribers"`
+	Warnings    []string      `json:"warnings"`
+}
+
+func newImportChanges() ImportChanges {
+	return ImportChanges{Created: []string{}, Updated: []string{}, Deleted: []string{}}
+}
+
+// ExportArchive 导出当前服务状态，includeTokens=false 时不包含订阅 token。
+func ExportArchive(includeTokens bool) (*Archive, error) {
+	urls, err := store.GetStoredSubscribeUrls()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := store.ListStashProfiles()
+	if err != nil {
+		return nil, err
+	}
+	subscribers, err := store.ListSubscribers()
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Version:     ArchiveVersion,
+		ExportedAt:  time.Now().Unix(),
+		Sources:     normalizeSubscribeURLs(urls),
+		Profiles:    make([]ArchiveProfile, 0, len(profiles)),
+		Subscribers: make([]ArchiveSubscriber, 0, len(subscribers)),
+	}
+	for _, profile := range profiles {
+		archive.Profiles = append(archive.Profiles, ArchiveProfile{Name: profile.Name, Content: profile.Content})
+	}
+	for _, subscriber := range subscribers {
+		item := ArchiveSubscriber{
+			Username:       subscriber.Username,
+			ProfileName:    subscriber.ProfileName,
+			Disabled:       subscriber.Disabled,
+			DisabledReason: subscriber.DisabledReason,
+			Tags:           subscriber.Tags,
+			Vars:           subscriber.Vars,
+			Override:       subscriber.Override,
+		}
+		if includeTokens {
+			item.Token = subscriber.Token
+		}
+		archive.Subscribers = append(archive.Subscribers, item)
+	}
+	return archive, nil
+}
+
+// EncodeArchive 按 format（json / yaml）编码备份，返回内容与 Content-Type。
+func EncodeArchive(archive *Archive, format string) ([]byte, string, error) {
+	switch strings.ToLower(format) {
+	case "", "json":
+		data, err := json.MarshalIndent(archive, "", "  ")
+		return append(data, '\n'), "application/json", err
+	case "yaml", "yml":
+		data, err := yaml.Marshal(archive)
+		return data, "application/yaml", err
+	default:
+		return nil, "", fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// DecodeArchive 解析 JSON 或 YAML 格式的备份。
+func DecodeArchive(data []byte) (*Archive, error) {
+	var archive Archive
+	if err := yaml.Unmarshal(data, &archive); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if archive.Version <= 0 {
+		return nil, fmt.Errorf("invalid archive: missing version")
+	}
+	if archive.Version > ArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d (max %d)", archive.Version, ArchiveVersion)
+	}
+	return &archive, nil
+}
+
+// normalizeArchive 规范化并校验备份内容，不访问存储。
+func normalizeArchive(archive *Archive) error {
+	archive.Sources = normalizeSubscribeURLs(archive.Sources)
+
+	seenProfiles := make(map[string]struct{}, len(archive.Profiles))
+	for i := range archive.Profiles {
+		p := &archive.Profiles[i]
+		p.Name = strings.TrimSpace(p.Name)
+		if p.Name == "" {
+			return fmt.Errorf("profile #%d: name is required", i+1)
+		}
+		if _, dup := seenProfiles[p.Name]; dup {
+			return fmt.Errorf("duplicate profile %s", p.Name)
+		}
+		seenProfiles[p.Name] = struct{}{}
+		if _, err := store.ParseStashProfileContent(p.Content); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+	}
+
+	seenUsers := make(map[string]struct{}, len(archive.Subscribers))
+	seenTokens := make(map[string]string, len(archive.Subscribers))
+	for i := range archive.Subscribers {
+		s := &archive.Subscribers[i]
+		s.Username = strings.TrimSpace(s.Username)
+		s.Token = strings.TrimSpace(s.Token)
+		s.ProfileName = store.DefaultStashProfileNameIfEmpty(s.ProfileName)
+		if s.Username == "" {
+			return fmt.Errorf("subscriber #%d: username is required", i+1)
+		}
+		if _, dup := seenUsers[s.Username]; dup {
+			return fmt.Errorf("duplicate subscriber %s", s.Username)
+		}
+		seenUsers[s.Username] = struct{}{}
+		if s.Token != "" {
+			if other, dup := seenTokens[s.Token]; dup {
+				return fmt.Errorf("subscribers %s and %s share the same token", other, s.Username)
+			}
+			seenTokens[s.Token] = s.Username
+		}
+		if !s.Disabled {
+			s.DisabledReason = ""
+		}
+		tags, vars, err := store.NormalizeSubscriberAttributes(s.Tags, s.Vars)
+		if err != nil {
+			return fmt.Errorf("subscriber %s: %w", s.Username, err)
+		}
+		s.Tags, s.Vars = tags, vars
+		if s.Override, err = store.NormalizeSubscriberOverride(s.Override); err != nil {
+			return fmt.Errorf("subscriber %s override: %w", s.Username, err)
+		}
+	}
+	return nil
+}
+
+func sameProfileContent(a, b string) bool {
+	return strings.TrimSpace(a) == strings.TrimSpace(b)
+}
+
+// ImportArchive 校验并导入备份。先完整校验再写入；写入过程中存储出错时可能只导入了部分数据。
+func ImportArchive(archive *Archive, mode ImportMode, dryRun bool) (*ImportReport, error) {
+	if mode == "" {
+		mode = ImportModeMerge
+	}
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return nil, fmt.Errorf("invalid import mode: %s", mode)
+	}
+	if err := normalizeArchive(archive); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{
+		Mode:        mode,
+		DryRun:      dryRun,
+		Sources:     newImportChanges(),
+		Profiles:    newImportChanges(),
+		Subscribers: newImportChanges(),
+		Warnings:    []string{},
+	}
+
+	// ---- 读取现有状态 ----
+	currentURLs, err := store.GetStoredSubscribeUrls()
+	if err != nil {
+		return nil, err
+	}
+	currentURLs = normalizeSubscribeURLs(currentURLs)
+
+	profileList, err := store.ListStashProfiles()
+	if err != nil {
+		return nil, err
+	}
+	currentProfiles := make(map[string]string, len(profileList))
+	for _, p := range profileList {
+		currentProfiles[p.Name] = p.Content
+	}
+
+	subscriberList, err := store.ListSubscribers()
+	if err != nil {
+		return nil, err
+	}
+	currentSubscribers := make(map[string]store.Subscriber, len(subscriberList))
+	tokenOwners := make(map[string]string, len(subscriberList))
+	for _, s := range subscriberList {
+		currentSubscribers[s.Username] = s
+		tokenOwners[s.Token] = s.Username
+	}
+
+	// ---- 订阅链接 ----
+	targetURLs := archive.Sources
+	if mode == ImportModeMerge {
+		targetURLs = normalizeSubscribeURLs(append(append([]string{}, currentURLs...), archive.Sources...))
+	}
+	inTarget := make(map[string]struct{}, len(targetURLs))
+	for _, u := range targetURLs {
+		inTarget[u] = struct{}{}
+	}
+	inCurrent := make(map[string]struct{}, len(currentURLs))
+	for _, u := range currentURLs {
+		inCurrent[u] = struct{}{}
+		if _, ok := inTarget[u]; !ok {
+			report.Sources.Deleted = append(report.Sources.Deleted, u)
+		}
+	}
+	for _, u := range targetURLs {
+		if _, ok := inCurrent[u]; ok {
+			report.Sources.Unchanged++
+		} else {
+			report.Sources.Created = append(report.Sources.Created, u)
+		}
+	}
+
+	// ---- 模板 ----
+	archiveProfiles := make(map[string]struct{}, len(archive.Profiles))
+	for _, p := range archive.Profiles {
+		archiveProfiles[p.Name] = struct{}{}
+		content, exists := currentProfiles[p.Name]
+		switch {
+		case !exists:
+			report.Profiles.Created = append(report.Profiles.Created, p.Name)
+		case !sameProfileContent(content, p.Content):
+			report.Profiles.Updated = append(report.Profiles.Updated, p.Name)
+		default:
+			report.Profiles.Unchanged++
+		}
+	}
+	if mode == ImportModeReplace {
+		for name := range currentProfiles {
+			if _, keep := archiveProfiles[name]; !keep && name != store.DefaultStashProfileName {
+				report.Profiles.Deleted = append(report.Profiles.Deleted, name)
+			}
+		}
+	}
+
+	// 校验导入后全部模板的 extends 继承关系，并确定写入顺序（父模板在前）。
+	finalProfiles := map[string]string{store.DefaultStashProfileName: currentProfiles[store.DefaultStashProfileName]}
+	if mode == ImportModeMerge {
+		for name, content := range currentProfiles {
+			finalProfiles[name] = content
+		}
+	}
+	for _, p := range archive.Profiles {
+		finalProfiles[p.Name] = p.Content
+	}
+	writeOrder, err := store.SortStashProfilesByExtends(finalProfiles)
+	if err != nil {
+		return nil, err
+	}
+	// 删除顺序：子模板在前，避免删除仍被继承的模板。
+	deleteOrder, sortErr := store.SortStashProfilesByExtends(currentProfiles)
+	if sortErr != nil {
+		deleteOrder = append([]string{}, report.Profiles.Deleted...)
+	}
+
+	// 导入后可用的模板：备份中的、默认模板，以及合并模式下保留的现有模板。
+	availableProfiles := map[string]struct{}{store.DefaultStashProfileName: {}}
+	for name := range archiveProfiles {
+		availableProfiles[name] = struct{}{}
+	}
+	if mode == ImportModeMerge {
+		for name := range currentProfiles {
+			availableProfiles[name] = struct{}{}
+		}
+	}
+
+	// ---- 订阅用户 ----
+	archiveUsers := make(map[string]ArchiveSubscriber, len(archive.Subscribers))
+	for _, s := range archive.Subscribers {
+		archiveUsers[s.Username] = s
+	}
+	// tokenReleased 判断现有用户的 token 在导入时是否会被释放：
+	// 替换模式下该用户被删除，或以备份中的另一个 token 重建。
+	tokenReleased := func(owner string) bool {
+		if mode != ImportModeReplace {
+			return false
+		}
+		entry, kept := archiveUsers[owner]
+		return !kept || entry.Token != ""
+	}
+
+	var toCreate, toRecreate, toUpdate []ArchiveSubscriber
+	for _, s := range archive.Subscribers {
+		if _, ok := availableProfiles[s.ProfileName]; !ok {
+			return nil, fmt.Errorf("subscriber %s references unknown profile %s", s.Username, s.ProfileName)
+		}
+		if owner, taken := tokenOwners[s.Token]; s.Token != "" && taken && owner != s.Username && !tokenReleased(owner) {
+			return nil, fmt.Errorf("token of subscriber %s is already used by %s", s.Username, owner)
+		}
+
+		existing, exists := currentSubscribers[s.Username]
+		if !exists {
+			if s.Token == "" {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("subscriber %s has no token in archive, a new token will be generated", s.Username))
+			}
+			report.Subscribers.Created = append(report.Subscribers.Created, s.Username)
+			toCreate = append(toCreate, s)
+			continue
+		}
+
+		tokenChanged := s.Token != "" && s.Token != existing.Token
+		if tokenChanged && mode == ImportModeMerge {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("subscriber %s exists with a different token, keeping the current token", s.Username))
+			tokenChanged = false
+		}
+		switch {
+		case tokenChanged:
+			report.Subscribers.Updated = append(report.Subscribers.Updated, s.Username)
+			toRecreate = append(toRecreate, s)
+		case existing.ProfileName != s.ProfileName || existing.Disabled != s.Disabled ||
+			!reflect.DeepEqual(existing.Tags, s.Tags) || !reflect.DeepEqual(existing.Vars, s.Vars) ||
+			existing.Override != s.Override:
+			report.Subscribers.Updated = append(report.Subscribers.Updated, s.Username)
+			toUpdate = append(toUpdate, s)
+		default:
+			report.Subscribers.Unchanged++
+		}
+	}
+	if mode == ImportModeReplace {
+		for username := range currentSubscribers {
+			if _, keep := archiveUsers[username]; !keep {
+				report.Subscribers.Deleted = append(report.Subscribers.Deleted, username)
+			}
+		}
+	}
+
+	for _, changes := range []*ImportChanges{&report.Sources, &report.Profiles, &report.Subscribers} {
+		sort.Strings(changes.Deleted)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	// ---- 写入 ----
+	if len(report.Sources.Created) > 0 || len(report.Sources.Deleted) > 0 {
+		if err := store.SaveSubscribeUrls(targetURLs); err != nil {
+			return report, err
+		}
+	}
+
+	archiveProfileContent := make(map[string]string, len(archive.Profiles))
+	for _, p := range archive.Profiles {
+		archiveProfileContent[p.Name] = p.Content
+	}
+	for _, name := range writeOrder {
+		target, inArchive := archiveProfileContent[name]
+		if !inArchive {
+			continue
+		}
+		content, exists := currentProfiles[name]
+		var err error
+		if !exists {
+			err = store.CreateStashProfile(name, target, importRevisionAuthor)
+		} else if !sameProfileContent(content, target) {
+			err = store.UpdateStashProfile(name, target, importRevisionAuthor)
+		}
+		if err != nil {
+			return report, fmt.Errorf("import profile %s: %w", name, err)
+		}
+	}
+
+	// 先删除再创建，释放被替换用户占用的 token。
+	for _, username := range report.Subscribers.Deleted {
+		if err := store.DeleteSubscriber(username); err != nil {
+			return report, fmt.Errorf("delete subscriber %s: %w", username, err)
+		}
+	}
+	for _, s := range toRecreate {
+		if err := store.DeleteSubscriber(s.Username); err != nil {
+			return report, fmt.Errorf("replace subscriber %s: %w", s.Username, err)
+		}
+	}
+	for _, s := range append(toCreate, toRecreate...) {
+		if _, err := store.RestoreSubscriber(store.Subscriber{
+			Username:       s.Username,
+			Token:          s.Token,
+			ProfileName:    s.ProfileName,
+			Disabled:       s.Disabled,
+			DisabledReason: s.DisabledReason,
+			Tags:           s.Tags,
+			Vars:           s.Vars,
+			Override:       s.Override,
+		}); err != nil {
+			return report, fmt.Errorf("import subscriber %s: %w", s.Username, err)
+		}
+	}
+	for _, s := range toUpdate {
+		if err := store.UpdateSubscriberProfile(s.Username, s.ProfileName); err != nil {
+			return report, fmt.Errorf("update subscriber %s: %w", s.Username, err)
+		}
+		if err := store.SetSubscriberDisabled(s.Username, s.Disabled, s.DisabledReason); err != nil {
+			return report, fmt.Errorf("update subscriber %s: %w", s.Username, err)
+		}
+		if err := store.UpdateSubscriberAttributes(s.Username, s.Tags, s.Vars); err != nil {
+			return report, fmt.Errorf("update subscriber %s: %w", s.Username, err)
+		}
+		if err := store.UpdateSubscriberOverride(s.Username, s.Override); err != nil {
+			return report, fmt.Errorf("update subscriber %s: %w", s.Username, err)
+		}
+	}
+
+	toDelete := make(map[string]bool, len(report.Profiles.Deleted))
+	for _, name := range report.Profiles.Deleted {
+		toDelete[name] = true
+	}
+	for i := len(deleteOrder) - 1; i >= 0; i-- {
+		name := deleteOrder[i]
+		if !toDelete[name] {
+			continue
+		}
+		if err := store.DeleteStashProfile(name, ""); err != nil {
+			return report, fmt.Errorf("delete profile %s: %w", name, err)
+		}
+	}
+
+	return report, nil
+}
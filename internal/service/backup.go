@@ -0,0 +1,363 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"my-stash-rule/internal/config"
+)
+
+const (
+	backupFilePrefix    = "stash-rule-backup-"
+	backupFileSuffix    = ".json"
+	backupTimeLayout    = "20060102-150405"
+	backupSchedulerTick = time.Hour
+)
+
+var backupMu sync.Mutex
+
+// BackupInfo 表示一份已保存的备份。
+type BackupInfo struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// backupTarget 备份存放位置（本地目录或 S3 兼容存储）。
+type backupTarget interface {
+	Describe() string
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	Delete(name string) error
+	// List 返回全部备份文件名（不保证顺序）。
+	List() ([]BackupInfo, error)
+}
+
+// backupName 由时间生成备份文件名，文件名按字典序即按时间排序。
+func backupName(t time.Time) string {
+	return backupFilePrefix + t.UTC().Format(backupTimeLayout) + backupFileSuffix
+}
+
+// parseBackupName 解析备份文件名中的时间，非备份文件返回 false。
+func parseBackupName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, backupFileSuffix) {
+		return time.Time{}, false
+	}
+	raw := strings.TrimSuffix(strings.TrimPrefix(name, backupFilePrefix), backupFileSuffix)
+	t, err := time.Parse(backupTimeLayout, raw)
+	return t, err == nil
+}
+
+// ---- 本地目录 ----
+
+type dirBackupTarget struct {
+	dir string
+}
+
+func (d *dirBackupTarget) Describe() string {
+	return "dir:" + d.dir
+}
+
+func (d *dirBackupTarget) Put(name string, data []byte) error {
+	if err := os.MkdirAll(d.dir, 0o700); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免留下不完整的备份。
+	tmp, err := os.CreateTemp(d.dir, ".tmp-"+name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+}
+
+func (d *dirBackupTarget) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.dir, name))
+}
+
+func (d *dirBackupTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(d.dir, name))
+}
+
+func (d *dirBackupTarget) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(d.dir)
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupInfo, 0, len(entries))
+	for _, entry := range entries {
+		created, ok := parseBackupName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: entry.Name(), Size: info.Size(), CreatedAt: created.Unix()})
+	}
+	return backups, nil
+}
+
+// ---- S3 兼容存储 ----
+
+type s3BackupTarget struct {
+	client *s3Client
+	prefix string
+}
+
+func (s *s3BackupTarget) Describe() string {
+	return "s3:" + s.client.bucket + "/" + s.prefix
+}
+
+func (s *s3BackupTarget) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3BackupTarget) Put(name string, data []byte) error {
+	return s.client.PutObject(s.key(name), data)
+}
+
+func (s *s3BackupTarget) Get(name string) ([]byte, error) {
+	return s.client.GetObject(s.key(name))
+}
+
+func (s *s3BackupTarget) Delete(name string) error {
+	return s.client.DeleteObject(s.key(name))
+}
+
+func (s *s3BackupTarget) List() ([]BackupInfo, error) {
+	objects, err := s.client.ListObjects(s.key(backupFilePrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupInfo, 0, len(objects))
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, s.key(""))
+		created, ok := parseBackupName(name)
+		if !ok {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: name, Size: object.Size, CreatedAt: created.Unix()})
+	}
+	return backups, nil
+}
+
+// newBackupTarget 按配置创建备份目标，未配置时返回 nil。
+func newBackupTarget(cfg config.BackupConfig) (backupTarget, error) {
+	if cfg.S3Bucket != "" {
+		client, err := newS3Client(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle)
+		if err != nil {
+			return nil, err
+		}
+		return &s3BackupTarget{client: client, prefix: cfg.S3Prefix}, nil
+	}
+	if cfg.Dir != "" {
+		return &dirBackupTarget{dir: cfg.Dir}, nil
+	}
+	return nil, nil
+}
+
+// ErrBackupNotConfigured 未设置 BACKUP_DIR / BACKUP_S3_BUCKET。
+var ErrBackupNotConfigured = errors.New("backup target not configured")
+
+func configuredBackupTarget() (backupTarget, config.BackupConfig, error) {
+	cfg := config.GetBackupConfig()
+	target, err := newBackupTarget(cfg)
+	if err != nil {
+		return nil, cfg, err
+	}
+	if target == nil {
+		return nil, cfg, ErrBackupNotConfigured
+	}
+	return target, cfg, nil
+}
+
+// sortBackups 按时间倒序（最新在前）。
+func sortBackups(backups []BackupInfo) {
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+}
+
+// ListBackups 列出已保存的备份（最新在前）。
+func ListBackups() ([]BackupInfo, error) {
+	target, _, err := configuredBackupTarget()
+	if err != nil {
+		return nil, err
+	}
+	backups, err := target.List()
+	if err != nil {
+		return nil, err
+	}
+	sortBackups(backups)
+	return backups, nil
+}
+
+// RunBackup 立即导出一份备份写入备份目标，并按保留数量清理旧备份。
+func RunBackup() (BackupInfo, error) {
+	target, cfg, err := configuredBackupTarget()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return runBackup(target, cfg.Retention, cfg.IncludeTokens, time.Now())
+}
+
+func runBackup(target backupTarget, retention int, includeTokens bool, now time.Time) (BackupInfo, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	archive, err := ExportArchive(includeTokens)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	archive.ExportedAt = now.Unix()
+	data, _, err := EncodeArchive(archive, "json")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	info := BackupInfo{Name: backupName(now), Size: int64(len(data)), CreatedAt: now.Unix()}
+	if err := target.Put(info.Name, data); err != nil {
+		return BackupInfo{}, fmt.Errorf("write backup %s: %w", info.Name, err)
+	}
+
+	if err := pruneBackups(target, retention); err != nil {
+		log.Printf("Failed to prune old backups: %v", err)
+	}
+	return info, nil
+}
+
+// pruneBackups 只保留最新的 retention 份备份。
+func pruneBackups(target backupTarget, retention int) error {
+	backups, err := target.List()
+	if err != nil {
+		return err
+	}
+	sortBackups(backups)
+	if len(backups) <= retention {
+		return nil
+	}
+	for _, old := range backups[retention:] {
+		if err := target.Delete(old.Name); err != nil {
+			return fmt.Errorf("delete backup %s: %w", old.Name, err)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup 从指定备份恢复，name 为空时使用最新的备份。
+func RestoreBackup(name string, mode ImportMode, dryRun bool) (string, *ImportReport, error) {
+	target, _, err := configuredBackupTarget()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if name == "" {
+		backups, err := target.List()
+		if err != nil {
+			return "", nil, err
+		}
+		if len(backups) == 0 {
+			return "", nil, fmt.Errorf("no backups found")
+		}
+		sortBackups(backups)
+		name = backups[0].Name
+	}
+	if _, ok := parseBackupName(name); !ok {
+		return "", nil, fmt.Errorf("invalid backup name: %s", name)
+	}
+
+	data, err := target.Get(name)
+	if err != nil {
+		return name, nil, fmt.Errorf("read backup %s: %w", name, err)
+	}
+	archive, err := DecodeArchive(data)
+	if err != nil {
+		return name, nil, err
+	}
+	report, err := ImportArchive(archive, mode, dryRun)
+	return name, report, err
+}
+
+func shouldRunBackup(target backupTarget, interval time.Duration, now time.Time) (bool, error) {
+	backups, err := target.List()
+	if err != nil {
+		return false, err
+	}
+	if len(backups) == 0 {
+		return true, nil
+	}
+	sortBackups(backups)
+	return now.Sub(time.Unix(backups[0].CreatedAt, 0)) >= interval, nil
+}
+
+// StartBackupScheduler 后台启动定时备份；未配置备份目标时不启动。
+// 以最新一份备份的时间判断是否到期，进程重启不会重复备份。
+func StartBackupScheduler() {
+	target, cfg, err := configuredBackupTarget()
+	if errors.Is(err, ErrBackupNotConfigured) {
+		return
+	}
+	if err != nil {
+		log.Printf("Backup scheduler disabled: %v", err)
+		return
+	}
+	log.Printf("Backup scheduler started (%s, every %s, keep %d)", target.Describe(), cfg.Interval, cfg.Retention)
+
+	check := func(now time.Time) {
+		ok, err := shouldRunBackup(target, cfg.Interval, now)
+		if err != nil {
+			log.Printf("Failed to check backup schedule: %v", err)
+			return
+		}
+		if !ok {
+			return
+		}
+		info, err := runBackup(target, cfg.Retention, cfg.IncludeTokens, now)
+		if err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			return
+		}
+		log.Printf("Scheduled backup written: %s (%d bytes)", info.Name, info.Size)
+	}
+
+	go func() {
+		check(time.Now())
+
+		tick := backupSchedulerTick
+		if cfg.Interval < tick {
+			tick = cfg.Interval
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			check(now)
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"my-stash-rule/internal/store"
+)
+
+// RenderedConfig 按指定格式生成好的最终配置。
+type RenderedConfig struct {
+	Body []byte
+	// ETag 为输出内容的哈希（强校验，已带引号），内容不变时跨实例、跨重启保持一致。
+	ETag string
+	// ModifiedAt 输出内容最近一次发生变化的时间（秒级）。
+	ModifiedAt time.Time
+	// Result 为本次实际生成的结果；命中缓存时为 nil。
+	Result *RenderResult
+}
+
+type renderedConfigKey struct {
+	username   string
+	subscriber bool
+	profile    string
+	format     string
+}
+
+type renderedConfigEntry struct {
+	// version 生成时的 store.DataVersion。
+	version int64
+	config  RenderedConfig
+}
+
+// renderedConfigs 每个（用户, 格式）只保留最近一次的输出，输入变化后被新结果替换。
+var renderedConfigs = struct {
+	sync.Mutex
+	entries map[renderedConfigKey]*renderedConfigEntry
+}{entries: make(map[renderedConfigKey]*renderedConfigEntry)}
+
+// InvalidateRenderedConfigs 清空已生成配置的缓存。
+// 缓存按数据版本号命中，数据修改后自然失效；此函数用于更换存储后端（版本号重新计数）等场景。
+func InvalidateRenderedConfigs() {
+	renderedConfigs.Lock()
+	renderedConfigs.entries = make(map[renderedConfigKey]*renderedConfigEntry)
+	renderedConfigs.Unlock()
+}
+
+// RenderConfigCached 同 RenderConfig 并转换为 format。影响生成结果的数据（订阅链接、节点缓存、模板、订阅用户）
+// 未修改时（store.DataVersion 不变）直接返回上次生成的结果，不再读取这些数据、渲染模板和编码 YAML。
+// req.Drafts 不为空时不使用缓存。
+func RenderConfigCached(req RenderRequest, format string) (*RenderedConfig, error) {
+	if format == "" {
+		format = ConfigFormatYAML
+	}
+	if len(req.Drafts) > 0 {
+		return renderConfigFormat(req, format, nil)
+	}
+
+	// 先读版本号再生成：生成期间数据被修改时，结果只返回给本次请求，不写入缓存。
+	version, err := store.DataVersion()
+	if err != nil {
+		return nil, err
+	}
+	key := renderedConfigKey{username: req.Username, subscriber: req.Subscriber, profile: req.ProfileName, format: format}
+	renderedConfigs.Lock()
+	previous := renderedConfigs.entries[key]
+	renderedConfigs.Unlock()
+	if previous != nil && previous.version == version {
+		cached := previous.config
+		return &cached, nil
+	}
+
+	var previousConfig *RenderedConfig
+	if previous != nil {
+		previousConfig = &previous.config
+	}
+	rendered, err := renderConfigFormat(req, format, previousConfig)
+	if err != nil {
+		if errors.Is(err, ErrSubscriberNotFound) {
+			renderedConfigs.Lock()
+			delete(renderedConfigs.entries, key)
+			renderedConfigs.Unlock()
+		}
+		return nil, err
+	}
+
+	if current, err := store.DataVersion(); err != nil || current != version {
+		return rendered, nil
+	}
+	stored := *rendered
+	stored.Result = nil
+	renderedConfigs.Lock()
+	renderedConfigs.entries[key] = &renderedConfigEntry{version: version, config: stored}
+	renderedConfigs.Unlock()
+	return rendered, nil
+}
+
+// renderConfigFormat 生成配置并计算 ETag；输出与 previous 相同时沿用其 ModifiedAt。
+func renderConfigFormat(req RenderRequest, format string, previous *RenderedConfig) (*RenderedConfig, error) {
+	result, err := RenderConfig(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ConvertConfigFormat(result.Output, format)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	rendered := &RenderedConfig{
+		Body:       body,
+		ETag:       `"` + hex.EncodeToString(sum[:16]) + `"`,
+		ModifiedAt: time.Now().Truncate(time.Second),
+		Result:     result,
+	}
+	if previous != nil && previous.ETag == rendered.ETag {
+		rendered.ModifiedAt = previous.ModifiedAt
+	}
+	return rendered, nil
+}
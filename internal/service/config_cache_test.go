@@ -0,0 +1,114 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/model"
+	"my-stash-rule/internal/store"
+)
+
+func TestRenderConfigCached(t *testing.T) {
+	useMemoryStore(t)
+	const url = "https://example.com/sub"
+	if err := store.SaveSubscribeUrls([]string{url}); err != nil {
+		t.Fatal(err)
+	}
+	nodes := []model.ProxyNode{{"name": "HK 01", "type": "trojan", "server": "hk1.example.com", "port": 443}}
+	if err := store.SaveProxyCache(url, nodes, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddSubscriber("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	req := RenderRequest{Username: "alice", Subscriber: true}
+
+	first, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Result == nil || first.ETag == "" {
+		t.Fatalf("expected a fresh render, got %+v", first)
+	}
+
+	// 拉取统计变化不影响缓存
+	if _, err := RecordConfigFetch("alice", "203.0.113.1", "Stash/2.0"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Result != nil || second.ETag != first.ETag || string(second.Body) != string(first.Body) {
+		t.Errorf("expected cached render, got %+v", second)
+	}
+
+	if err := store.UpdateSubscriberAttributes("alice", []string{"vip"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	third, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 标签未被模板使用：重新生成，但输出与 ETag 不变
+	if third.Result == nil || third.ETag != first.ETag || !third.ModifiedAt.Equal(first.ModifiedAt) {
+		t.Errorf("expected re-render with same output, got %+v", third)
+	}
+
+	nodes = append(nodes, model.ProxyNode{"name": "JP 01", "type": "trojan", "server": "jp1.example.com", "port": 443})
+	if err := store.SaveProxyCache(url, nodes, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fourth.Result == nil || fourth.ETag == first.ETag || !strings.Contains(string(fourth.Body), "JP 01") {
+		t.Errorf("expected new config after node change, got etag %s", fourth.ETag)
+	}
+
+	if _, err := RenderConfigCached(RenderRequest{Username: "bob", Subscriber: true}, ConfigFormatYAML); err != ErrSubscriberNotFound {
+		t.Errorf("expected ErrSubscriberNotFound, got %v", err)
+	}
+}
+
+func TestRenderConfigCachedSkipsResultWhenDataChanges(t *testing.T) {
+	useMemoryStore(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("trojan://secret@hk1.example.com:443#HK%2001"))
+	}))
+	t.Cleanup(srv.Close)
+	if err := store.SaveSubscribeUrls([]string{srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddSubscriber("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	req := RenderRequest{Username: "alice", Subscriber: true}
+
+	// 生成过程中补拉缺失的节点缓存会修改数据，这次结果不能写入缓存。
+	first, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil || first.Result == nil || !strings.Contains(string(first.Body), "HK 01") {
+		t.Fatalf("first render = %+v, %v", first, err)
+	}
+	second, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil || second.Result == nil {
+		t.Fatalf("expected a re-render after data changed during the first one, got %+v, %v", second, err)
+	}
+	third, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil || third.Result != nil || third.ETag != second.ETag {
+		t.Fatalf("expected cached render, got %+v, %v", third, err)
+	}
+
+	// 修改模板后重新生成。
+	if err := store.UpdateStashProfile(store.DefaultStashProfileName, "mode: global\n", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := RenderConfigCached(req, ConfigFormatYAML)
+	if err != nil || fourth.Result == nil || fourth.ETag == third.ETag {
+		t.Errorf("expected new config after profile change, got %+v, %v", fourth, err)
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells 行级 diff 的最大计算量（去掉首尾相同行后 旧行数 × 新行数）。
+const maxDiffCells = 4_000_000
+
+// DiffOp 差异行类型。
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+// DiffLine 差异中的一行。
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffResult 两段文本的行级差异。
+type DiffResult struct {
+	Lines   []DiffLine `json:"lines"`
+	Added   int        `json:"added"`
+	Removed int        `json:"removed"`
+}
+
+func splitDiffLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// DiffLines 基于最长公共子序列计算 from -> to 的行级差异。
+func DiffLines(from, to string) (*DiffResult, error) {
+	a, b := splitDiffLines(from), splitDiffLines(to)
+
+	// 先去掉相同的首尾行，缩小计算范围。
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		return nil, fmt.Errorf("content too large to diff")
+	}
+
+	result := &DiffResult{Lines: make([]DiffLine, 0, len(a)+len(b))}
+	for _, line := range a[:prefix] {
+		result.Lines = append(result.Lines, DiffLine{Op: DiffEqual, Text: line})
+	}
+
+	// lcs[i][j] 为 midA[i:] 与 midB[j:] 的最长公共子序列长度。
+	n, m := len(midA), len(midB)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && midA[i] == midB[j]:
+			result.Lines = append(result.Lines, DiffLine{Op: DiffEqual, Text: midA[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+			result.Lines = append(result.Lines, DiffLine{Op: DiffInsert, Text: midB[j]})
+			result.Added++
+			j++
+		default:
+			result.Lines = append(result.Lines, DiffLine{Op: DiffDelete, Text: midA[i]})
+			result.Removed++
+			i++
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		result.Lines = append(result.Lines, DiffLine{Op: DiffEqual, Text: line})
+	}
+	return result, nil
+}
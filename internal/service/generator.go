@@ -9,13 +9,23 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// proxyRegions 按节点名称识别的地区，顺序即地区分组的顺序。
+var proxyRegions = []string{"HK", "TW", "JP", "SG", "US", "KR"}
+
 // GenerateConfig generates the complete Stash YAML configuration
+// References that no longer resolve are pruned; see GenerateConfigFromMap.
 func GenerateConfig(proxies []ProxyNode, overlays ...map[string]interface{}) ([]byte, error) {
+	configBytes, _, err := GenerateConfigFromMap(BuildMergedConfig(proxies, overlays...))
+	return configBytes, err
+}
+
+// BuildMergedConfig builds the dynamic configuration and merges overlays onto it in order.
+func BuildMergedConfig(proxies []ProxyNode, overlays ...map[string]interface{}) map[string]interface{} {
 	config := BuildConfigMap(proxies)
 	for _, overlay := range overlays {
 		config = DeepMergeMap(config, overlay)
 	}
-	return GenerateConfigFromMap(config)
+	return config
 }
 
 // BuildConfigMap generates the default dynamic configuration map.
@@ -28,8 +38,7 @@ func BuildConfigMap(proxies []ProxyNode) map[string]interface{} {
 	}
 
 	regionGroups := make(map[string][]string)
-	regions := []string{"HK", "TW", "JP", "SG", "US", "KR"}
-	for _, r := range regions {
+	for _, r := range proxyRegions {
 		regionGroups[r] = []string{}
 	}
 
@@ -89,19 +98,24 @@ func BuildConfigMap(proxies []ProxyNode) map[string]interface{} {
 	return config
 }
 
-// GenerateConfigFromMap encodes a config map into YAML bytes.
-func GenerateConfigFromMap(config map[string]interface{}) ([]byte, error) {
+// GenerateConfigFromMap prunes dangling proxy / group references (see PruneDanglingReferences)
+// and encodes the config map into YAML bytes, returning what was changed.
+func GenerateConfigFromMap(config map[string]interface{}) ([]byte, []ConfigChange, error) {
+	config, changes := PruneDanglingReferences(config)
+
 	var buf bytes.Buffer
 	enc := yaml.NewEncoder(&buf)
 	enc.SetIndent(2)
 	err := enc.Encode(config)
-	return buf.Bytes(), err
+	return buf.Bytes(), changes, err
 }
 
 func toStringMap(value interface{}) (map[string]interface{}, bool) {
 	switch typed := value.(type) {
 	case map[string]interface{}:
 		return typed, true
+	case ProxyNode:
+		return typed, true
 	case map[interface{}]interface{}:
 		out := make(map[string]interface{}, len(typed))
 		for k, v := range typed {
@@ -168,57 +182,66 @@ func toInterfaceSlice(value interface{}) ([]interface{}, bool) {
 	return out, true
 }
 
-func mergeSlices(base, override []interface{}) []interface{} {
+// mergeSlices 合并数组：先处理覆盖数组中的 $delete / $patch 元素，
+// 其余元素按 mode 放在原数组之前（默认、prepend）或之后（append）。
+func mergeSlices(base, override []interface{}, mode mergeMode) []interface{} {
 	out := make([]interface{}, 0, len(base)+len(override))
-	for _, item := range override {
-		out = append(out, cloneValue(item))
-	}
 	for _, item := range base {
 		out = append(out, cloneValue(item))
 	}
-	return out
-}
 
-// DeepMergeMap deep merges override into base. Map values are merged recursively.
-// Map values are merged recursively; arrays are concatenated (override + base);
-// other value types are replaced by override.
-func DeepMergeMap(base, override map[string]interface{}) map[string]interface{} {
-	if base == nil && override == nil {
-		return map[string]interface{}{}
-	}
-	if base == nil {
-		if override == nil {
-			return map[string]interface{}{}
+	items := make([]interface{}, 0, len(override))
+	for _, item := range override {
+		var isDirective bool
+		if out, isDirective = applySliceDirective(out, item); isDirective {
+			continue
 		}
-		cloned, _ := cloneValue(override).(map[string]interface{})
-		return cloned
+		items = append(items, normalizeOverride(item))
 	}
-	if override == nil {
-		cloned, _ := cloneValue(base).(map[string]interface{})
-		return cloned
+
+	if mode == mergeAppend {
+		return append(out, items...)
 	}
+	return append(items, out...)
+}
 
-	result := make(map[string]interface{}, len(base))
+// DeepMergeMap deep merges override into base.
+// Map values are merged recursively; arrays are concatenated (override + base);
+// other value types are replaced by override. Merge directives in override keys
+// (key!, +key, key+, $delete, $patch) are documented in merge.go.
+func DeepMergeMap(base, override map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(override))
 	for key, baseValue := range base {
 		result[key] = cloneValue(baseValue)
 	}
 
-	for key, overrideValue := range override {
+	for _, rawKey := range sortedMergeKeys(override) {
+		overrideValue := override[rawKey]
+		key, mode := parseMergeKey(rawKey, overrideValue)
+		if isDeleteMarker(overrideValue) {
+			delete(result, key)
+			continue
+		}
+
 		currentValue, exists := result[key]
+		if !exists || mode == mergeReplace {
+			result[key] = normalizeOverride(overrideValue)
+			continue
+		}
+
 		overrideMap, overrideIsMap := toStringMap(overrideValue)
 		currentMap, currentIsMap := toStringMap(currentValue)
-		overrideSlice, overrideIsSlice := toInterfaceSlice(overrideValue)
-		currentSlice, currentIsSlice := toInterfaceSlice(currentValue)
-
-		if exists && currentIsMap && overrideIsMap {
+		if currentIsMap && overrideIsMap {
 			result[key] = DeepMergeMap(currentMap, overrideMap)
 			continue
 		}
-		if exists && currentIsSlice && overrideIsSlice {
-			result[key] = mergeSlices(currentSlice, overrideSlice)
+		overrideSlice, overrideIsSlice := toInterfaceSlice(overrideValue)
+		currentSlice, currentIsSlice := toInterfaceSlice(currentValue)
+		if currentIsSlice && overrideIsSlice {
+			result[key] = mergeSlices(currentSlice, overrideSlice, mode)
 			continue
 		}
-		result[key] = cloneValue(overrideValue)
+		result[key] = normalizeOverride(overrideValue)
 	}
 
 	return result
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+// LeakCheckResult 表示一次订阅拉取后的泄露检测结果。
+type LeakCheckResult struct {
+	Usage        store.FetchUsage
+	Alert        *store.LeakAlert
+	AutoDisabled bool
+}
+
+// RecordConfigFetch 记录订阅 token 的一次拉取，并在窗口内不同 IP / 客户端数超过阈值时
+// 产生告警（同一窗口内只告警一次），按配置自动禁用 token。
+func RecordConfigFetch(username, ip, client string) (LeakCheckResult, error) {
+	now := time.Now()
+	window := config.GetLeakDetectWindow()
+
+	usage, err := store.RecordSubscriberFetch(username, ip, client, now, window)
+	if err != nil {
+		return LeakCheckResult{}, err
+	}
+	result := LeakCheckResult{Usage: usage}
+
+	maxIPs := config.GetLeakDetectMaxIPs()
+	maxClients := config.GetLeakDetectMaxClients()
+
+	var reasons []string
+	if maxIPs > 0 && usage.DistinctIPs > int64(maxIPs) {
+		reasons = append(reasons, fmt.Sprintf("distinct ips %d > %d", usage.DistinctIPs, maxIPs))
+	}
+	if maxClients > 0 && usage.DistinctClients > int64(maxClients) {
+		reasons = append(reasons, fmt.Sprintf("distinct clients %d > %d", usage.DistinctClients, maxClients))
+	}
+	if len(reasons) == 0 {
+		return result, nil
+	}
+
+	first, err := store.MarkSubscriberFlagged(username, window)
+	if err != nil {
+		return result, err
+	}
+	if !first {
+		return result, nil
+	}
+
+	ips, clients, err := store.ListSubscriberFetchSources(username)
+	if err != nil {
+		return result, err
+	}
+
+	alert := store.LeakAlert{
+		Username:        username,
+		CreatedAt:       now.Unix(),
+		DistinctIPs:     usage.DistinctIPs,
+		DistinctClients: usage.DistinctClients,
+		IPs:             ips,
+		Clients:         clients,
+		Reason:          strings.Join(reasons, "; "),
+	}
+
+	if config.GetLeakDetectAutoDisable() {
+		if err := store.SetSubscriberDisabled(username, true, "leak: "+alert.Reason); err != nil {
+			return result, err
+		}
+		alert.AutoDisabled = true
+		result.AutoDisabled = true
+	}
+
+	if err := store.AddLeakAlert(alert); err != nil {
+		return result, err
+	}
+	log.Printf("Possible token leak for subscriber %s: %s (auto_disabled=%t)", username, alert.Reason, alert.AutoDisabled)
+
+	result.Alert = &alert
+	return result, nil
+}
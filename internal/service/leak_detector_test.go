@@ -0,0 +1,54 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"my-stash-rule/internal/store"
+)
+
+func TestRecordConfigFetchAlertThresholds(t *testing.T) {
+	useMemoryStore(t)
+	t.Setenv("LEAK_DETECT_WINDOW", "1h")
+	t.Setenv("LEAK_DETECT_MAX_IPS", "2")
+	t.Setenv("LEAK_DETECT_MAX_CLIENTS", "0")
+	t.Setenv("LEAK_DETECT_AUTO_DISABLE", "true")
+	if _, err := store.AddSubscriber("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		result, err := RecordConfigFetch("alice", fmt.Sprintf("198.51.100.%d", i), fmt.Sprintf("client-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Alert != nil || result.AutoDisabled {
+			t.Fatalf("fetch %d alerted at threshold: %+v", i, result)
+		}
+	}
+
+	result, err := RecordConfigFetch("alice", "198.51.100.3", "client-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Alert == nil || !result.AutoDisabled || result.Alert.DistinctIPs != 3 {
+		t.Fatalf("expected alert above threshold, got %+v", result)
+	}
+	subscriber, err := store.GetSubscriber("alice")
+	if err != nil || subscriber == nil || !subscriber.Disabled {
+		t.Errorf("subscriber not disabled: %+v %v", subscriber, err)
+	}
+
+	// 同一窗口内只告警一次；客户端数阈值为 0 时不检测
+	result, err = RecordConfigFetch("alice", "198.51.100.4", "client-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Alert != nil {
+		t.Errorf("alerted twice in one window: %+v", result.Alert)
+	}
+	alerts, err := store.ListLeakAlerts()
+	if err != nil || len(alerts) != 1 {
+		t.Errorf("alerts = %+v %v", alerts, err)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 模板中的合并指令：
+//
+//	key!: value              替换原值，不做合并
+//	+key: [...]              数组插入到原数组之前（未加指令时的默认行为）
+//	key+: [...]              数组追加到原数组之后
+//	key: $delete             删除该字段
+//	- $delete: x             （数组元素）删除原数组中等于 x 或 name 为 x 的元素
+//	- $patch: name           （数组元素）按 name 找到原数组中的元素，将其余字段深度合并进去
+//
+// 只有去掉指令后的字段名由字母、数字、- 和 _ 组成时才视为指令，+key / key+ 还要求值为数组；
+// 因此 hosts、nameserver-policy 中的 +.lan、+.corp.example.com 等域名通配键保持原样。
+const (
+	mergeDeleteMarker = "$delete"
+	mergePatchKey     = "$patch"
+)
+
+type mergeMode int
+
+const (
+	mergeDefault mergeMode = iota
+	mergeReplace
+	mergePrepend
+	mergeAppend
+)
+
+// parseMergeKey 按字段名与覆盖值拆出字段名与合并指令，不满足指令条件的字段名原样返回。
+func parseMergeKey(key string, value interface{}) (string, mergeMode) {
+	if len(key) < 2 {
+		return key, mergeDefault
+	}
+	_, isSlice := toInterfaceSlice(value)
+	switch {
+	case strings.HasSuffix(key, "!") && isMergeFieldName(strings.TrimSuffix(key, "!")):
+		return strings.TrimSuffix(key, "!"), mergeReplace
+	case strings.HasPrefix(key, "+") && isSlice && isMergeFieldName(strings.TrimPrefix(key, "+")):
+		return strings.TrimPrefix(key, "+"), mergePrepend
+	case strings.HasSuffix(key, "+") && isSlice && isMergeFieldName(strings.TrimSuffix(key, "+")):
+		return strings.TrimSuffix(key, "+"), mergeAppend
+	}
+	return key, mergeDefault
+}
+
+// isMergeFieldName 是否为普通配置字段名（如 rules、proxy-groups），域名、geosite:cn 等不是。
+func isMergeFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedMergeKeys 不带指令的字段先处理，其余按字段名排序，保证结果稳定。
+func sortedMergeKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		_, mi := parseMergeKey(keys[i], m[keys[i]])
+		_, mj := parseMergeKey(keys[j], m[keys[j]])
+		if (mi == mergeDefault) != (mj == mergeDefault) {
+			return mi == mergeDefault
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func isDeleteMarker(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && s == mergeDeleteMarker
+}
+
+// normalizeOverride 没有可合并的原值时，去掉覆盖值中的合并指令。
+func normalizeOverride(value interface{}) interface{} {
+	if m, ok := toStringMap(value); ok {
+		return DeepMergeMap(map[string]interface{}{}, m)
+	}
+	if s, ok := toInterfaceSlice(value); ok {
+		return mergeSlices(nil, s, mergeDefault)
+	}
+	return value
+}
+
+// sliceItemMatches 数组元素等于 target，或是 name 为 target 的 map。
+func sliceItemMatches(item, target interface{}) bool {
+	if m, ok := toStringMap(item); ok {
+		if name, ok := m["name"].(string); ok {
+			if t, ok := target.(string); ok && name == t {
+				return true
+			}
+		}
+	}
+	return reflect.DeepEqual(item, target)
+}
+
+// applySliceDirective 处理数组元素上的 $delete / $patch 指令，返回是否为指令元素。
+func applySliceDirective(items []interface{}, directive interface{}) ([]interface{}, bool) {
+	m, ok := toStringMap(directive)
+	if !ok {
+		return items, false
+	}
+
+	if target, ok := m[mergeDeleteMarker]; ok && len(m) == 1 {
+		kept := items[:0]
+		for _, item := range items {
+			if !sliceItemMatches(item, target) {
+				kept = append(kept, item)
+			}
+		}
+		return kept, true
+	}
+
+	if rawTarget, ok := m[mergePatchKey]; ok {
+		target, _ := rawTarget.(string)
+		patch := make(map[string]interface{}, len(m)-1)
+		for key, value := range m {
+			if key != mergePatchKey {
+				patch[key] = value
+			}
+		}
+		for i, item := range items {
+			if itemMap, ok := toStringMap(item); ok && sliceItemMatches(itemMap, target) {
+				items[i] = DeepMergeMap(itemMap, patch)
+				return items, true
+			}
+		}
+		log.Printf("Warning: %s target %q not found, ignored", mergePatchKey, target)
+		return items, true
+	}
+
+	return items, false
+}
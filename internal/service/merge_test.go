@@ -0,0 +1,100 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func mustYAMLMap(t *testing.T, content string) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &out); err != nil {
+		t.Fatalf("unmarshal %q: %v", content, err)
+	}
+	return out
+}
+
+func assertMerged(t *testing.T, base, override, want string) {
+	t.Helper()
+	got := DeepMergeMap(mustYAMLMap(t, base), mustYAMLMap(t, override))
+	if expected := mustYAMLMap(t, want); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("merge result mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+}
+
+func TestDeepMergeMapDefault(t *testing.T) {
+	assertMerged(t,
+		"dns: {enable: true, nameserver: [1.1.1.1]}\nrules: [A]\nmode: rule",
+		"dns: {nameserver: [8.8.8.8]}\nrules: [B]\nmode: global",
+		"dns: {enable: true, nameserver: [8.8.8.8, 1.1.1.1]}\nrules: [B, A]\nmode: global",
+	)
+}
+
+func TestDeepMergeMapReplace(t *testing.T) {
+	assertMerged(t,
+		"dns: {enable: true, nameserver: [1.1.1.1]}\nrules: [A]",
+		"dns!: {nameserver: [8.8.8.8]}\nrules!: [B]",
+		"dns: {nameserver: [8.8.8.8]}\nrules: [B]",
+	)
+}
+
+func TestDeepMergeMapPrependAndAppend(t *testing.T) {
+	assertMerged(t,
+		"rules: [A, B]\nhosts: [x]",
+		"+rules: [P]\nhosts+: [y]",
+		"rules: [P, A, B]\nhosts: [x, y]",
+	)
+	// 同时出现普通字段与追加指令：先按默认方式合并，再追加
+	assertMerged(t,
+		"rules: [A]",
+		"rules: [P]\nrules+: [Z]",
+		"rules: [P, A, Z]",
+	)
+}
+
+func TestDeepMergeMapDeleteKey(t *testing.T) {
+	assertMerged(t,
+		"dns: {enable: true, fallback: [1.1.1.1]}\nmode: rule",
+		"dns: {fallback: $delete}\nmode: $delete\nmissing: $delete",
+		"dns: {enable: true}",
+	)
+}
+
+func TestDeepMergeMapDeleteSliceItem(t *testing.T) {
+	assertMerged(t,
+		"rules: [A, B, C]\nproxy-groups: [{name: G1, type: select}, {name: G2, type: select}]",
+		"rules: [{$delete: B}, N]\nproxy-groups: [{$delete: G1}]",
+		"rules: [N, A, C]\nproxy-groups: [{name: G2, type: select}]",
+	)
+}
+
+func TestDeepMergeMapPatchSliceItem(t *testing.T) {
+	assertMerged(t,
+		"proxy-groups: [{name: G1, type: select, proxies: [a]}, {name: G2, type: select, proxies: [b]}]",
+		"proxy-groups: [{$patch: G2, type: url-test, proxies+: [c]}, {$patch: missing, type: fallback}]",
+		"proxy-groups: [{name: G1, type: select, proxies: [a]}, {name: G2, type: url-test, proxies: [b, c]}]",
+	)
+}
+
+func TestDeepMergeMapStripsDirectivesWithoutBase(t *testing.T) {
+	got := DeepMergeMap(nil, mustYAMLMap(t, "dns!: {fallback+: [x], ipv6: $delete}\nrules: [{$delete: A}, B]"))
+	want := mustYAMLMap(t, "dns: {fallback: [x]}\nrules: [B]")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDeepMergeMapKeepsDomainWildcardKeys(t *testing.T) {
+	assertMerged(t,
+		"hosts: {router.lan: 192.168.1.1}\ndns: {nameserver-policy: {geosite:cn: 223.5.5.5}}",
+		`hosts: {"+.lan": 192.168.1.1, "+.home.arpa": [10.0.0.1, 10.0.0.2], "example.com!": 1.2.3.4}
+dns: {nameserver-policy: {"+.corp.example.com": [10.0.0.53, 10.0.0.54], "+.internal": 10.0.0.53}}`,
+		`hosts: {router.lan: 192.168.1.1, "+.lan": 192.168.1.1, "+.home.arpa": [10.0.0.1, 10.0.0.2], "example.com!": 1.2.3.4}
+dns: {nameserver-policy: {geosite:cn: 223.5.5.5, "+.corp.example.com": [10.0.0.53, 10.0.0.54], "+.internal": 10.0.0.53}}`,
+	)
+	// 值不是数组时 +key 不是指令。
+	assertMerged(t, "rules: [A]", `"+rules": x`, `rules: [A]
+"+rules": x`)
+}
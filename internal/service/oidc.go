@@ -0,0 +1,531 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // RS384/RS512/ES384/ES512 使用的哈希
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+// oidcStateTTL 授权请求从跳转到回调的最长时间
+const oidcStateTTL = 10 * time.Minute
+
+// oidcClockSkew 校验 ID Token 时间时允许的时钟偏差
+const oidcClockSkew = 2 * time.Minute
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider 身份提供方的 discovery 元数据（只取用到的字段）
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcProviderMu    sync.Mutex
+	oidcProviderCache *oidcProvider
+	oidcProviderFor   string
+)
+
+// oidcJWK 身份提供方公布的签名公钥（只取 RSA / EC 用到的字段）
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var (
+	oidcKeysMu    sync.Mutex
+	oidcKeysCache = map[string][]oidcJWK{}
+)
+
+// OIDCIdentity 单点登录成功后解析出的管理员身份
+type OIDCIdentity struct {
+	Username string
+	Groups   []string
+	Role     store.AdminRole
+}
+
+// discoverOIDCProvider 读取 issuer 的 /.well-known/openid-configuration，结果按 issuer 缓存。
+func discoverOIDCProvider(issuer string) (*oidcProvider, error) {
+	oidcProviderMu.Lock()
+	defer oidcProviderMu.Unlock()
+
+	if oidcProviderCache != nil && oidcProviderFor == issuer {
+		return oidcProviderCache, nil
+	}
+
+	var provider oidcProvider
+	if err := oidcGetJSON(issuer+"/.well-known/openid-configuration", "", &provider); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if strings.TrimRight(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc discovery issuer mismatch: %s", provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document is missing endpoints")
+	}
+
+	oidcProviderCache = &provider
+	oidcProviderFor = issuer
+	return oidcProviderCache, nil
+}
+
+func oidcGetJSON(endpoint, accessToken string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func randomURLSafe(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// BeginOIDCLogin 生成授权跳转地址（state + nonce + PKCE S256），并返回 state 供调用方绑定到浏览器。
+func BeginOIDCLogin() (authURL, state string, err error) {
+	cfg := config.GetOIDCConfig()
+	if !cfg.Enabled() {
+		return "", "", fmt.Errorf("oidc is not configured")
+	}
+
+	provider, err := discoverOIDCProvider(cfg.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomURLSafe(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomURLSafe(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLSafe(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := store.SaveOIDCState(state, store.OIDCLoginState{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		CreatedAt:    time.Now().Unix(),
+	}, oidcStateTTL); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", cfg.RedirectURL)
+	q.Set("scope", strings.Join(cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return provider.AuthorizationEndpoint + sep + q.Encode(), state, nil
+}
+
+// CompleteOIDCLogin 处理回调：校验 state，用授权码换取 token，解析身份并映射角色，
+// 最后创建或更新对应的管理员账号。
+func CompleteOIDCLogin(state, code string) (*OIDCIdentity, error) {
+	cfg := config.GetOIDCConfig()
+	if !cfg.Enabled() {
+		return nil, fmt.Errorf("oidc is not configured")
+	}
+	if state == "" || code == "" {
+		return nil, fmt.Errorf("missing state or code")
+	}
+
+	saved, err := store.ConsumeOIDCState(state)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		return nil, fmt.Errorf("invalid or expired state")
+	}
+
+	provider, err := discoverOIDCProvider(cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := exchangeOIDCCode(cfg, provider, code, saved.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyIDTokenSignature(provider, tokens.IDToken); err != nil {
+		return nil, err
+	}
+	claims, err := parseIDTokenClaims(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateIDTokenClaims(cfg, claims, saved.Nonce, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// ID Token 未携带用户名/分组声明时，从 userinfo 端点补充。
+	if provider.UserinfoEndpoint != "" && tokens.AccessToken != "" &&
+		(claims[cfg.GroupsClaim] == nil || claims[cfg.UsernameClaim] == nil) {
+		var userinfo map[string]interface{}
+		if err := oidcGetJSON(provider.UserinfoEndpoint, tokens.AccessToken, &userinfo); err == nil {
+			if sub, _ := userinfo["sub"].(string); sub == claims["sub"] {
+				for key, value := range userinfo {
+					if _, ok := claims[key]; !ok {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	identity := &OIDCIdentity{
+		Username: oidcUsername(cfg, claims),
+		Groups:   claimStrings(claims[cfg.GroupsClaim]),
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("id token has no usable username claim")
+	}
+	identity.Role = mapOIDCRole(cfg, identity.Groups)
+	if identity.Role == "" {
+		return nil, fmt.Errorf("user %s is not allowed to access the admin panel", identity.Username)
+	}
+
+	subject, _ := claims["sub"].(string)
+	user, err := store.UpsertSSOAdmin(store.SSOIdentity{
+		Source:   "oidc",
+		Issuer:   cfg.Issuer,
+		Subject:  subject,
+		Username: identity.Username,
+		Role:     identity.Role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 用户名已被本地账号占用时实际账号名与声明不同，以存储中的账号为准。
+	identity.Username = user.Username
+	return identity, nil
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func exchangeOIDCCode(cfg config.OIDCConfig, provider *oidcProvider, code, verifier string) (*oidcTokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", cfg.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint returned no id_token")
+	}
+	return &tokens, nil
+}
+
+// oidcSignatureHashes 支持的 ID Token 签名算法及其哈希；不接受 none 与 HMAC。
+var oidcSignatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifyIDTokenSignature 用身份提供方 jwks_uri 公布的公钥校验 ID Token 签名。
+// 公钥按 jwks_uri 缓存，找不到 kid 对应的公钥时重新拉取一次（身份提供方轮换密钥）。
+func verifyIDTokenSignature(provider *oidcProvider, idToken string) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed id_token")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("malformed id_token header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return fmt.Errorf("malformed id_token header: %w", err)
+	}
+	hash, ok := oidcSignatureHashes[header.Alg]
+	if !ok {
+		return fmt.Errorf("unsupported id_token signing algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed id_token signature: %w", err)
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	for _, refresh := range []bool{false, true} {
+		keys, err := oidcSigningKeys(provider.JWKSURI, refresh)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, key := range keys {
+			if key.Use != "" && key.Use != "sig" || header.Kid != "" && key.Kid != header.Kid {
+				continue
+			}
+			if verifyJWKSignature(key, header.Alg, hash, digest, signature) {
+				return nil
+			}
+			found = true
+		}
+		if found {
+			break
+		}
+	}
+	return fmt.Errorf("id_token signature verification failed")
+}
+
+// oidcSigningKeys 返回 jwks_uri 的公钥，refresh 为 true 时忽略缓存重新拉取。
+func oidcSigningKeys(jwksURI string, refresh bool) ([]oidcJWK, error) {
+	oidcKeysMu.Lock()
+	defer oidcKeysMu.Unlock()
+
+	if keys, ok := oidcKeysCache[jwksURI]; ok && !refresh {
+		return keys, nil
+	}
+	var set struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	if err := oidcGetJSON(jwksURI, "", &set); err != nil {
+		return nil, fmt.Errorf("fetch oidc signing keys: %w", err)
+	}
+	oidcKeysCache[jwksURI] = set.Keys
+	return set.Keys, nil
+}
+
+// verifyJWKSignature 用单个公钥校验签名，密钥类型与算法不匹配时返回 false。
+func verifyJWKSignature(key oidcJWK, alg string, hash crypto.Hash, digest, signature []byte) bool {
+	decode := func(value string) *big.Int {
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(raw) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(raw)
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "RS") && key.Kty == "RSA":
+		n, e := decode(key.N), decode(key.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return false
+		}
+		pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+	case strings.HasPrefix(alg, "ES") && key.Kty == "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[key.Crv]
+		x, y := decode(key.X), decode(key.Y)
+		if !ok || x == nil || y == nil {
+			return false
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		sv := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest, r, sv)
+	}
+	return false
+}
+
+// parseIDTokenClaims 解析 ID Token 的 payload，调用前需先通过 verifyIDTokenSignature 校验签名；
+// iss/aud/exp/nonce 在 validateIDTokenClaims 中逐项校验。
+func parseIDTokenClaims(idToken string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token payload: %w", err)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token payload: %w", err)
+	}
+	return claims, nil
+}
+
+func validateIDTokenClaims(cfg config.OIDCConfig, claims map[string]interface{}, nonce string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != cfg.Issuer {
+		return fmt.Errorf("id_token issuer mismatch")
+	}
+
+	audiences := claimStrings(claims["aud"])
+	audOK := false
+	for _, aud := range audiences {
+		if aud == cfg.ClientID {
+			audOK = true
+			break
+		}
+	}
+	if !audOK {
+		return fmt.Errorf("id_token audience mismatch")
+	}
+	if len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != cfg.ClientID {
+			return fmt.Errorf("id_token authorized party mismatch")
+		}
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcClockSkew).Unix() > int64(exp) {
+		return fmt.Errorf("id_token expired")
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return fmt.Errorf("id_token nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return fmt.Errorf("id_token has no subject")
+	}
+	return nil
+}
+
+// oidcUsername 按配置的声明取用户名，依次回退到 email、sub。
+func oidcUsername(cfg config.OIDCConfig, claims map[string]interface{}) string {
+	for _, key := range []string{cfg.UsernameClaim, "email", "sub"} {
+		if value, _ := claims[key].(string); strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// claimStrings 将字符串或字符串数组形式的声明统一为切片。
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// mapOIDCRole 根据分组映射角色，命中多个分组时取最高角色；均未命中时使用默认角色（可为空，即拒绝登录）。
+func mapOIDCRole(cfg config.OIDCConfig, groups []string) store.AdminRole {
+	var best store.AdminRole
+	for _, group := range groups {
+		role := store.AdminRole(cfg.RoleMapping[group])
+		if !role.Valid() {
+			continue
+		}
+		if best == "" || role.Allows(best) {
+			best = role
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	role := store.AdminRole(cfg.DefaultRole)
+	if role.Valid() {
+		return role
+	}
+	return ""
+}
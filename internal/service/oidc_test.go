@@ -0,0 +1,234 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/store"
+)
+
+// mockOIDCIssuer 最小化的 OIDC 身份提供方：discovery、JWKS 与校验 PKCE 的 token 端点。
+type mockOIDCIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockOIDCGrant
+}
+
+type mockOIDCGrant struct {
+	challenge string
+	claims    map[string]interface{}
+	signWith  *rsa.PrivateKey
+}
+
+func newMockOIDCIssuer(t *testing.T) *mockOIDCIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &mockOIDCIssuer{t: t, key: key, grants: map[string]mockOIDCGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.srv.URL,
+			"authorization_endpoint": iss.srv.URL + "/authorize",
+			"token_endpoint":         iss.srv.URL + "/token",
+			"jwks_uri":               iss.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		iss.mu.Lock()
+		grant, ok := iss.grants[r.Form.Get("code")]
+		delete(iss.grants, r.Form.Get("code"))
+		iss.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     iss.sign(grant.signWith, grant.claims),
+		})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+
+	t.Setenv("OIDC_ISSUER", iss.srv.URL)
+	t.Setenv("OIDC_CLIENT_ID", "stash-rule")
+	t.Setenv("OIDC_REDIRECT_URL", "https://stash.example.com/api/auth/oidc/callback")
+	t.Setenv("OIDC_ROLE_MAPPING", "admins=owner,staff=viewer")
+	return iss
+}
+
+func (iss *mockOIDCIssuer) sign(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	if key == nil {
+		key = iss.key
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		iss.t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login 走完整的授权码流程；tweak 可在签发前修改授权（篡改 nonce、换签名密钥等）。
+func (iss *mockOIDCIssuer) login(t *testing.T, sub, username string, groups []string, tweak func(*mockOIDCGrant)) (*OIDCIdentity, error) {
+	t.Helper()
+	authURL, state, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatalf("BeginOIDCLogin: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := parsed.Query()
+	if !strings.HasPrefix(authURL, iss.srv.URL+"/authorize?") || q.Get("state") != state || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	grant := mockOIDCGrant{
+		challenge: q.Get("code_challenge"),
+		claims: map[string]interface{}{
+			"iss":                iss.srv.URL,
+			"aud":                "stash-rule",
+			"sub":                sub,
+			"preferred_username": username,
+			"groups":             groups,
+			"nonce":              q.Get("nonce"),
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Minute).Unix(),
+		},
+	}
+	if tweak != nil {
+		tweak(&grant)
+	}
+	code := "code-" + state
+	iss.mu.Lock()
+	iss.grants[code] = grant
+	iss.mu.Unlock()
+	return CompleteOIDCLogin(state, code)
+}
+
+func TestOIDCLoginAgainstMockIssuer(t *testing.T) {
+	useMemoryStore(t)
+	iss := newMockOIDCIssuer(t)
+
+	identity, err := iss.login(t, "sub-1", "carol", []string{"staff", "admins"}, nil)
+	if err != nil {
+		t.Fatalf("CompleteOIDCLogin: %v", err)
+	}
+	if identity.Username != "carol" || identity.Role != store.AdminRoleOwner {
+		t.Fatalf("identity = %+v, want carol/owner", identity)
+	}
+
+	// 同一 issuer+sub 改名后仍对应原账号，不会新建。
+	identity, err = iss.login(t, "sub-1", "carol-renamed", []string{"admins"}, nil)
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if identity.Username != "carol" {
+		t.Fatalf("renamed login mapped to %q, want carol", identity.Username)
+	}
+
+	// 签名、nonce、PKCE 任一不对都拒绝登录。
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := map[string]func(*mockOIDCGrant){
+		"bad signature": func(g *mockOIDCGrant) { g.signWith = otherKey },
+		"wrong nonce":   func(g *mockOIDCGrant) { g.claims["nonce"] = "replayed-nonce" },
+		"wrong pkce":    func(g *mockOIDCGrant) { g.challenge = "not-the-challenge" },
+		"unmapped role": func(g *mockOIDCGrant) { g.claims["groups"] = []string{"guests"} },
+	}
+	for name, tweak := range rejected {
+		if _, err := iss.login(t, "sub-2", "mallory", []string{"admins"}, tweak); err == nil {
+			t.Errorf("%s: login succeeded, want error", name)
+		}
+	}
+	if user, _ := store.GetAdminUser("mallory"); user != nil {
+		t.Fatalf("rejected logins created account %+v", user)
+	}
+}
+
+func TestOIDCLoginDoesNotTakeOverLocalAccounts(t *testing.T) {
+	useMemoryStore(t)
+	iss := newMockOIDCIssuer(t)
+	if err := store.CreateAdminUser("alice", "local-pass", store.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := iss.login(t, "sub-alice", "alice", []string{"admins"}, nil)
+	if err != nil {
+		t.Fatalf("CompleteOIDCLogin: %v", err)
+	}
+	if identity.Username != "oidc:sub-alice" {
+		t.Fatalf("username = %q, want oidc:sub-alice", identity.Username)
+	}
+	local, err := store.GetAdminUser("alice")
+	if err != nil || local == nil || local.Role != store.AdminRoleViewer {
+		t.Fatalf("local alice = %+v, %v; want untouched viewer", local, err)
+	}
+	if ok, _ := store.AuthenticateAdmin("alice", "local-pass"); !ok {
+		t.Fatal("local alice password no longer works")
+	}
+}
+
+func TestOIDCRoleSyncKeepsLastOwner(t *testing.T) {
+	useMemoryStore(t)
+	iss := newMockOIDCIssuer(t)
+
+	if _, err := iss.login(t, "sub-1", "carol", []string{"admins"}, nil); err != nil {
+		t.Fatalf("CompleteOIDCLogin: %v", err)
+	}
+	// 删掉默认 admin，carol 成为唯一的 owner。
+	if err := store.DeleteAdminUser("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iss.login(t, "sub-1", "carol", []string{"staff"}, nil); err == nil || !strings.Contains(err.Error(), "last owner") {
+		t.Fatalf("demoting the last owner: err = %v, want last owner error", err)
+	}
+	user, err := store.GetAdminUser("carol")
+	if err != nil || user == nil || user.Role != store.AdminRoleOwner {
+		t.Fatalf("carol = %+v, %v; want owner", user, err)
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"my-stash-rule/internal/store"
+)
+
+// ProfileTemplateDataFor 构建渲染模板所需的数据；subscriber 为 nil（如管理员）时标签与变量为空。
+func ProfileTemplateDataFor(username string, subscriber *store.Subscriber, proxies []ProxyNode) store.ProfileTemplateData {
+	data := store.ProfileTemplateData{
+		Username:    username,
+		Vars:        map[string]string{},
+		NodeCount:   len(proxies),
+		RegionNodes: map[string]int{},
+	}
+	if subscriber != nil {
+		data.Tags = subscriber.Tags
+		for k, v := range subscriber.Vars {
+			data.Vars[k] = v
+		}
+	}
+
+	for _, p := range proxies {
+		name, _ := p["name"].(string)
+		for region, matched := range classifyProxyName(name) {
+			if matched {
+				data.RegionNodes[region]++
+			}
+		}
+	}
+	for _, region := range proxyRegions {
+		if data.RegionNodes[region] > 0 {
+			data.Regions = append(data.Regions, region)
+		}
+	}
+	return data
+}
+
+// ResolveProfileOverlays 按继承链（先父后子）加载模板并用 data 渲染，返回依次合并到动态配置上的 overlay。
+// overlay 中已去掉 extends 字段。
+func ResolveProfileOverlays(name string, data store.ProfileTemplateData) ([]map[string]interface{}, []string, error) {
+	return ResolveProfileOverlaysWithDrafts(name, data, nil)
+}
+
+// ResolveProfileOverlaysWithDrafts 同 ResolveProfileOverlays，drafts（模板名 -> 未保存内容）优先于已保存内容。
+func ResolveProfileOverlaysWithDrafts(name string, data store.ProfileTemplateData, drafts map[string]string) ([]map[string]interface{}, []string, error) {
+	chain, err := store.ResolveStashProfileChainWithDrafts(name, drafts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	overlays := make([]map[string]interface{}, 0, len(chain))
+	for _, item := range chain {
+		var profileMap map[string]interface{}
+		if content, isDraft := drafts[item]; isDraft {
+			profileMap, err = store.RenderStashProfileMap(content, data)
+		} else {
+			profileMap, err = store.GetStashProfileMapFor(item, data)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("load stash profile %s: %w", item, err)
+		}
+		delete(profileMap, store.ProfileExtendsKey)
+		overlays = append(overlays, profileMap)
+	}
+	return overlays, chain, nil
+}
+
+// SubscriberOverride 返回订阅用户的覆盖配置（在模板之后最后合并），未设置时返回 nil。
+func SubscriberOverride(subscriber *store.Subscriber, data store.ProfileTemplateData) (map[string]interface{}, error) {
+	if subscriber == nil || subscriber.Override == "" {
+		return nil, nil
+	}
+	override, err := store.RenderStashProfileMap(subscriber.Override, data)
+	if err != nil {
+		return nil, fmt.Errorf("subscriber %s override: %w", subscriber.Username, err)
+	}
+	return override, nil
+}
+
+// ResolveProfileYAML 返回模板按继承链合并后的 YAML（不含动态基础配置），用于预览。
+// 模板语法使用示例数据渲染。
+func ResolveProfileYAML(name string) ([]byte, []string, error) {
+	overlays, chain, err := ResolveProfileOverlays(name, store.SampleProfileTemplateData())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged := map[string]interface{}{}
+	for _, overlay := range overlays {
+		merged = DeepMergeMap(merged, overlay)
+	}
+	content, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, chain, nil
+}
@@ -1,12 +1,13 @@
 package service
 
 import (
+	"fmt"
 	"log"
-	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"my-stash-rule/internal/config"
 	"my-stash-rule/internal/model"
 	"my-stash-rule/internal/store"
 )
@@ -14,8 +15,11 @@ import (
 const (
 	proxyCacheRefreshInterval = 24 * time.Hour
 	proxyCacheSchedulerTick   = time.Hour
+	// proxyCacheRetryTick 检查失败链接是否到了再次尝试时间的间隔。
+	proxyCacheRetryTick = time.Minute
 )
 
+// proxyCacheRefreshMu 串行化刷新结果的写入（缓存与拉取状态），拉取和重试等待期间不持有。
 var proxyCacheRefreshMu sync.Mutex
 
 // ProxyCacheRefreshItem 表示单个订阅链接刷新结果。
@@ -24,6 +28,16 @@ type ProxyCacheRefreshItem struct {
 	Count     int    `json:"count"`
 	UpdatedAt int64  `json:"updated_at"`
 	Error     string `json:"error,omitempty"`
+	// Attempts 本次刷新实际发出的请求次数（含重试）。
+	Attempts int `json:"attempts"`
+	// Via 拉取使用的出站代理（已隐藏密码），直连时为空。
+	Via string `json:"via,omitempty"`
+	// NextRetryAt 刷新失败后计划再次尝试的时间。
+	NextRetryAt int64 `json:"next_retry_at,omitempty"`
+	// NotModified 上游返回 304，沿用已有缓存。
+	NotModified bool `json:"not_modified,omitempty"`
+	// Stale 刷新失败但保留了上次成功拉取的缓存，Count 为缓存中的节点数。
+	Stale bool `json:"stale,omitempty"`
 }
 
 // ProxyCacheRefreshResult 表示一次刷新任务的整体结果。
@@ -59,7 +73,8 @@ func normalizeSubscribeURLs(urls []string) []string {
 }
 
 // BuildProxiesFromCache 返回按订阅链接合并后的节点列表。
-// 仅在某个链接没有缓存时才触发远程拉取并回写缓存。
+// 仅在某个链接没有缓存时才触发远程拉取并回写缓存；该拉取发生在订阅请求中，只尝试一次不做重试，
+// 失败的链接由后台按失败重试间隔继续尝试。
 func BuildProxiesFromCache(urls []string) ([]model.ProxyNode, error) {
 	normalizedURLs := normalizeSubscribeURLs(urls)
 	if len(normalizedURLs) == 0 {
@@ -72,9 +87,9 @@ func BuildProxiesFromCache(urls []string) ([]model.ProxyNode, error) {
 	}
 
 	if len(missing) > 0 {
-		if _, refreshErr := RefreshProxyCache(missing); refreshErr != nil {
-			log.Printf("Failed to refresh missing proxy cache: %v", refreshErr)
-		}
+		cfg := config.GetUpstreamConfig()
+		cfg.Retries = 0
+		refreshProxyCacheURLs(cfg, missing, time.Now())
 	}
 
 	all := make([]model.ProxyNode, 0)
@@ -92,23 +107,32 @@ func BuildProxiesFromCache(urls []string) ([]model.ProxyNode, error) {
 	return all, nil
 }
 
-// RefreshProxyCache 按订阅链接刷新缓存（每个链接独立存储）。
+// RefreshProxyCache 按订阅链接刷新缓存（每个链接独立存储），并记录为最近一次全量刷新时间。
+// 仅用于每日刷新和手动刷新；补拉缺失缓存、失败重试不更新该时间，以免推迟每日刷新。
 func RefreshProxyCache(urls []string) (ProxyCacheRefreshResult, error) {
-	proxyCacheRefreshMu.Lock()
-	defer proxyCacheRefreshMu.Unlock()
+	refreshedAt := time.Now()
+	result := refreshProxyCacheURLs(config.GetUpstreamConfig(), urls, refreshedAt)
+	if result.Total == 0 {
+		return result, nil
+	}
+	if err := store.SetProxyCacheLastRunAt(refreshedAt); err != nil {
+		return result, err
+	}
+	return result, nil
+}
 
+// refreshProxyCacheURLs 并发刷新各订阅链接的缓存，不更新最近一次全量刷新时间。
+func refreshProxyCacheURLs(cfg config.UpstreamConfig, urls []string, refreshedAt time.Time) ProxyCacheRefreshResult {
 	normalizedURLs := normalizeSubscribeURLs(urls)
-	refreshedAt := time.Now()
 	result := ProxyCacheRefreshResult{
 		Total:       len(normalizedURLs),
 		RefreshedAt: refreshedAt.Unix(),
 		Items:       make([]ProxyCacheRefreshItem, 0, len(normalizedURLs)),
 	}
 	if len(normalizedURLs) == 0 {
-		return result, nil
+		return result
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
 	type workerResult struct {
 		item ProxyCacheRefreshItem
 	}
@@ -121,27 +145,7 @@ func RefreshProxyCache(urls []string) (ProxyCacheRefreshResult, error) {
 		go func(url string) {
 			defer wg.Done()
 
-			item := ProxyCacheRefreshItem{
-				URL:       url,
-				Count:     0,
-				UpdatedAt: refreshedAt.Unix(),
-			}
-
-			proxies, err := fetchSingleURL(client, url)
-			if err != nil {
-				item.Error = err.Error()
-				ch <- workerResult{item: item}
-				return
-			}
-
-			if err := store.SaveProxyCache(url, proxies, refreshedAt); err != nil {
-				item.Error = err.Error()
-				ch <- workerResult{item: item}
-				return
-			}
-
-			item.Count = len(proxies)
-			ch <- workerResult{item: item}
+			ch <- workerResult{item: refreshSingleURL(cfg, url, refreshedAt)}
 		}(targetURL)
 	}
 
@@ -162,12 +166,87 @@ func RefreshProxyCache(urls []string) (ProxyCacheRefreshResult, error) {
 			result.Success++
 		}
 	}
+	return result
+}
 
-	if err := store.SetProxyCacheLastRunAt(refreshedAt); err != nil {
-		return result, err
+// refreshSingleURL 刷新单个订阅链接：有缓存时发送条件请求，上游返回 304 时沿用缓存；
+// 可重试的失败按配置退避重试，最终失败、内容为空或无法解析时保留上次成功的缓存，
+// 记录错误与连续失败次数，并安排在每日刷新之外再次尝试。
+// 拉取（含重试等待）不持有 proxyCacheRefreshMu，只在写回结果时加锁并重新读取最新的缓存与状态。
+func refreshSingleURL(cfg config.UpstreamConfig, url string, refreshedAt time.Time) ProxyCacheRefreshItem {
+	item := ProxyCacheRefreshItem{
+		URL:       url,
+		Count:     0,
+		UpdatedAt: refreshedAt.Unix(),
 	}
 
-	return result, nil
+	_, _, found, err := store.GetProxyCache(url)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	state, err := store.GetProxyFetchState(url)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	var validators upstreamValidators
+	if found {
+		validators = upstreamValidators{ETag: state.ETag, LastModified: state.LastModified}
+	}
+	result, attempts, err := fetchSubscriptionWithRetry(cfg, url, validators)
+	item.Attempts = attempts
+	item.Via = result.Via
+
+	proxyCacheRefreshMu.Lock()
+	defer proxyCacheRefreshMu.Unlock()
+	cached, _, found, loadErr := store.GetProxyCache(url)
+	if loadErr != nil {
+		item.Error = loadErr.Error()
+		return item
+	}
+	if state, loadErr = store.GetProxyFetchState(url); loadErr != nil {
+		item.Error = loadErr.Error()
+		return item
+	}
+	if err == nil && result.NotModified && !found {
+		err = fmt.Errorf("upstream returned 304 but no cache exists")
+	}
+	if err == nil {
+		proxies := result.Proxies
+		if result.NotModified {
+			proxies = cached
+			item.NotModified = true
+		}
+		if err = store.SaveProxyCache(url, proxies, refreshedAt); err == nil {
+			item.Count = len(proxies)
+			state.ETag = result.Validators.ETag
+			state.LastModified = result.Validators.LastModified
+			state.CheckedAt = refreshedAt.Unix()
+			state.ConsecutiveFailures = 0
+			state.NextRetryAt = 0
+		}
+	}
+	if err != nil {
+		item.Error = err.Error()
+		item.NotModified = false
+		if found {
+			item.Count = len(cached)
+			item.Stale = true
+		}
+		state.LastError = err.Error()
+		state.LastErrorAt = refreshedAt.Unix()
+		state.ConsecutiveFailures++
+		state.NextRetryAt = refreshedAt.Add(upstreamFollowUpDelay(cfg.FailedRetryInterval, state.ConsecutiveFailures)).Unix()
+		item.NextRetryAt = state.NextRetryAt
+		log.Printf("Failed to refresh proxy cache for %s (%d consecutive failures, next retry at %s): %v",
+			redactSubscriptionURL(url), state.ConsecutiveFailures, time.Unix(state.NextRetryAt, 0).Format(time.RFC3339), err)
+	}
+	if saveErr := store.SaveProxyFetchState(url, state); saveErr != nil {
+		log.Printf("Failed to save proxy fetch state for %s: %v", redactSubscriptionURL(url), saveErr)
+	}
+	return item
 }
 
 // RefreshProxyCacheFromStore 刷新当前配置中的全部订阅链接缓存。
@@ -202,6 +281,34 @@ func GetProxyCacheStatus() (ProxyCacheStatusResult, error) {
 	}, nil
 }
 
+// dueFailedURLs 返回上次刷新失败且已到再次尝试时间的订阅链接。
+func dueFailedURLs(now time.Time) ([]string, error) {
+	urls, err := store.GetStoredSubscribeUrls()
+	if err != nil {
+		return nil, err
+	}
+	due := make([]string, 0)
+	for _, url := range normalizeSubscribeURLs(urls) {
+		state, err := store.GetProxyFetchState(url)
+		if err != nil {
+			return nil, err
+		}
+		if state.NextRetryAt > 0 && now.Unix() >= state.NextRetryAt {
+			due = append(due, url)
+		}
+	}
+	return due, nil
+}
+
+// RetryFailedProxyCaches 重新刷新已到再次尝试时间的失败链接，没有到期链接时返回零值结果。
+func RetryFailedProxyCaches(now time.Time) (ProxyCacheRefreshResult, error) {
+	due, err := dueFailedURLs(now)
+	if err != nil || len(due) == 0 {
+		return ProxyCacheRefreshResult{}, err
+	}
+	return refreshProxyCacheURLs(config.GetUpstreamConfig(), due, time.Now()), nil
+}
+
 func shouldRunDailyRefresh(now time.Time) (bool, error) {
 	urls, err := store.GetStoredSubscribeUrls()
 	if err != nil {
@@ -247,15 +354,27 @@ func StartDailyProxyCacheScheduler() {
 
 		ticker := time.NewTicker(proxyCacheSchedulerTick)
 		defer ticker.Stop()
-
-		for now := range ticker.C {
-			ok, err := shouldRunDailyRefresh(now)
-			if err != nil {
-				log.Printf("Failed to check proxy cache refresh time: %v", err)
-				continue
-			}
-			if ok {
-				runRefresh("daily")
+		retryTicker := time.NewTicker(proxyCacheRetryTick)
+		defer retryTicker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				ok, err := shouldRunDailyRefresh(now)
+				if err != nil {
+					log.Printf("Failed to check proxy cache refresh time: %v", err)
+					continue
+				}
+				if ok {
+					runRefresh("daily")
+				}
+			case now := <-retryTicker.C:
+				result, err := RetryFailedProxyCaches(now)
+				if err != nil {
+					log.Printf("Proxy cache retry failed: %v", err)
+				} else if result.Total > 0 {
+					log.Printf("Proxy cache retried failed urls: success=%d failed=%d total=%d", result.Success, result.Failed, result.Total)
+				}
 			}
 		}
 	}()
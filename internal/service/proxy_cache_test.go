@@ -0,0 +1,274 @@
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/store"
+)
+
+// conditionalUpstream 模拟支持 ETag 的订阅服务，body / status 可在测试中修改。
+type conditionalUpstream struct {
+	mu          sync.Mutex
+	body        string
+	etag        string
+	status      int
+	conditional int // 收到的条件请求数
+}
+
+func (u *conditionalUpstream) set(status int, body, etag string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status, u.body, u.etag = status, body, etag
+}
+
+func (u *conditionalUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		u.conditional++
+		if inm == u.etag && u.status == http.StatusOK {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if u.etag != "" {
+		w.Header().Set("ETag", u.etag)
+	}
+	w.WriteHeader(u.status)
+	w.Write([]byte(u.body))
+}
+
+// fastUpstreamRetries 缩短重试等待，避免测试变慢。
+func fastUpstreamRetries(t *testing.T) {
+	t.Helper()
+	t.Setenv("UPSTREAM_RETRY_BACKOFF", "1ms")
+	t.Setenv("UPSTREAM_RETRY_BACKOFF_MAX", "4ms")
+}
+
+func TestRefreshProxyCacheConditionalAndStale(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	upstream := &conditionalUpstream{}
+	srv := httptest.NewServer(upstream)
+	t.Cleanup(srv.Close)
+	body := base64.StdEncoding.EncodeToString([]byte("trojan://secret@hk1.example.com:443#HK%2001\ntrojan://secret@jp1.example.com:443#JP%2001"))
+
+	refresh := func() ProxyCacheRefreshItem {
+		t.Helper()
+		result, err := RefreshProxyCache([]string{srv.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Items[0]
+	}
+	status := func() store.ProxyCacheStatus {
+		t.Helper()
+		statuses, err := store.ListProxyCacheStatus([]string{srv.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return statuses[0]
+	}
+
+	upstream.set(http.StatusOK, body, `"v1"`)
+	if item := refresh(); item.Error != "" || item.Count != 2 || item.NotModified {
+		t.Fatalf("first refresh = %+v", item)
+	}
+
+	if item := refresh(); !item.NotModified || item.Count != 2 || upstream.conditional != 1 {
+		t.Errorf("expected 304 reuse, got %+v (conditional requests: %d)", item, upstream.conditional)
+	}
+
+	// 空内容与错误状态码都保留上次成功的缓存，并累计失败次数
+	upstream.set(http.StatusOK, "<html>maintenance</html>", `"v2"`)
+	if item := refresh(); !item.Stale || item.Count != 2 || item.Error == "" {
+		t.Errorf("expected stale fallback, got %+v", item)
+	}
+	upstream.set(http.StatusBadGateway, "", "")
+	refresh()
+	if s := status(); s.Count != 2 || s.ConsecutiveFailures != 2 || s.LastError != "status code: 502" || s.LastErrorAt == 0 {
+		t.Errorf("status after failures = %+v", s)
+	}
+	nodes, _, found, err := store.GetProxyCache(srv.URL)
+	if err != nil || !found || len(nodes) != 2 {
+		t.Errorf("cache not kept: %v %v %d", err, found, len(nodes))
+	}
+
+	upstream.set(http.StatusOK, base64.StdEncoding.EncodeToString([]byte("trojan://secret@hk1.example.com:443#HK%2001")), `"v3"`)
+	if item := refresh(); item.Error != "" || item.Count != 1 {
+		t.Errorf("recovery refresh = %+v", item)
+	}
+	if s := status(); s.ConsecutiveFailures != 0 || s.LastError != "status code: 502" || s.CheckedAt == 0 || s.Count != 1 {
+		t.Errorf("status after recovery = %+v", s)
+	}
+}
+
+func TestRefreshProxyCacheRetries(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	t.Setenv("UPSTREAM_RETRIES", "2")
+	t.Setenv("UPSTREAM_FAILED_RETRY_INTERVAL", "10m")
+	body := base64.StdEncoding.EncodeToString([]byte("trojan://secret@hk1.example.com:443#HK%2001"))
+
+	var hits, failFirst, failStatus int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(&hits, 1); n <= atomic.LoadInt32(&failFirst) {
+			w.WriteHeader(int(atomic.LoadInt32(&failStatus)))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	if err := store.SaveSubscribeUrls([]string{srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(fail, status int32) ProxyCacheRefreshItem {
+		t.Helper()
+		atomic.StoreInt32(&hits, 0)
+		atomic.StoreInt32(&failFirst, fail)
+		atomic.StoreInt32(&failStatus, status)
+		result, err := RefreshProxyCache([]string{srv.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Items[0]
+	}
+
+	if item := refresh(2, http.StatusServiceUnavailable); item.Error != "" || item.Attempts != 3 || item.Count != 1 {
+		t.Errorf("expected success after retries, got %+v", item)
+	}
+	if item := refresh(10, http.StatusNotFound); item.Attempts != 1 || !item.Stale {
+		t.Errorf("4xx should not be retried, got %+v", item)
+	}
+
+	start := time.Now()
+	item := refresh(10, http.StatusBadGateway)
+	if item.Attempts != 3 || item.NextRetryAt == 0 {
+		t.Fatalf("expected exhausted retries with follow-up, got %+v", item)
+	}
+	// 连续第 2 次失败，再次尝试的间隔翻倍
+	if wait := time.Unix(item.NextRetryAt, 0).Sub(start); wait < 19*time.Minute || wait > 21*time.Minute {
+		t.Errorf("next retry in %s, want about 20m", wait)
+	}
+
+	atomic.StoreInt32(&failFirst, 0)
+	if result, err := RetryFailedProxyCaches(time.Now()); err != nil || result.Total != 0 {
+		t.Errorf("retried before due: %+v %v", result, err)
+	}
+	// 失败重试不算作每日刷新，不能推迟下一次每日刷新。
+	lastRunAt := time.Now().Add(-23 * time.Hour).Truncate(time.Second)
+	if err := store.SetProxyCacheLastRunAt(lastRunAt); err != nil {
+		t.Fatal(err)
+	}
+	result, err := RetryFailedProxyCaches(time.Unix(item.NextRetryAt, 0))
+	if err != nil || result.Total != 1 || result.Success != 1 {
+		t.Fatalf("follow-up retry = %+v %v", result, err)
+	}
+	if got, err := store.GetProxyCacheLastRunAt(); err != nil || got != lastRunAt.Unix() {
+		t.Errorf("last run at = %d, %v; want unchanged %d", got, err, lastRunAt.Unix())
+	}
+	statuses, err := store.ListProxyCacheStatus([]string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := statuses[0]; s.NextRetryAt != 0 || s.ConsecutiveFailures != 0 {
+		t.Errorf("status after follow-up = %+v", s)
+	}
+}
+
+func TestBuildProxiesFromCacheFetchesMissingOnce(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	t.Setenv("UPSTREAM_RETRIES", "3")
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	// 订阅请求中补拉缺失的缓存只尝试一次，失败后交给后台重试。
+	proxies, err := BuildProxiesFromCache([]string{srv.URL})
+	if err != nil || len(proxies) != 0 {
+		t.Fatalf("proxies = %v, %v", proxies, err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("upstream hits = %d, want 1", n)
+	}
+	state, err := store.GetProxyFetchState(srv.URL)
+	if err != nil || state.ConsecutiveFailures != 1 || state.NextRetryAt == 0 {
+		t.Errorf("fetch state = %+v, %v; want a scheduled follow-up", state, err)
+	}
+	if lastRunAt, _ := store.GetProxyCacheLastRunAt(); lastRunAt != 0 {
+		t.Errorf("last run at = %d, want unset", lastRunAt)
+	}
+}
+
+func TestRefreshProxyCacheSourceTimeout(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	t.Setenv("UPSTREAM_RETRIES", "0")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("trojan://secret@hk1.example.com:443#HK%2001"))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("UPSTREAM_SOURCE_TIMEOUTS", srv.URL+"=50ms")
+
+	result, err := RefreshProxyCache([]string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := result.Items[0]; !strings.Contains(item.Error, "Timeout") {
+		t.Errorf("expected per-source timeout, got %+v", item)
+	}
+}
+
+func TestRefreshProxyCacheRejectsOversizedResponse(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	t.Setenv("UPSTREAM_RETRIES", "2")
+	limit := maxSubscriptionBodySize
+	maxSubscriptionBodySize = 1024
+	t.Cleanup(func() { maxSubscriptionBodySize = limit })
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(strings.Repeat("trojan://secret@hk1.example.com:443#HK%2001\n", 100)))
+	}))
+	t.Cleanup(srv.Close)
+
+	result, err := RefreshProxyCache([]string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := result.Items[0]; !strings.Contains(item.Error, "too large") {
+		t.Errorf("expected oversized response error, got %+v", item)
+	}
+	// 超大内容重试也不会成功，不重试。
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("upstream hits = %d, want 1", n)
+	}
+}
+
+func TestUpstreamRetryDelay(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 6: 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if got := upstreamRetryDelay(attempt, time.Second, 10*time.Second); got < want/2 || got > want {
+				t.Errorf("attempt %d: delay %s out of [%s, %s]", attempt, got, want/2, want)
+			}
+		}
+	}
+	if got := upstreamFollowUpDelay(time.Hour, 10); got != proxyCacheRefreshInterval {
+		t.Errorf("follow-up delay = %s, want capped at %s", got, proxyCacheRefreshInterval)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigChange 生成配置时自动修正的一处内容，Path 为修正前的位置。
+type ConfigChange struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// PruneDanglingReferences 清理合并后配置中的失效引用，返回修正后的配置（不修改原配置）与修正明细：
+//   - 代理组成员中不存在的节点 / 代理组（以及引用自身）被移除；
+//   - 没有成员的代理组：仍被其他代理组或规则引用时改为 [DIRECT]，否则删除；
+//   - 目标不存在的规则被删除，MATCH / FINAL 规则改为指向 DIRECT。
+func PruneDanglingReferences(config map[string]interface{}) (map[string]interface{}, []ConfigChange) {
+	var changes []ConfigChange
+	record := func(path, format string, args ...interface{}) {
+		changes = append(changes, ConfigChange{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	known := make(map[string]bool)
+	for _, name := range builtinPolicies {
+		known[name] = true
+	}
+	proxies, _ := toInterfaceSlice(config["proxies"])
+	for _, item := range proxies {
+		if proxy, ok := toStringMap(item); ok {
+			if name, _ := proxy["name"].(string); name != "" {
+				known[name] = true
+			}
+		}
+	}
+
+	rawGroups, hasGroups := toInterfaceSlice(config["proxy-groups"])
+	groups := make([]interface{}, len(rawGroups))
+	for i, item := range rawGroups {
+		groups[i] = item
+		if group, ok := toStringMap(item); ok {
+			if name, _ := group["name"].(string); name != "" {
+				known[name] = true
+			}
+		}
+	}
+
+	// 1. 移除代理组中不存在的成员
+	referenced := make(map[string]bool)
+	for i, item := range groups {
+		group, ok := toStringMap(item)
+		if !ok {
+			continue
+		}
+		name, _ := group["name"].(string)
+		members, isList := toInterfaceSlice(group["proxies"])
+		if !isList {
+			continue
+		}
+
+		kept := make([]interface{}, 0, len(members))
+		for j, member := range members {
+			memberName, isName := member.(string)
+			switch {
+			case isName && memberName == name:
+				record(fmt.Sprintf("proxy-groups[%d].proxies[%d]", i, j), "removed self reference from proxy group %s", name)
+			case isName && !known[memberName]:
+				record(fmt.Sprintf("proxy-groups[%d].proxies[%d]", i, j), "removed unknown member %s from proxy group %s", memberName, name)
+			default:
+				kept = append(kept, member)
+				if isName {
+					referenced[memberName] = true
+				}
+			}
+		}
+		if len(kept) != len(members) {
+			copied := make(map[string]interface{}, len(group))
+			for k, v := range group {
+				copied[k] = v
+			}
+			copied["proxies"] = kept
+			groups[i] = copied
+		}
+	}
+
+	// 2. 清理规则：目标不存在时删除，兜底规则改为 DIRECT
+	rawRules, hasRules := toInterfaceSlice(config["rules"])
+	rules := make([]interface{}, 0, len(rawRules))
+	for i, item := range rawRules {
+		rule, isString := item.(string)
+		if !isString {
+			rules = append(rules, item)
+			continue
+		}
+		ruleType, _, target, ok := parseRule(rule)
+		if !ok || known[target] {
+			rules = append(rules, item)
+			if ok {
+				referenced[target] = true
+			}
+			continue
+		}
+		if ruleType == "MATCH" || ruleType == "FINAL" {
+			record(fmt.Sprintf("rules[%d]", i), "%s rule target %s does not exist, changed to DIRECT", ruleType, target)
+			rules = append(rules, strings.TrimSpace(strings.Split(rule, ",")[0])+",DIRECT")
+			referenced["DIRECT"] = true
+			continue
+		}
+		record(fmt.Sprintf("rules[%d]", i), "removed rule %q: target %s does not exist", rule, target)
+	}
+
+	// 3. 处理空代理组
+	finalGroups := make([]interface{}, 0, len(groups))
+	for i, item := range groups {
+		group, ok := toStringMap(item)
+		if !ok {
+			finalGroups = append(finalGroups, item)
+			continue
+		}
+		members, _ := toInterfaceSlice(group["proxies"])
+		uses, _ := toInterfaceSlice(group["use"])
+		if len(members) > 0 || len(uses) > 0 {
+			finalGroups = append(finalGroups, item)
+			continue
+		}
+
+		name, _ := group["name"].(string)
+		if !referenced[name] {
+			record(fmt.Sprintf("proxy-groups[%d]", i), "removed empty proxy group %s", name)
+			continue
+		}
+		copied := make(map[string]interface{}, len(group))
+		for k, v := range group {
+			copied[k] = v
+		}
+		copied["proxies"] = []interface{}{"DIRECT"}
+		finalGroups = append(finalGroups, copied)
+		record(fmt.Sprintf("proxy-groups[%d]", i), "proxy group %s has no members, using DIRECT", name)
+	}
+
+	if len(changes) == 0 {
+		return config, nil
+	}
+
+	out := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	if hasGroups {
+		out["proxy-groups"] = finalGroups
+	}
+	if hasRules {
+		out["rules"] = rules
+	}
+	return out, changes
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPruneDanglingReferences(t *testing.T) {
+	config := mustYAMLMap(t, `
+proxies:
+  - {name: A, type: ss}
+proxy-groups:
+  - {name: Main, type: select, proxies: [A, Gone, Main, Region]}
+  - {name: Region, type: url-test, proxies: [Filtered]}
+  - {name: Unused, type: select, proxies: []}
+  - {name: Provided, type: select, use: [provider]}
+rules:
+  - DOMAIN,a.com,Main
+  - DOMAIN,b.com,Removed
+  - GEOIP,CN,DIRECT
+  - MATCH,Removed
+`)
+	original := mustYAMLMap(t, "x: 1")
+	original = DeepMergeMap(original, config)
+
+	pruned, changes := PruneDanglingReferences(config)
+	want := mustYAMLMap(t, `
+proxies:
+  - {name: A, type: ss}
+proxy-groups:
+  - {name: Main, type: select, proxies: [A, Region]}
+  - {name: Region, type: url-test, proxies: [DIRECT]}
+  - {name: Provided, type: select, use: [provider]}
+rules:
+  - DOMAIN,a.com,Main
+  - GEOIP,CN,DIRECT
+  - MATCH,DIRECT
+`)
+	if !reflect.DeepEqual(pruned, want) {
+		t.Fatalf("pruned config mismatch\n got: %#v\nwant: %#v", pruned, want)
+	}
+
+	var paths []string
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	wantPaths := []string{
+		"proxy-groups[0].proxies[1]", "proxy-groups[0].proxies[2]", "proxy-groups[1].proxies[0]",
+		"rules[1]", "rules[3]", "proxy-groups[1]", "proxy-groups[2]",
+	}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("change paths = %v, want %v", paths, wantPaths)
+	}
+
+	// 原配置不被修改
+	delete(original, "x")
+	if !reflect.DeepEqual(config, original) {
+		t.Error("PruneDanglingReferences must not modify its input")
+	}
+}
+
+func TestPruneDanglingReferencesKeepsValidConfig(t *testing.T) {
+	config := BuildConfigMap([]ProxyNode{{"name": "HK 01", "type": "trojan"}})
+	pruned, changes := PruneDanglingReferences(config)
+	if len(changes) != 0 {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if reflect.ValueOf(pruned).Pointer() != reflect.ValueOf(config).Pointer() {
+		t.Error("config without changes should be returned as is")
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"my-stash-rule/internal/store"
+)
+
+// ErrSubscriberNotFound 指定的订阅用户不存在。
+var ErrSubscriberNotFound = errors.New("subscriber not found")
+
+// 配置输出格式：yaml 为实际下发给客户端的 Stash 配置，json 为同一配置的 JSON 表示。
+const (
+	ConfigFormatYAML = "yaml"
+	ConfigFormatJSON = "json"
+)
+
+// RenderRequest 生成最终配置的参数。
+type RenderRequest struct {
+	// Username 模板中的 .Username；Subscriber 为 true 时同时加载该订阅用户的标签、变量、绑定模板与覆盖配置。
+	Username   string
+	Subscriber bool
+	// ProfileName 为空时使用订阅用户绑定的模板（非订阅用户为 default）。
+	ProfileName string
+	// Drafts 未保存的模板内容（模板名 -> 内容），优先于已保存内容。
+	Drafts map[string]string
+}
+
+// RenderResult 生成的最终配置。
+type RenderResult struct {
+	ProfileName string
+	Chain       []string
+	NodeCount   int
+	Output      []byte
+	Warnings    []ConfigWarning
+	Changes     []ConfigChange
+}
+
+// RenderConfig 使用节点缓存生成订阅用户（或管理员）实际拿到的配置，与 GET / 的输出一致。
+func RenderConfig(req RenderRequest) (*RenderResult, error) {
+	urls, err := store.GetStoredSubscribeUrls()
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := BuildProxiesFromCache(urls)
+	if err != nil {
+		return nil, fmt.Errorf("load proxies: %w", err)
+	}
+
+	var subscriber *store.Subscriber
+	if req.Subscriber {
+		if subscriber, err = store.GetSubscriber(req.Username); err != nil {
+			return nil, err
+		}
+		if subscriber == nil {
+			return nil, ErrSubscriberNotFound
+		}
+	}
+
+	profileName := strings.TrimSpace(req.ProfileName)
+	if profileName == "" && subscriber != nil {
+		profileName = subscriber.ProfileName
+	}
+	profileName = store.DefaultStashProfileNameIfEmpty(profileName)
+
+	drafts := make(map[string]string, len(req.Drafts))
+	for name, content := range req.Drafts {
+		drafts[store.DefaultStashProfileNameIfEmpty(name)] = content
+	}
+
+	data := ProfileTemplateDataFor(req.Username, subscriber, proxies)
+	overlays, chain, err := ResolveProfileOverlaysWithDrafts(profileName, data, drafts)
+	if err != nil {
+		return nil, err
+	}
+	override, err := SubscriberOverride(subscriber, data)
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		overlays = append(overlays, override)
+		chain = append(chain, "(override)")
+	}
+
+	config := BuildMergedConfig(proxies, overlays...)
+	warnings := ValidateConfigMap(config)
+	output, changes, err := GenerateConfigFromMap(config)
+	if err != nil {
+		return nil, err
+	}
+	return &RenderResult{
+		ProfileName: profileName,
+		Chain:       chain,
+		NodeCount:   len(proxies),
+		Output:      output,
+		Warnings:    warnings,
+		Changes:     changes,
+	}, nil
+}
+
+// ConvertConfigFormat 将生成的 YAML 配置转换为指定格式（yaml / json）。
+func ConvertConfigFormat(output []byte, format string) ([]byte, error) {
+	switch format {
+	case "", ConfigFormatYAML:
+		return output, nil
+	case ConfigFormatJSON:
+		var config interface{}
+		if err := yaml.Unmarshal(output, &config); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(config, "", "  ")
+	default:
+		return nil, fmt.Errorf("unsupported format %q, expected yaml or json", format)
+	}
+}
@@ -4,15 +4,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
+	"my-stash-rule/internal/config"
 	"my-stash-rule/internal/model"
 	"gopkg.in/yaml.v3"
 )
@@ -30,17 +28,16 @@ func FetchProxies(urls []string) ([]ProxyNode, error) {
 		wg         sync.WaitGroup
 	)
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	cfg := config.GetUpstreamConfig()
 
 	for _, u := range urls {
 		wg.Add(1)
 		go func(targetURL string) {
 			defer wg.Done()
-			proxies, err := fetchSingleURL(client, targetURL)
+			result, _, err := fetchSubscriptionWithRetry(cfg, targetURL, upstreamValidators{})
+			proxies := result.Proxies
 			if err != nil {
-				log.Printf("Failed to fetch from %s: %v", targetURL, err)
+				log.Printf("Failed to fetch from %s: %v", redactSubscriptionURL(targetURL), err)
 				return
 			}
 			if len(proxies) > 0 {
@@ -61,31 +58,6 @@ func FetchProxies(urls []string) ([]ProxyNode, error) {
 	return allProxies, nil
 }
 
-func fetchSingleURL(client *http.Client, url string) ([]ProxyNode, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("User-Agent", userAgent)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return parseSubscription(string(body)), nil
-}
-
 func parseSubscription(content string) []ProxyNode {
 	content = strings.TrimSpace(content)
 
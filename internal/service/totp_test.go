@@ -0,0 +1,54 @@
+package service
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA-1 测试向量；本项目使用 6 位验证码，即 8 位参考值的后 6 位。
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range vectors {
+		code, err := TOTPCode(secret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", v.unix, err)
+		}
+		if want := v.code[len(v.code)-totpDigits:]; code != want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", v.unix, code, want)
+		}
+	}
+}
+
+func TestValidateTOTPAllowsOneStepOfSkew(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []time.Duration{-totpPeriod * time.Second, 0, totpPeriod * time.Second} {
+		step, ok := ValidateTOTP(secret, code, now.Add(offset))
+		if !ok || step != now.Unix()/totpPeriod {
+			t.Errorf("offset %v: step=%d ok=%v, want step %d", offset, step, ok, now.Unix()/totpPeriod)
+		}
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(2*totpPeriod*time.Second)); ok {
+		t.Error("code two steps old should be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "000000", now); ok {
+		t.Error("wrong code should be rejected")
+	}
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/store"
+)
+
+// errEmptySubscription 上游返回的内容解析不出任何节点（空内容、错误页面或格式不支持）。
+var errEmptySubscription = errors.New("no proxies parsed from response")
+
+// maxSubscriptionBodySize 订阅内容的最大字节数，超过时放弃本次拉取，避免异常上游耗尽内存。
+var maxSubscriptionBodySize int64 = 8 << 20
+
+// errSubscriptionTooLarge 订阅内容超过 maxSubscriptionBodySize。
+var errSubscriptionTooLarge = errors.New("subscription response is too large")
+
+// upstreamStatusError 上游返回了非 200 / 304 的状态码。
+type upstreamStatusError struct {
+	Code int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("status code: %d", e.Code)
+}
+
+// upstreamValidators 上次成功拉取时上游返回的校验值，用于发送条件请求。
+type upstreamValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// upstreamFetchResult 单次拉取订阅链接的结果；NotModified 为 true 时上游返回 304，Proxies 为空。
+type upstreamFetchResult struct {
+	Proxies     []ProxyNode
+	NotModified bool
+	Validators  upstreamValidators
+	// Via 使用的出站代理（已隐藏密码），直连时为空。
+	Via string
+}
+
+// fetchSubscription 拉取并解析订阅链接；validators 不为空时发送 If-None-Match / If-Modified-Since。
+// 非 200 / 304 状态码或解析不出节点时返回错误。
+func fetchSubscription(client *http.Client, url string, validators upstreamValidators) (upstreamFetchResult, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return upstreamFetchResult{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return upstreamFetchResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return upstreamFetchResult{NotModified: true, Validators: validators}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return upstreamFetchResult{}, &upstreamStatusError{Code: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSubscriptionBodySize+1))
+	if err != nil {
+		return upstreamFetchResult{}, err
+	}
+	if int64(len(body)) > maxSubscriptionBodySize {
+		return upstreamFetchResult{}, fmt.Errorf("%w (limit %d bytes)", errSubscriptionTooLarge, maxSubscriptionBodySize)
+	}
+	proxies := parseSubscription(string(body))
+	if len(proxies) == 0 {
+		return upstreamFetchResult{}, errEmptySubscription
+	}
+	return upstreamFetchResult{
+		Proxies: proxies,
+		Validators: upstreamValidators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		},
+	}, nil
+}
+
+// retryableUpstreamError 网络错误、超时、空内容、408 / 429 与 5xx 可重试，其他 4xx 与超大内容重试也不会成功。
+func retryableUpstreamError(err error) bool {
+	if errors.Is(err, errSubscriptionTooLarge) {
+		return false
+	}
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.Code
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+	}
+	return true
+}
+
+// upstreamRetryDelay 第 attempt 次重试（从 1 开始）前的等待时间：base 每次翻倍，不超过 max，
+// 并在后一半区间内随机抖动，避免多个链接同时重试。
+func upstreamRetryDelay(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// redactSubscriptionURL 返回用于日志的订阅链接：订阅链接的路径或参数中通常带有机场的订阅 token，
+// 只保留协议与主机，如 https://sub.example.com/…。
+func redactSubscriptionURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "(invalid url)"
+	}
+	redacted := parsed.Scheme + "://" + parsed.Host
+	if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" {
+		redacted += "/…"
+	}
+	return redacted
+}
+
+// redactUpstreamError 隐藏 net/http 错误信息中的完整订阅链接（如 Get "https://…?token=…": timeout）。
+func redactUpstreamError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactSubscriptionURL(urlErr.URL)
+	}
+	return err
+}
+
+// upstreamHTTPClient 按订阅链接的单次超时与出站代理创建 HTTP 客户端，并返回使用的代理（已隐藏密码）。
+func upstreamHTTPClient(cfg config.UpstreamConfig, rawURL string) (*http.Client, string, error) {
+	host := ""
+	if parsed, err := url.Parse(rawURL); err == nil {
+		host = parsed.Hostname()
+	}
+	client := &http.Client{Timeout: cfg.TimeoutFor(rawURL, host)}
+
+	spec := cfg.ProxyFor(rawURL, host)
+	if spec == "" {
+		return client, "", nil
+	}
+	proxyURL, via, err := resolveUpstreamProxy(spec)
+	if err != nil {
+		return nil, "", fmt.Errorf("outbound proxy %s: %w", spec, err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client.Transport = transport
+	return client, via, nil
+}
+
+// upstreamNodePrefix 出站代理设置为 node:<节点名> 时使用已缓存的同名节点。
+const upstreamNodePrefix = "node:"
+
+// resolveUpstreamProxy 将出站代理设置解析为代理链接，支持 http / https / socks5 链接与 node:<节点名>。
+func resolveUpstreamProxy(spec string) (*url.URL, string, error) {
+	if name, isNode := strings.CutPrefix(spec, upstreamNodePrefix); isNode {
+		name = strings.TrimSpace(name)
+		proxyURL, err := cachedNodeProxyURL(name)
+		if err != nil {
+			return nil, "", err
+		}
+		return proxyURL, fmt.Sprintf("%s%s (%s)", upstreamNodePrefix, name, proxyURL.Redacted()), nil
+	}
+
+	proxyURL, err := url.Parse(spec)
+	if err != nil {
+		return nil, "", err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, "", fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, "", errors.New("proxy host is required")
+	}
+	return proxyURL, proxyURL.Redacted(), nil
+}
+
+// cachedNodeProxyURL 在当前订阅链接的节点缓存中查找名为 name 的 socks5 / http 节点并转换为代理链接。
+func cachedNodeProxyURL(name string) (*url.URL, error) {
+	urls, err := store.GetStoredSubscribeUrls()
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range normalizeSubscribeURLs(urls) {
+		nodes, _, found, err := store.GetProxyCache(source)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		for _, node := range nodes {
+			if nodeName, _ := node["name"].(string); nodeName == name {
+				return nodeProxyURL(node)
+			}
+		}
+	}
+	return nil, fmt.Errorf("node %s not found in proxy cache", name)
+}
+
+// nodeProxyURL 将 socks5 / http 类型的节点转换为代理链接，其他类型无法直接作为 HTTP 客户端的代理。
+func nodeProxyURL(node ProxyNode) (*url.URL, error) {
+	name, _ := node["name"].(string)
+	nodeType, _ := node["type"].(string)
+	scheme := ""
+	switch nodeType {
+	case "socks5":
+		scheme = "socks5"
+	case "http":
+		scheme = "http"
+		if tls, _ := node["tls"].(bool); tls {
+			scheme = "https"
+		}
+	default:
+		return nil, fmt.Errorf("node %s has type %q, only socks5 and http nodes can be used as outbound proxy", name, nodeType)
+	}
+
+	server, _ := node["server"].(string)
+	port := nodePort(node["port"])
+	if server == "" || port == "" {
+		return nil, fmt.Errorf("node %s has no server or port", name)
+	}
+	proxyURL := &url.URL{Scheme: scheme, Host: net.JoinHostPort(server, port)}
+	if username, _ := node["username"].(string); username != "" {
+		password, _ := node["password"].(string)
+		proxyURL.User = url.UserPassword(username, password)
+	}
+	return proxyURL, nil
+}
+
+// nodePort 读取节点端口：YAML 解析为 int，经 JSON 缓存后为 float64，部分订阅写成字符串。
+func nodePort(value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+// fetchSubscriptionWithRetry 拉取订阅链接，可重试的失败按 cfg 退避重试，返回实际尝试次数。
+// 出站代理无法解析（如节点不存在）时不发出请求，尝试次数为 0。
+func fetchSubscriptionWithRetry(cfg config.UpstreamConfig, rawURL string, validators upstreamValidators) (upstreamFetchResult, int, error) {
+	client, via, err := upstreamHTTPClient(cfg, rawURL)
+	if err != nil {
+		return upstreamFetchResult{}, 0, err
+	}
+	attempt := 0
+	for {
+		attempt++
+		result, err := fetchSubscription(client, rawURL, validators)
+		err = redactUpstreamError(err)
+		result.Via = via
+		if err == nil || attempt > cfg.Retries || !retryableUpstreamError(err) {
+			return result, attempt, err
+		}
+		delay := upstreamRetryDelay(attempt, cfg.RetryBackoff, cfg.RetryBackoffMax)
+		log.Printf("Fetch %s failed (attempt %d/%d), retrying in %s: %v", redactSubscriptionURL(rawURL), attempt, cfg.Retries+1, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
+
+// upstreamFollowUpDelay 刷新失败后再次尝试前的等待时间：interval 按连续失败次数翻倍，不超过每日刷新间隔。
+func upstreamFollowUpDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 1; i < failures && delay < proxyCacheRefreshInterval; i++ {
+		delay *= 2
+	}
+	if delay > proxyCacheRefreshInterval {
+		delay = proxyCacheRefreshInterval
+	}
+	return delay
+}
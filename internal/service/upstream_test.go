@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my-stash-rule/internal/model"
+	"my-stash-rule/internal/store"
+)
+
+var testSubscriptionBody = base64.StdEncoding.EncodeToString([]byte("trojan://secret@hk1.example.com:443#HK%2001"))
+
+// startSOCKS5Proxy 启动一个只支持用户名密码认证与 CONNECT 的最小 SOCKS5 代理，返回地址与建立的连接数。
+func startSOCKS5Proxy(t *testing.T, username, password string) (string, *int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var connects int32
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		buf := make([]byte, 262)
+		// 协商认证方式：只接受用户名密码（0x02）
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+			return
+		}
+		conn.Write([]byte{5, 2})
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != username || string(pass) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		// CONNECT 请求
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+			return
+		}
+		var host string
+		switch buf[3] {
+		case 1:
+			io.ReadFull(conn, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case 3:
+			io.ReadFull(conn, buf[:1])
+			name := make([]byte, buf[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		default:
+			return
+		}
+		io.ReadFull(conn, buf[:2])
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		atomic.AddInt32(&connects, 1)
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String(), &connects
+}
+
+func refreshOne(t *testing.T, source string) ProxyCacheRefreshItem {
+	t.Helper()
+	result, err := RefreshProxyCache([]string{source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Items[0]
+}
+
+func TestRefreshProxyCacheThroughHTTPProxy(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+
+	// 代理替身直接应答被代理的请求，目标主机无需真实存在
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.Host + " " + r.Header.Get("Proxy-Authorization"))
+		w.Write([]byte(testSubscriptionBody))
+	}))
+	t.Cleanup(proxy.Close)
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("fetcher", "s3cret")
+	t.Setenv("UPSTREAM_PROXY", proxyURL.String())
+
+	item := refreshOne(t, "http://blocked.example.com/sub?token=abc")
+	if item.Error != "" || item.Count != 1 {
+		t.Fatalf("refresh through proxy = %+v", item)
+	}
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("fetcher:s3cret"))
+	if got, _ := proxied.Load().(string); got != "blocked.example.com "+wantAuth {
+		t.Errorf("proxy saw %q", got)
+	}
+	if strings.Contains(item.Via, "s3cret") || !strings.Contains(item.Via, proxyURL.Host) {
+		t.Errorf("via = %q", item.Via)
+	}
+
+	// 按订阅链接设置为直连，忽略全局代理
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testSubscriptionBody))
+	}))
+	t.Cleanup(direct.Close)
+	t.Setenv("UPSTREAM_PROXY", "http://127.0.0.1:1")
+	t.Setenv("UPSTREAM_SOURCE_PROXIES", direct.URL+"=direct")
+	if item := refreshOne(t, direct.URL); item.Error != "" || item.Via != "" {
+		t.Errorf("direct source = %+v", item)
+	}
+}
+
+func TestRefreshProxyCacheThroughCachedSOCKS5Node(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	socksAddr, connects := startSOCKS5Proxy(t, "exit", "pa55")
+	socksHost, socksPort, _ := net.SplitHostPort(socksAddr)
+	port, _ := strconv.Atoi(socksPort)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testSubscriptionBody))
+	}))
+	t.Cleanup(upstream.Close)
+
+	const nodesSource = "https://nodes.example.com/sub"
+	if err := store.SaveSubscribeUrls([]string{nodesSource, upstream.URL}); err != nil {
+		t.Fatal(err)
+	}
+	nodes := []model.ProxyNode{
+		{"name": "SOCKS Exit", "type": "socks5", "server": socksHost, "port": port, "username": "exit", "password": "pa55"},
+		{"name": "Trojan Exit", "type": "trojan", "server": "tj.example.com", "port": 443, "password": "x"},
+	}
+	if err := store.SaveProxyCache(nodesSource, nodes, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("UPSTREAM_SOURCE_PROXIES", upstream.URL+"=node:SOCKS Exit")
+	item := refreshOne(t, upstream.URL)
+	if item.Error != "" || item.Count != 1 || atomic.LoadInt32(connects) != 1 {
+		t.Fatalf("refresh through socks5 node = %+v (connects: %d)", item, atomic.LoadInt32(connects))
+	}
+	if !strings.HasPrefix(item.Via, "node:SOCKS Exit (socks5://exit:") || strings.Contains(item.Via, "pa55") {
+		t.Errorf("via = %q", item.Via)
+	}
+
+	for spec, want := range map[string]string{
+		"node:Missing":     "not found in proxy cache",
+		"node:Trojan Exit": "only socks5 and http nodes",
+		"ftp://proxy:21":   "unsupported proxy scheme",
+	} {
+		t.Setenv("UPSTREAM_SOURCE_PROXIES", upstream.URL+"="+spec)
+		item := refreshOne(t, upstream.URL)
+		if item.Attempts != 0 || !item.Stale || !strings.Contains(item.Error, want) {
+			t.Errorf("%s: expected %q without requests, got %+v", spec, want, item)
+		}
+	}
+}
+
+func TestRefreshProxyCacheRedactsSubscriptionURL(t *testing.T) {
+	useMemoryStore(t)
+	fastUpstreamRetries(t)
+	t.Setenv("UPSTREAM_RETRIES", "1")
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// 一个拒绝连接的地址：net/http 的错误信息中会带上完整链接。
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + ln.Addr().String()
+	ln.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, source := range []string{closed + "/api/v1/client/subscribe?token=s3cr3t-closed", srv.URL + "/link/s3cr3t-path?flag=stash"} {
+		result, err := RefreshProxyCache([]string{source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item := result.Items[0]; item.Error == "" || strings.Contains(item.Error, "s3cr3t") {
+			t.Errorf("error = %q, want a failure without the subscription token", item.Error)
+		}
+	}
+	if out := logs.String(); strings.Contains(out, "s3cr3t") || !strings.Contains(out, srv.URL+"/…") {
+		t.Errorf("logs leak or miss the redacted subscription url:\n%s", out)
+	}
+}
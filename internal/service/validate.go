@@ -0,0 +1,310 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"my-stash-rule/internal/store"
+)
+
+// ConfigWarning 配置校验发现的问题，Path 指向出问题的字段，如 proxy-groups[3].proxies[1]。
+type ConfigWarning struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type configFieldKind int
+
+const (
+	fieldPort configFieldKind = iota
+	fieldBool
+	fieldString
+	fieldMap
+	fieldList
+)
+
+// configFieldKinds 已知顶层字段的类型，未列出的字段不做检查。
+var configFieldKinds = map[string]configFieldKind{
+	"port":                fieldPort,
+	"socks-port":          fieldPort,
+	"mixed-port":          fieldPort,
+	"redir-port":          fieldPort,
+	"tproxy-port":         fieldPort,
+	"allow-lan":           fieldBool,
+	"ipv6":                fieldBool,
+	"bind-address":        fieldString,
+	"mode":                fieldString,
+	"log-level":           fieldString,
+	"external-controller": fieldString,
+	"secret":              fieldString,
+	"dns":                 fieldMap,
+	"hosts":               fieldMap,
+	"proxy-providers":     fieldMap,
+	"rule-providers":      fieldMap,
+	"proxies":             fieldList,
+	"proxy-groups":        fieldList,
+	"rules":               fieldList,
+}
+
+// configFieldEnums 取值受限的字段。
+var configFieldEnums = map[string][]string{
+	"mode":      {"rule", "global", "direct", "script"},
+	"log-level": {"silent", "error", "warning", "info", "debug"},
+}
+
+var (
+	proxyGroupTypes = []string{"select", "url-test", "fallback", "load-balance", "relay"}
+	// builtinPolicies 无需定义即可被代理组与规则引用的策略。
+	builtinPolicies = []string{"DIRECT", "REJECT", "REJECT-DROP", "REJECT-TINYGIF"}
+)
+
+func containsString(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConfigMap 检查合并后的配置：字段类型、重复名称、代理组成员与规则目标引用。
+// 返回的问题不阻止生成配置，仅供提示。
+func ValidateConfigMap(config map[string]interface{}) []ConfigWarning {
+	v := &configValidator{}
+	v.checkFieldTypes(config)
+
+	policies := make(map[string]string) // name -> 定义位置
+	for _, name := range builtinPolicies {
+		policies[name] = "builtin"
+	}
+	v.collectProxies(config["proxies"], policies)
+	groups := v.collectGroups(config["proxy-groups"], policies)
+	providers := mapKeys(config["proxy-providers"])
+	for _, group := range groups {
+		v.checkGroupMembers(group, policies, providers)
+	}
+	v.checkRules(config["rules"], policies, mapKeys(config["rule-providers"]))
+	return v.warnings
+}
+
+type configValidator struct {
+	warnings []ConfigWarning
+}
+
+func (v *configValidator) warn(path, format string, args ...interface{}) {
+	v.warnings = append(v.warnings, ConfigWarning{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *configValidator) checkFieldTypes(config map[string]interface{}) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		if _, known := configFieldKinds[key]; known {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := config[key]
+		if value == nil {
+			v.warn(key, "value is empty")
+			continue
+		}
+		switch configFieldKinds[key] {
+		case fieldPort:
+			n, ok := value.(int)
+			if !ok {
+				v.warn(key, "expected an integer, got %T", value)
+			} else if n < 0 || n > 65535 {
+				v.warn(key, "port %d is out of range", n)
+			}
+		case fieldBool:
+			if _, ok := value.(bool); !ok {
+				v.warn(key, "expected a boolean, got %T", value)
+			}
+		case fieldString:
+			s, ok := value.(string)
+			if !ok {
+				v.warn(key, "expected a string, got %T", value)
+			} else if allowed := configFieldEnums[key]; allowed != nil && !containsString(allowed, s) {
+				v.warn(key, "unknown value %q, expected one of %s", s, strings.Join(allowed, ", "))
+			}
+		case fieldMap:
+			if _, ok := toStringMap(value); !ok {
+				v.warn(key, "expected a map, got %T", value)
+			}
+		case fieldList:
+			if _, ok := toInterfaceSlice(value); !ok {
+				v.warn(key, "expected a list, got %T", value)
+			}
+		}
+	}
+}
+
+func (v *configValidator) collectProxies(value interface{}, policies map[string]string) {
+	items, _ := toInterfaceSlice(value)
+	for i, item := range items {
+		path := fmt.Sprintf("proxies[%d]", i)
+		proxy, ok := toStringMap(item)
+		if !ok {
+			v.warn(path, "expected a map, got %T", item)
+			continue
+		}
+		name, _ := proxy["name"].(string)
+		if name == "" {
+			v.warn(path+".name", "proxy name is required")
+			continue
+		}
+		if t, _ := proxy["type"].(string); t == "" {
+			v.warn(path+".type", "proxy %s has no type", name)
+		}
+		if previous, dup := policies[name]; dup {
+			v.warn(path+".name", "duplicate name %s (already defined at %s)", name, previous)
+			continue
+		}
+		policies[name] = path
+	}
+}
+
+type configGroup struct {
+	path    string
+	name    string
+	members interface{}
+	use     interface{}
+}
+
+func (v *configValidator) collectGroups(value interface{}, policies map[string]string) []configGroup {
+	items, _ := toInterfaceSlice(value)
+	groups := make([]configGroup, 0, len(items))
+	for i, item := range items {
+		path := fmt.Sprintf("proxy-groups[%d]", i)
+		group, ok := toStringMap(item)
+		if !ok {
+			v.warn(path, "expected a map, got %T", item)
+			continue
+		}
+		name, _ := group["name"].(string)
+		if name == "" {
+			v.warn(path+".name", "proxy group name is required")
+			continue
+		}
+		if t, _ := group["type"].(string); !containsString(proxyGroupTypes, t) {
+			v.warn(path+".type", "proxy group %s has unknown type %q", name, t)
+		}
+		if previous, dup := policies[name]; dup {
+			v.warn(path+".name", "duplicate name %s (already defined at %s)", name, previous)
+			continue
+		}
+		policies[name] = path
+		groups = append(groups, configGroup{path: path, name: name, members: group["proxies"], use: group["use"]})
+	}
+	return groups
+}
+
+func (v *configValidator) checkGroupMembers(group configGroup, policies map[string]string, providers map[string]bool) {
+	members, isList := toInterfaceSlice(group.members)
+	if group.members != nil && !isList {
+		v.warn(group.path+".proxies", "expected a list, got %T", group.members)
+	}
+	uses, _ := toInterfaceSlice(group.use)
+	if len(members) == 0 && len(uses) == 0 {
+		v.warn(group.path+".proxies", "proxy group %s has no members", group.name)
+	}
+
+	for i, member := range members {
+		path := fmt.Sprintf("%s.proxies[%d]", group.path, i)
+		name, ok := member.(string)
+		switch {
+		case !ok:
+			v.warn(path, "expected a name, got %T", member)
+		case name == group.name:
+			v.warn(path, "proxy group %s references itself", group.name)
+		case policies[name] == "":
+			v.warn(path, "proxy group %s references unknown proxy or group %s", group.name, name)
+		}
+	}
+	for i, use := range uses {
+		if name, _ := use.(string); !providers[name] {
+			v.warn(fmt.Sprintf("%s.use[%d]", group.path, i), "proxy group %s uses unknown proxy provider %v", group.name, use)
+		}
+	}
+}
+
+func (v *configValidator) checkRules(value interface{}, policies map[string]string, ruleProviders map[string]bool) {
+	items, _ := toInterfaceSlice(value)
+	for i, item := range items {
+		path := fmt.Sprintf("rules[%d]", i)
+		rule, ok := item.(string)
+		if !ok {
+			v.warn(path, "expected a string, got %T", item)
+			continue
+		}
+		ruleType, payload, target, ok := parseRule(rule)
+		if !ok {
+			v.warn(path, "malformed rule %q", rule)
+			continue
+		}
+		if policies[target] == "" {
+			v.warn(path, "rule targets unknown proxy or group %s", target)
+		}
+		if ruleType == "RULE-SET" && !ruleProviders[payload] {
+			v.warn(path, "rule references unknown rule provider %s", payload)
+		}
+	}
+}
+
+// parseRule 拆出规则类型、匹配内容与目标策略，如 DOMAIN-SUFFIX,google.com,Proxies。
+// 逻辑规则（AND / OR / NOT）的条件带括号，目标取最后一个右括号之后的字段。
+func parseRule(rule string) (ruleType, payload, target string, ok bool) {
+	parts := strings.Split(rule, ",")
+	ruleType = strings.ToUpper(strings.TrimSpace(parts[0]))
+	switch ruleType {
+	case "MATCH", "FINAL":
+		if len(parts) < 2 {
+			return ruleType, "", "", false
+		}
+		return ruleType, "", strings.TrimSpace(parts[1]), true
+	case "AND", "OR", "NOT":
+		end := strings.LastIndex(rule, ")")
+		if end < 0 {
+			return ruleType, "", "", false
+		}
+		rest := strings.Split(strings.TrimPrefix(strings.TrimSpace(rule[end+1:]), ","), ",")
+		target = strings.TrimSpace(rest[0])
+		return ruleType, "", target, target != ""
+	}
+	if len(parts) < 3 {
+		return ruleType, "", "", false
+	}
+	return ruleType, strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]), true
+}
+
+func mapKeys(value interface{}) map[string]bool {
+	m, _ := toStringMap(value)
+	keys := make(map[string]bool, len(m))
+	for key := range m {
+		keys[key] = true
+	}
+	return keys
+}
+
+// ValidateProfileConfig 使用当前节点缓存与示例数据生成模板 name 的完整配置并校验，
+// 同时返回生成配置时会自动修正的失效引用。
+func ValidateProfileConfig(name string) ([]ConfigWarning, []ConfigChange, error) {
+	urls, err := store.GetStoredSubscribeUrls()
+	if err != nil {
+		return nil, nil, err
+	}
+	proxies, err := BuildProxiesFromCache(urls)
+	if err != nil {
+		return nil, nil, err
+	}
+	overlays, _, err := ResolveProfileOverlays(name, store.SampleProfileTemplateData())
+	if err != nil {
+		return nil, nil, err
+	}
+	config := BuildMergedConfig(proxies, overlays...)
+	_, changes := PruneDanglingReferences(config)
+	return ValidateConfigMap(config), changes, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func warningPaths(warnings []ConfigWarning) string {
+	paths := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		paths = append(paths, w.Path)
+	}
+	return strings.Join(paths, " ")
+}
+
+func TestValidateConfigMapDefaultConfigIsClean(t *testing.T) {
+	proxies := []ProxyNode{
+		{"name": "HK 01", "type": "trojan", "server": "hk1.example.com", "port": 443},
+		{"name": "JP 01", "type": "trojan", "server": "jp1.example.com", "port": 443},
+	}
+	if warnings := ValidateConfigMap(BuildConfigMap(proxies)); len(warnings) != 0 {
+		t.Fatalf("default config should be valid, got %+v", warnings)
+	}
+}
+
+func TestValidateConfigMapReferences(t *testing.T) {
+	config := mustYAMLMap(t, `
+proxies:
+  - {name: A, type: ss}
+  - {name: A, type: ss}
+  - {name: B}
+proxy-groups:
+  - {name: G1, type: select, proxies: [A, Missing, G1, DIRECT]}
+  - {name: G2, type: wrong, proxies: [G1]}
+  - {name: A, type: select, proxies: [B]}
+  - {name: Empty, type: select}
+  - {name: P, type: select, use: [provider-x]}
+rule-providers:
+  ads: {behavior: domain, url: https://example.com/ads.yaml}
+rules:
+  - DOMAIN-SUFFIX,google.com,G1
+  - DOMAIN,example.com,Nowhere
+  - RULE-SET,ads,REJECT
+  - RULE-SET,tracking,REJECT
+  - AND,((DOMAIN,example.com),(NETWORK,UDP)),G2
+  - OR,((DOMAIN,a.com),(DOMAIN,b.com)),Gone
+  - GEOIP
+  - MATCH,G2
+`)
+	got := warningPaths(ValidateConfigMap(config))
+	want := "proxies[1].name proxies[2].type proxy-groups[1].type proxy-groups[2].name " +
+		"proxy-groups[0].proxies[1] proxy-groups[0].proxies[2] proxy-groups[3].proxies proxy-groups[4].use[0] " +
+		"rules[1] rules[3] rules[5] rules[6]"
+	if got != want {
+		t.Fatalf("warning paths:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestValidateConfigMapFieldTypes(t *testing.T) {
+	config := mustYAMLMap(t, `
+mixed-port: "7890"
+port: 70000
+allow-lan: "yes"
+mode: everything
+log-level: debug
+dns: [1.1.1.1]
+rules: DOMAIN,a.com,DIRECT
+external-controller:
+`)
+	got := warningPaths(ValidateConfigMap(config))
+	want := "allow-lan dns external-controller mixed-port mode port rules"
+	if got != want {
+		t.Fatalf("warning paths:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	cases := []struct {
+		rule, ruleType, payload, target string
+		ok                              bool
+	}{
+		{"DOMAIN-SUFFIX,google.com,Proxies", "DOMAIN-SUFFIX", "google.com", "Proxies", true},
+		{"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve", "IP-CIDR", "10.0.0.0/8", "DIRECT", true},
+		{"match,Final", "MATCH", "", "Final", true},
+		{"NOT,((DOMAIN,a.com)),REJECT", "NOT", "", "REJECT", true},
+		{"DOMAIN,a.com", "DOMAIN", "", "", false},
+	}
+	for _, c := range cases {
+		ruleType, payload, target, ok := parseRule(c.rule)
+		if ruleType != c.ruleType || payload != c.payload || target != c.target || ok != c.ok {
+			t.Errorf("parseRule(%q) = %q %q %q %v", c.rule, ruleType, payload, target, ok)
+		}
+	}
+}
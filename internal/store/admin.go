@@ -0,0 +1,638 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AdminRole 管理员角色。
+type AdminRole string
+
+const (
+	// AdminRoleViewer 只读访问。
+	AdminRoleViewer AdminRole = "viewer"
+	// AdminRoleEditor 可编辑订阅链接与配置模板。
+	AdminRoleEditor AdminRole = "editor"
+	// AdminRoleOwner 拥有全部权限，包括管理其他管理员。
+	AdminRoleOwner AdminRole = "owner"
+)
+
+var adminRoleRank = map[AdminRole]int{
+	AdminRoleViewer: 1,
+	AdminRoleEditor: 2,
+	AdminRoleOwner:  3,
+}
+
+// Valid 判断角色是否为已知角色。
+func (r AdminRole) Valid() bool {
+	_, ok := adminRoleRank[r]
+	return ok
+}
+
+// Allows 判断当前角色是否满足 required 角色的权限要求。
+func (r AdminRole) Allows(required AdminRole) bool {
+	return r.Valid() && adminRoleRank[r] >= adminRoleRank[required]
+}
+
+var ErrAdminNotFound = errors.New("admin not found")
+
+// AdminUser 表示一个管理员账号（不含密码）。
+type AdminUser struct {
+	Username    string    `json:"username"`
+	Role        AdminRole `json:"role"`
+	CreatedAt   int64     `json:"created_at"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	Source      string    `json:"source,omitempty"`
+}
+
+// adminRecord 是管理员账号的存储结构。
+type adminRecord struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Role         AdminRole `json:"role"`
+	CreatedAt    int64     `json:"created_at"`
+
+	// Source 账号来源：空为本地账号，"oidc" 为单点登录自动创建（无本地密码）。
+	Source string `json:"source,omitempty"`
+	// SSOIssuer / SSOSubject 单点登录账号对应的身份提供方与用户标识（iss + sub），用于再次登录时匹配账号。
+	SSOIssuer  string `json:"sso_issuer,omitempty"`
+	SSOSubject string `json:"sso_subject,omitempty"`
+
+	TOTPSecret        string   `json:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes     []string `json:"recovery_codes,omitempty"` // sha256(hex)
+}
+
+func (a adminRecord) user() AdminUser {
+	return AdminUser{
+		Username:    a.Username,
+		Role:        a.Role,
+		CreatedAt:   a.CreatedAt,
+		TOTPEnabled: a.TOTPSecret != "",
+		Source:      a.Source,
+	}
+}
+
+func getAdminRecord(username string) (*adminRecord, error) {
+	record, err := backend.GetAdmin(username)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	if !record.Role.Valid() {
+		record.Role = AdminRoleViewer
+	}
+	return record, nil
+}
+
+// updateAdminRecord 原子地读取-修改-写回管理员记录。
+func updateAdminRecord(username string, fn func(record *adminRecord) error) error {
+	return backend.UpdateAdmin(username, fn)
+}
+
+func listAdminRecords() ([]adminRecord, error) {
+	records, err := backend.ListAdmins()
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if !records[i].Role.Valid() {
+			records[i].Role = AdminRoleViewer
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Username < records[j].Username
+	})
+	return records, nil
+}
+
+func countAdminOwners() (int, error) {
+	records, err := listAdminRecords()
+	if err != nil {
+		return 0, err
+	}
+	owners := 0
+	for _, record := range records {
+		if record.Role == AdminRoleOwner {
+			owners++
+		}
+	}
+	return owners, nil
+}
+
+// legacyAdminMigrator 由支持旧版数据迁移的后端实现。
+type legacyAdminMigrator interface {
+	// migrateLegacyAdmin 将旧版单管理员迁移为 owner，返回是否发生迁移。
+	migrateLegacyAdmin() (bool, error)
+}
+
+// InitDefaultAdmin 初始化管理员账号。
+// 若存在旧版单管理员配置（stash-rule:admin），迁移为 owner；
+// 若没有任何管理员，创建默认管理员 admin/admin（owner）。
+func InitDefaultAdmin() error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	count, err := backend.CountAdmins()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if migrator, ok := backend.(legacyAdminMigrator); ok {
+		migrated, err := migrator.migrateLegacyAdmin()
+		if err != nil {
+			return err
+		}
+		if migrated {
+			return nil
+		}
+	}
+
+	if err := CreateAdminUser("admin", "admin", AdminRoleOwner); err != nil {
+		return err
+	}
+	log.Println("Created default admin account: admin")
+	return nil
+}
+
+// AuthenticateAdmin 验证管理员账号
+func AuthenticateAdmin(username, password string) (bool, error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return false, nil
+	}
+
+	record, err := getAdminRecord(username)
+	if err != nil {
+		return false, err
+	}
+	if record == nil || record.PasswordHash == "" {
+		return false, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password))
+	return err == nil, nil
+}
+
+// GetAdminUser 获取管理员账号，不存在时返回 nil。
+func GetAdminUser(username string) (*AdminUser, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, nil
+	}
+
+	record, err := getAdminRecord(username)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	user := record.user()
+	return &user, nil
+}
+
+// ListAdminUsers 获取全部管理员账号。
+func ListAdminUsers() ([]AdminUser, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	records, err := listAdminRecords()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]AdminUser, 0, len(records))
+	for _, record := range records {
+		users = append(users, record.user())
+	}
+	return users, nil
+}
+
+// CreateAdminUser 新增管理员账号。
+func CreateAdminUser(username, password string, role AdminRole) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password is required")
+	}
+	if !role.Valid() {
+		return fmt.Errorf("invalid role")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return backend.CreateAdmin(adminRecord{
+		Username:     username,
+		PasswordHash: string(hashedPassword),
+		Role:         role,
+		CreatedAt:    time.Now().Unix(),
+	})
+}
+
+// SSOIdentity 身份提供方确认的管理员身份。
+type SSOIdentity struct {
+	// Source 账号来源，如 "oidc"。
+	Source  string
+	Issuer  string
+	Subject string
+	// Username 首次登录创建账号时使用的用户名。
+	Username string
+	Role     AdminRole
+}
+
+// UpsertSSOAdmin 单点登录成功后创建或更新管理员账号，角色以身份提供方声明为准。
+// 按 Source + Issuer + Subject 匹配已有的单点登录账号，不会接管同名的本地账号：
+// 首次登录时用户名已被占用则使用 "<source>:<subject>"。同步角色时不允许降级最后一个 owner。
+func UpsertSSOAdmin(identity SSOIdentity) (*AdminUser, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	identity.Username = strings.TrimSpace(identity.Username)
+	if identity.Source == "" || identity.Issuer == "" || identity.Subject == "" {
+		return nil, fmt.Errorf("sso source, issuer and subject are required")
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if !identity.Role.Valid() {
+		return nil, fmt.Errorf("invalid role")
+	}
+
+	records, err := listAdminRecords()
+	if err != nil {
+		return nil, err
+	}
+	var matched, legacy *adminRecord
+	usernameTaken := false
+	for i := range records {
+		record := &records[i]
+		if record.Username == identity.Username {
+			usernameTaken = true
+		}
+		if record.Source != identity.Source {
+			continue
+		}
+		if record.SSOIssuer == identity.Issuer && record.SSOSubject == identity.Subject {
+			matched = record
+		}
+		// 旧版本创建的单点登录账号没有记录 iss / sub，按用户名认领并补写。
+		if record.SSOSubject == "" && record.Username == identity.Username {
+			legacy = record
+		}
+	}
+	if matched == nil {
+		matched = legacy
+	}
+
+	if matched != nil {
+		if matched.Role == AdminRoleOwner && identity.Role != AdminRoleOwner {
+			owners, err := countAdminOwners()
+			if err != nil {
+				return nil, err
+			}
+			if owners <= 1 {
+				return nil, fmt.Errorf("cannot demote the last owner")
+			}
+		}
+		var user AdminUser
+		if err := updateAdminRecord(matched.Username, func(record *adminRecord) error {
+			record.Role = identity.Role
+			record.SSOIssuer = identity.Issuer
+			record.SSOSubject = identity.Subject
+			user = record.user()
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+
+	username := identity.Username
+	if usernameTaken {
+		username = identity.Source + ":" + identity.Subject
+	}
+	record := adminRecord{
+		Username:   username,
+		Role:       identity.Role,
+		CreatedAt:  time.Now().Unix(),
+		Source:     identity.Source,
+		SSOIssuer:  identity.Issuer,
+		SSOSubject: identity.Subject,
+	}
+	if err := backend.CreateAdmin(record); err != nil {
+		if errors.Is(err, errAdminExists) {
+			return nil, fmt.Errorf("admin username %s is already taken", username)
+		}
+		return nil, err
+	}
+	log.Printf("Created %s admin account %s (%s)", identity.Source, username, identity.Role)
+	user := record.user()
+	return &user, nil
+}
+
+// UpdateAdminUser 由 owner 修改管理员角色或重置密码（newPassword 为空时保持不变）。
+func UpdateAdminUser(username string, role AdminRole, newPassword string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if !role.Valid() {
+		return fmt.Errorf("invalid role")
+	}
+
+	record, err := getAdminRecord(username)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return ErrAdminNotFound
+	}
+
+	if record.Role == AdminRoleOwner && role != AdminRoleOwner {
+		owners, err := countAdminOwners()
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return fmt.Errorf("cannot demote the last owner")
+		}
+	}
+
+	passwordHash := ""
+	passwordChanged := strings.TrimSpace(newPassword) != ""
+	if passwordChanged {
+		encoded, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		passwordHash = string(encoded)
+	}
+
+	if err := updateAdminRecord(username, func(record *adminRecord) error {
+		record.Role = role
+		if passwordChanged {
+			record.PasswordHash = passwordHash
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if passwordChanged {
+		return RevokeAllSessions(username, "")
+	}
+	return nil
+}
+
+// DeleteAdminUser 删除管理员账号，不允许删除最后一个 owner。
+func DeleteAdminUser(username string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	record, err := getAdminRecord(username)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return ErrAdminNotFound
+	}
+
+	if record.Role == AdminRoleOwner {
+		owners, err := countAdminOwners()
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return fmt.Errorf("cannot delete the last owner")
+		}
+	}
+
+	if err := backend.DeleteAdmin(username); err != nil {
+		return err
+	}
+	if err := RevokeAllAdminAPITokens(username); err != nil {
+		return err
+	}
+	return RevokeAllSessions(username, "")
+}
+
+// UpdateAdminCredentials 管理员修改自己的用户名和密码。
+// currentPassword 必填用于校验；newPassword 为空时保持原密码不变。
+// 用户名或密码变更时会撤销该管理员的全部 session；用户名变更时同时撤销其 API Token。
+func UpdateAdminCredentials(username, currentPassword, newUsername, newPassword string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	currentPassword = strings.TrimSpace(currentPassword)
+	newUsername = strings.TrimSpace(newUsername)
+	if currentPassword == "" {
+		return fmt.Errorf("current password is required")
+	}
+	if newUsername == "" {
+		return fmt.Errorf("new username is required")
+	}
+
+	record, err := getAdminRecord(username)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return ErrAdminNotFound
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(currentPassword)); err != nil {
+		return fmt.Errorf("current password is incorrect")
+	}
+
+	passwordChanged := strings.TrimSpace(newPassword) != ""
+	if passwordChanged {
+		encoded, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		record.PasswordHash = string(encoded)
+	}
+
+	oldUsername := record.Username
+	record.Username = newUsername
+
+	if oldUsername != newUsername {
+		if err := backend.RenameAdmin(oldUsername, *record); err != nil {
+			return err
+		}
+		log.Printf("Admin username changed: %s -> %s", oldUsername, newUsername)
+	} else if passwordChanged {
+		passwordHash := record.PasswordHash
+		if err := updateAdminRecord(oldUsername, func(current *adminRecord) error {
+			current.PasswordHash = passwordHash
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if oldUsername != newUsername {
+		if err := RevokeAllAdminAPITokens(oldUsername); err != nil {
+			return err
+		}
+	}
+	if passwordChanged || oldUsername != newUsername {
+		return RevokeAllSessions(oldUsername, "")
+	}
+	return nil
+}
+
+// AdminTOTP 表示管理员两步验证状态。
+type AdminTOTP struct {
+	Enabled           bool
+	Secret            string
+	PendingSecret     string
+	LastStep          int64
+	RecoveryCodesLeft int
+}
+
+// GetAdminTOTP 获取管理员两步验证状态。
+func GetAdminTOTP(username string) (AdminTOTP, error) {
+	if backend == nil {
+		return AdminTOTP{}, errStoreNotInitialized
+	}
+
+	record, err := getAdminRecord(strings.TrimSpace(username))
+	if err != nil {
+		return AdminTOTP{}, err
+	}
+	if record == nil {
+		return AdminTOTP{}, ErrAdminNotFound
+	}
+	return AdminTOTP{
+		Enabled:           record.TOTPSecret != "",
+		Secret:            record.TOTPSecret,
+		PendingSecret:     record.TOTPPendingSecret,
+		LastStep:          record.TOTPLastStep,
+		RecoveryCodesLeft: len(record.RecoveryCodes),
+	}, nil
+}
+
+// SetAdminTOTPPending 保存待确认的 TOTP 密钥（确认前不影响登录）。
+func SetAdminTOTPPending(username, secret string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return updateAdminRecord(username, func(record *adminRecord) error {
+		record.TOTPPendingSecret = secret
+		return nil
+	})
+}
+
+// EnableAdminTOTP 启用待确认的 TOTP 密钥，并保存恢复码哈希。
+func EnableAdminTOTP(username string, step int64, recoveryCodeHashes []string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return updateAdminRecord(username, func(record *adminRecord) error {
+		if record.TOTPPendingSecret == "" {
+			return fmt.Errorf("totp setup not started")
+		}
+		record.TOTPSecret = record.TOTPPendingSecret
+		record.TOTPPendingSecret = ""
+		record.TOTPLastStep = step
+		record.RecoveryCodes = recoveryCodeHashes
+		return nil
+	})
+}
+
+// DisableAdminTOTP 关闭两步验证并清空恢复码。
+func DisableAdminTOTP(username string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return updateAdminRecord(username, func(record *adminRecord) error {
+		record.TOTPSecret = ""
+		record.TOTPPendingSecret = ""
+		record.TOTPLastStep = 0
+		record.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// MarkAdminTOTPStepUsed 记录已使用的 TOTP 时间步；step 不大于上次记录时返回 false（重放）。
+func MarkAdminTOTPStepUsed(username string, step int64) (bool, error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+	accepted := false
+	err := updateAdminRecord(username, func(record *adminRecord) error {
+		if step <= record.TOTPLastStep {
+			return nil
+		}
+		record.TOTPLastStep = step
+		accepted = true
+		return nil
+	})
+	return accepted, err
+}
+
+// ReplaceAdminRecoveryCodes 替换全部恢复码哈希。
+func ReplaceAdminRecoveryCodes(username string, recoveryCodeHashes []string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return updateAdminRecord(username, func(record *adminRecord) error {
+		if record.TOTPSecret == "" {
+			return fmt.Errorf("totp is not enabled")
+		}
+		record.RecoveryCodes = recoveryCodeHashes
+		return nil
+	})
+}
+
+// ConsumeAdminRecoveryCode 使用一次恢复码，命中后立即作废。
+func ConsumeAdminRecoveryCode(username, recoveryCodeHash string) (bool, error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+	used := false
+	err := updateAdminRecord(username, func(record *adminRecord) error {
+		remaining := make([]string, 0, len(record.RecoveryCodes))
+		for _, hash := range record.RecoveryCodes {
+			if !used && hash == recoveryCodeHash {
+				used = true
+				continue
+			}
+			remaining = append(remaining, hash)
+		}
+		record.RecoveryCodes = remaining
+		return nil
+	})
+	return used, err
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// adminAPITokenPrefix 便于在日志、密钥扫描中识别管理 API Token。
+	adminAPITokenPrefix = "sra_"
+	// adminAPITokenTouchInterval 限制 last_used 的写入频率。
+	adminAPITokenTouchInterval = time.Minute
+)
+
+// ErrAdminAPITokenNotFound 管理 API Token 不存在
+var ErrAdminAPITokenNotFound = fmt.Errorf("api token not found")
+
+// AdminAPIToken 管理员 API Token 的元数据（不含明文和哈希）。
+// 实际权限为 Role 与所属管理员当前角色中较低的一个。
+type AdminAPIToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Username   string    `json:"username"`
+	Role       AdminRole `json:"role"`
+	CreatedAt  int64     `json:"created_at"`
+	ExpiresAt  int64     `json:"expires_at,omitempty"`
+	LastUsedAt int64     `json:"last_used_at,omitempty"`
+	LastUsedIP string    `json:"last_used_ip,omitempty"`
+}
+
+// Expired 判断 Token 是否已过期（ExpiresAt 为 0 表示永不过期）。
+func (t AdminAPIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt > 0 && now.Unix() >= t.ExpiresAt
+}
+
+type adminAPITokenRecord struct {
+	AdminAPIToken
+	TokenHash string `json:"token_hash"`
+}
+
+func hashAdminAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAdminAPIToken 为管理员创建 API Token，返回仅展示一次的明文。
+// role 不得高于管理员当前角色；ttl 为 0 表示永不过期。
+func CreateAdminAPIToken(username, name string, role AdminRole, ttl time.Duration) (string, *AdminAPIToken, error) {
+	if backend == nil {
+		return "", nil, errStoreNotInitialized
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("name is required")
+	}
+	if !role.Valid() {
+		return "", nil, fmt.Errorf("invalid role")
+	}
+
+	admin, err := GetAdminUser(username)
+	if err != nil {
+		return "", nil, err
+	}
+	if admin == nil {
+		return "", nil, ErrAdminNotFound
+	}
+	if !admin.Role.Allows(role) {
+		return "", nil, fmt.Errorf("role exceeds your own role")
+	}
+
+	random, err := generateRandomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := adminAPITokenPrefix + random
+	hash := hashAdminAPIToken(token)
+
+	now := time.Now()
+	record := adminAPITokenRecord{
+		AdminAPIToken: AdminAPIToken{
+			ID:        hash[:16],
+			Name:      name,
+			Username:  admin.Username,
+			Role:      role,
+			CreatedAt: now.Unix(),
+		},
+		TokenHash: hash,
+	}
+	if ttl > 0 {
+		record.ExpiresAt = now.Add(ttl).Unix()
+	}
+
+	if err := backend.SaveAdminAPIToken(record); err != nil {
+		return "", nil, err
+	}
+
+	info := record.AdminAPIToken
+	return token, &info, nil
+}
+
+// ValidateAdminAPIToken 校验 API Token，有效时返回元数据并记录最近使用时间与 IP。
+// Token 不存在或已过期时返回 nil。
+func ValidateAdminAPIToken(token, ip string) (*AdminAPIToken, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, adminAPITokenPrefix) {
+		return nil, nil
+	}
+
+	hash := hashAdminAPIToken(token)
+	record, err := backend.FindAdminAPIToken(hash)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.TokenHash != hash {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if record.Expired(now) {
+		return nil, nil
+	}
+
+	if now.Unix()-record.LastUsedAt >= int64(adminAPITokenTouchInterval.Seconds()) || record.LastUsedIP != ip {
+		record.LastUsedAt = now.Unix()
+		record.LastUsedIP = ip
+		if err := backend.SaveAdminAPIToken(*record); err != nil {
+			return nil, err
+		}
+	}
+
+	info := record.AdminAPIToken
+	return &info, nil
+}
+
+// ListAdminAPITokens 列出管理员的 API Token（最新创建在前），username 为空时列出全部。
+func ListAdminAPITokens(username string) ([]AdminAPIToken, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	records, err := backend.ListAdminAPITokens()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]AdminAPIToken, 0, len(records))
+	for _, record := range records {
+		if username != "" && record.Username != username {
+			continue
+		}
+		tokens = append(tokens, record.AdminAPIToken)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt > tokens[j].CreatedAt
+	})
+	return tokens, nil
+}
+
+// RevokeAdminAPIToken 撤销管理员的某个 API Token。
+func RevokeAdminAPIToken(username, id string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	record, err := backend.GetAdminAPIToken(strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Username != username {
+		return ErrAdminAPITokenNotFound
+	}
+	return backend.DeleteAdminAPITokens(*record)
+}
+
+// RevokeAllAdminAPITokens 撤销管理员的全部 API Token（删除账号或改名时调用）。
+func RevokeAllAdminAPITokens(username string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	records, err := backend.ListAdminAPITokens()
+	if err != nil {
+		return err
+	}
+
+	revoke := make([]adminAPITokenRecord, 0, len(records))
+	for _, record := range records {
+		if record.Username == username {
+			revoke = append(revoke, record)
+		}
+	}
+	if len(revoke) == 0 {
+		return nil
+	}
+	return backend.DeleteAdminAPITokens(revoke...)
+}
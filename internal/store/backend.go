@@ -0,0 +1,234 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"my-stash-rule/internal/config"
+	"my-stash-rule/internal/model"
+)
+
+var (
+	errStoreNotInitialized = errors.New("store not initialized")
+	errAdminExists         = errors.New("admin already exists")
+	errProfileExists       = errors.New("stash profile already exists")
+	errSubscriberExists    = errors.New("subscriber already exists")
+	errSubscriberNotFound  = errors.New("subscriber not found")
+	errTokenExists         = errors.New("token already exists")
+)
+
+// backend 当前使用的存储后端，由 InitStorage / UseBackend 设置。
+var backend Backend
+
+// Backend 存储后端。业务校验（参数规范化、权限、密码哈希等）由包级函数完成，
+// 后端只负责按记录读写，并保证单条记录的读-改-写是原子的。
+type Backend interface {
+	AdminBackend
+	SessionBackend
+	ProfileBackend
+	SubscriberBackend
+	ProxyCacheBackend
+	SecurityBackend
+
+	// DataVersion 影响配置生成的数据（订阅链接、模板、订阅用户、节点缓存）的版本号，
+	// IncrDataVersion 在这些数据修改后递增；Redis 后端的版本号在多个实例间共享。
+	DataVersion() (int64, error)
+	IncrDataVersion() error
+
+	// Name 后端名称，用于日志。
+	Name() string
+	// Close 释放连接或文件锁。
+	Close() error
+}
+
+// AdminBackend 管理员账号与管理 API Token。
+type AdminBackend interface {
+	CountAdmins() (int, error)
+	// GetAdmin 不存在时返回 nil。
+	GetAdmin(username string) (*adminRecord, error)
+	ListAdmins() ([]adminRecord, error)
+	// CreateAdmin 用户名已存在时返回 errAdminExists。
+	CreateAdmin(record adminRecord) error
+	// UpdateAdmin 原子地读取-修改-写回，不存在时返回 ErrAdminNotFound。
+	UpdateAdmin(username string, fn func(record *adminRecord) error) error
+	// RenameAdmin 以新用户名保存记录并删除旧记录，新用户名已存在时返回 errAdminExists。
+	RenameAdmin(oldUsername string, record adminRecord) error
+	DeleteAdmin(username string) error
+
+	GetAdminAPIToken(id string) (*adminAPITokenRecord, error)
+	// FindAdminAPIToken 按 token 哈希查找，不存在时返回 nil。
+	FindAdminAPIToken(hash string) (*adminAPITokenRecord, error)
+	ListAdminAPITokens() ([]adminAPITokenRecord, error)
+	SaveAdminAPIToken(record adminAPITokenRecord) error
+	DeleteAdminAPITokens(records ...adminAPITokenRecord) error
+}
+
+// SessionBackend 管理员登录会话。
+type SessionBackend interface {
+	// SaveSession 写入会话并加入管理员的会话索引，ttl 后自动失效。
+	SaveSession(token string, session Session, ttl time.Duration) error
+	// GetSession 不存在或已过期时返回 nil。
+	GetSession(token string) (*Session, error)
+	// ListSessionTokens 返回管理员会话索引中的 token（可能包含已过期的）。
+	ListSessionTokens(username string) ([]string, error)
+	DeleteSessions(username string, tokens ...string) error
+}
+
+// ProfileBackend 订阅链接与 Stash 配置模板。
+type ProfileBackend interface {
+	GetSubscribeURLs() ([]string, error)
+	SaveSubscribeURLs(urls []string) error
+
+	// ListProfiles 返回 模板名 -> YAML 内容。
+	ListProfiles() (map[string]string, error)
+	GetProfile(name string) (content string, found bool, err error)
+	SaveProfile(name, content string) error
+	// DeleteProfile 删除模板及其历史版本，并在同一事务中将绑定该模板的订阅用户改绑到 reassignTo；
+	// reassignTo 为空且仍有订阅用户绑定时不删除，返回 *StashProfileInUseError。
+	DeleteProfile(name, reassignTo string) error
+	// RenameProfile 重命名模板，历史版本与绑定的订阅用户一并迁移；newName 已存在时返回 errProfileExists。
+	RenameProfile(oldName, newName string) error
+
+	// AddProfileRevision 写入模板历史版本（最新在前），仅保留最近 maxLen 个。
+	// 版本号由后端在同一事务中按最新版本号 +1 分配，忽略 revision.Version。
+	AddProfileRevision(name string, revision StashProfileRevision, maxLen int) error
+	// ListProfileRevisions 返回模板历史版本，最新在前。
+	ListProfileRevisions(name string) ([]StashProfileRevision, error)
+}
+
+// SubscriberBackend 订阅用户、拉取统计与泄露告警。
+type SubscriberBackend interface {
+	ListSubscribers() ([]Subscriber, error)
+	// GetSubscriber 不存在时返回 nil。
+	GetSubscriber(username string) (*Subscriber, error)
+	// FindSubscriberByToken 不存在时返回 nil。
+	FindSubscriberByToken(token string) (*Subscriber, error)
+	// CreateSubscriber 用户名或 token 已存在时返回 errSubscriberExists / errTokenExists。
+	CreateSubscriber(subscriber Subscriber) error
+	SetSubscriberProfile(username, profileName string) error
+	// SetSubscriberAttributes 覆盖订阅用户的标签与自定义变量。
+	SetSubscriberAttributes(username string, tags []string, vars map[string]string) error
+	// SetSubscriberOverride 覆盖订阅用户的专属配置，content 为空表示清除。
+	SetSubscriberOverride(username, content string) error
+	// SetSubscriberDisabled disabled=false 时清除禁用原因。
+	SetSubscriberDisabled(username string, disabled bool, reason string) error
+	// DeleteSubscriber 删除订阅用户及其拉取统计。
+	DeleteSubscriber(username string) error
+
+	// RecordFetch 保存最近一次拉取信息，并返回窗口内不同 IP / 客户端数量。
+	RecordFetch(username string, stats FetchStats, now time.Time, window time.Duration) (FetchUsage, error)
+	GetFetchStats(username string) (FetchStats, error)
+	// ListFetchSources 返回窗口内的 IP 和客户端（最近使用在前）。
+	ListFetchSources(username string) (ips []string, clients []string, err error)
+	// MarkFetchFlagged 窗口内首次标记时返回 true。
+	MarkFetchFlagged(username string, window time.Duration) (bool, error)
+	ResetFetchTracking(username string) error
+
+	// AddLeakAlert 写入告警，仅保留最近 maxLen 条。
+	AddLeakAlert(alert LeakAlert, maxLen int) error
+	ListLeakAlerts() ([]LeakAlert, error)
+	ClearLeakAlerts() error
+}
+
+// ProxyCacheBackend 订阅节点缓存。
+type ProxyCacheBackend interface {
+	SaveProxyCache(url string, proxies []model.ProxyNode, updatedAt time.Time) error
+	GetProxyCache(url string) (proxies []model.ProxyNode, updatedAt int64, found bool, err error)
+	SetProxyCacheLastRunAt(t time.Time) error
+	GetProxyCacheLastRunAt() (int64, error)
+	// SaveProxyFetchState / GetProxyFetchState 单个订阅链接的拉取状态（条件请求校验值与失败记录）。
+	SaveProxyFetchState(url string, state ProxyFetchState) error
+	GetProxyFetchState(url string) (state ProxyFetchState, found bool, err error)
+}
+
+// SecurityBackend 限流、登录锁定与 OIDC 授权状态等短期数据。
+type SecurityBackend interface {
+	// AllowRequest 滑动窗口限流，超限时返回建议的重试等待时间。
+	AllowRequest(key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error)
+	// IncrLoginFailures 失败次数加一并返回累计次数，计数在 ttl 后过期；key 由 loginFailureKey 生成。
+	IncrLoginFailures(key string, ttl time.Duration) (int64, error)
+	ClearLoginFailures(key string) error
+	// LockLogin 锁定 ttl 时长并清除失败计数。
+	LockLogin(key string, ttl time.Duration) error
+	// LoginLockTTL 返回剩余锁定时长，未锁定时为 0。
+	LoginLockTTL(key string) (time.Duration, error)
+
+	SaveOIDCState(state string, data OIDCLoginState, ttl time.Duration) error
+	// ConsumeOIDCState 读取并删除 state，不存在时返回 nil。
+	ConsumeOIDCState(state string) (*OIDCLoginState, error)
+}
+
+// InitStorage 按 STORAGE_BACKEND 初始化存储后端（redis / file / memory），并初始化默认数据。
+func InitStorage() error {
+	var (
+		b   Backend
+		err error
+	)
+	switch kind := config.GetStorageBackend(); kind {
+	case "redis":
+		b, err = NewRedisBackend(config.GetRedisAddr(), config.GetRedisPassword(), config.GetRedisDB())
+	case "file":
+		b, err = NewBoltBackend(config.GetStoragePath())
+	case "memory":
+		b = NewMemoryBackend()
+		log.Println("Using in-memory storage, all data will be lost on restart")
+	default:
+		return fmt.Errorf("unknown storage backend %q (expected redis, file or memory)", kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	UseBackend(b)
+	return nil
+}
+
+// UseBackend 切换存储后端并初始化默认管理员与默认模板。
+func UseBackend(b Backend) {
+	if backend != nil && backend != b {
+		if err := backend.Close(); err != nil {
+			log.Printf("Warning: failed to close %s storage: %v", backend.Name(), err)
+		}
+	}
+	backend = b
+
+	// Initialize default admin / migrate legacy admin
+	if err := InitDefaultAdmin(); err != nil {
+		log.Printf("Warning: failed to init default admin: %v", err)
+	}
+	if err := InitDefaultStashProfile(); err != nil {
+		log.Printf("Warning: failed to init default stash profile: %v", err)
+	}
+}
+
+// CloseStorage 关闭当前存储后端。
+func CloseStorage() error {
+	if backend == nil {
+		return nil
+	}
+	err := backend.Close()
+	backend = nil
+	return err
+}
+
+// GetStoredSubscribeUrls 获取订阅链接
+func GetStoredSubscribeUrls() ([]string, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+	return backend.GetSubscribeURLs()
+}
+
+// SaveSubscribeUrls 保存订阅链接
+func SaveSubscribeUrls(urls []string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	if err := backend.SaveSubscribeURLs(urls); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
@@ -0,0 +1,794 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"my-stash-rule/internal/model"
+)
+
+var (
+	boltMetaBucket          = []byte("meta")                   // subscribe_urls / proxy_cache_last_run / leak_alerts
+	boltAdminsBucket        = []byte("admins")                 // username -> adminRecord(json)
+	boltAPITokensBucket     = []byte("admin_api_tokens")       // id -> adminAPITokenRecord(json)
+	boltAPITokenHashBucket  = []byte("admin_api_token_hashes") // sha256(token) -> id
+	boltSessionsBucket      = []byte("sessions")               // token -> boltSession(json)
+	boltProfilesBucket      = []byte("stash_profiles")         // profileName -> yaml content
+	boltProfileHistBucket   = []byte("stash_profile_history")  // profileName -> []StashProfileRevision(json)，最新在前
+	boltSubscribersBucket   = []byte("subscribers")            // username -> boltSubscriber(json)
+	boltSubscriberTokBucket = []byte("subscriber_tokens")      // token -> username
+	boltFetchStatsBucket    = []byte("subscriber_fetch")       // username -> FetchStats(json)
+	boltProxyCacheBucket    = []byte("proxy_cache")            // url -> boltProxyCache(json)
+	boltProxyFetchBucket    = []byte("proxy_fetch_state")      // url -> ProxyFetchState(json)
+
+	boltSubscribeURLsKey = []byte("subscribe_urls")
+	boltLastRunKey       = []byte("proxy_cache_last_run")
+	boltLeakAlertsKey    = []byte("leak_alerts")
+)
+
+// boltBackend 基于 bbolt 的单文件嵌入式存储后端，适合不部署 Redis 的单实例场景。
+// 限流、登录锁定、拉取窗口等短期数据保存在进程内存中。
+type boltBackend struct {
+	db *bolt.DB
+	*volatileState
+}
+
+type boltSession struct {
+	Session
+	ExpiresAt int64 `json:"expires_at"` // unix ms
+}
+
+type boltSubscriber struct {
+	Token          string            `json:"token"`
+	ProfileName    string            `json:"profile_name"`
+	Disabled       bool              `json:"disabled"`
+	DisabledReason string            `json:"disabled_reason,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Vars           map[string]string `json:"vars,omitempty"`
+	Override       string            `json:"override,omitempty"`
+}
+
+type boltProxyCache struct {
+	Proxies   []model.ProxyNode `json:"proxies"`
+	UpdatedAt int64             `json:"updated_at"`
+}
+
+// NewBoltBackend 打开（不存在时创建）数据文件并返回存储后端。
+// 同一文件同时只能被一个进程打开。
+func NewBoltBackend(path string) (Backend, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create storage dir failed: %v", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open storage file %s failed: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			boltMetaBucket, boltAdminsBucket, boltAPITokensBucket, boltAPITokenHashBucket,
+			boltSessionsBucket, boltProfilesBucket, boltProfileHistBucket, boltSubscribersBucket, boltSubscriberTokBucket,
+			boltFetchStatsBucket, boltProxyCacheBucket, boltProxyFetchBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init storage file %s failed: %v", path, err)
+	}
+
+	log.Printf("Opened file storage at %s", path)
+	return &boltBackend{db: db, volatileState: newVolatileState()}, nil
+}
+
+func (b *boltBackend) Name() string {
+	return "file"
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+func boltGetJSON(bucket *bolt.Bucket, key []byte, out interface{}) (bool, error) {
+	raw := bucket.Get(key)
+	if raw == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, out)
+}
+
+func boltPutJSON(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, encoded)
+}
+
+// ---- 订阅链接与模板 ----
+
+func (b *boltBackend) GetSubscribeURLs() ([]string, error) {
+	urls := []string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		_, err := boltGetJSON(tx.Bucket(boltMetaBucket), boltSubscribeURLsKey, &urls)
+		return err
+	})
+	return urls, err
+}
+
+func (b *boltBackend) SaveSubscribeURLs(urls []string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return boltPutJSON(tx.Bucket(boltMetaBucket), boltSubscribeURLsKey, urls)
+	})
+}
+
+func (b *boltBackend) ListProfiles() (map[string]string, error) {
+	profiles := map[string]string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltProfilesBucket).ForEach(func(k, v []byte) error {
+			profiles[string(k)] = string(v)
+			return nil
+		})
+	})
+	return profiles, err
+}
+
+func (b *boltBackend) GetProfile(name string) (content string, found bool, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(boltProfilesBucket).Get([]byte(name)); raw != nil {
+			content, found = string(raw), true
+		}
+		return nil
+	})
+	return content, found, err
+}
+
+func (b *boltBackend) SaveProfile(name, content string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltProfilesBucket).Put([]byte(name), []byte(content))
+	})
+}
+
+// boundSubscribers 返回绑定到指定模板的订阅用户。
+func (b *boltBackend) boundSubscribers(tx *bolt.Tx, profileName string) ([]string, error) {
+	var usernames []string
+	err := tx.Bucket(boltSubscribersBucket).ForEach(func(k, v []byte) error {
+		var record boltSubscriber
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("invalid subscriber record for %s: %w", k, err)
+		}
+		if record.ProfileName == profileName {
+			usernames = append(usernames, string(k))
+		}
+		return nil
+	})
+	return usernames, err
+}
+
+// reassignSubscribers 在同一事务中将绑定 from 模板的订阅用户改绑到 to。
+func (b *boltBackend) reassignSubscribers(tx *bolt.Tx, from, to string) error {
+	users := tx.Bucket(boltSubscribersBucket)
+	updated := map[string]boltSubscriber{}
+	err := users.ForEach(func(k, v []byte) error {
+		var record boltSubscriber
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("invalid subscriber record for %s: %w", k, err)
+		}
+		if record.ProfileName == from {
+			record.ProfileName = to
+			updated[string(k)] = record
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// ForEach 期间不能修改 bucket，遍历完成后再写回。
+	for username, record := range updated {
+		if err := boltPutJSON(users, []byte(username), record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *boltBackend) DeleteProfile(name, reassignTo string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if reassignTo != "" {
+			if tx.Bucket(boltProfilesBucket).Get([]byte(reassignTo)) == nil {
+				return reassignTargetNotFound(reassignTo)
+			}
+			if err := b.reassignSubscribers(tx, name, reassignTo); err != nil {
+				return err
+			}
+		} else {
+			usernames, err := b.boundSubscribers(tx, name)
+			if err != nil {
+				return err
+			}
+			if err := profileInUseError(name, usernames); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(boltProfileHistBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltProfilesBucket).Delete([]byte(name))
+	})
+}
+
+func (b *boltBackend) RenameProfile(oldName, newName string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		profiles := tx.Bucket(boltProfilesBucket)
+		history := tx.Bucket(boltProfileHistBucket)
+		if profiles.Get([]byte(newName)) != nil {
+			return errProfileExists
+		}
+		content := profiles.Get([]byte(oldName))
+		if content == nil {
+			return ErrStashProfileNotFound
+		}
+		if err := profiles.Put([]byte(newName), append([]byte{}, content...)); err != nil {
+			return err
+		}
+		if err := profiles.Delete([]byte(oldName)); err != nil {
+			return err
+		}
+
+		if err := history.Delete([]byte(newName)); err != nil {
+			return err
+		}
+		if raw := history.Get([]byte(oldName)); raw != nil {
+			if err := history.Put([]byte(newName), append([]byte{}, raw...)); err != nil {
+				return err
+			}
+			if err := history.Delete([]byte(oldName)); err != nil {
+				return err
+			}
+		}
+		return b.reassignSubscribers(tx, oldName, newName)
+	})
+}
+
+func (b *boltBackend) loadProfileRevisions(tx *bolt.Tx, name string) []StashProfileRevision {
+	revisions := []StashProfileRevision{}
+	if _, err := boltGetJSON(tx.Bucket(boltProfileHistBucket), []byte(name), &revisions); err != nil {
+		return []StashProfileRevision{}
+	}
+	return revisions
+}
+
+func (b *boltBackend) AddProfileRevision(name string, revision StashProfileRevision, maxLen int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		previous := b.loadProfileRevisions(tx, name)
+		revision.Version = nextProfileRevisionVersion(previous)
+		revisions := append([]StashProfileRevision{revision}, previous...)
+		if len(revisions) > maxLen {
+			revisions = revisions[:maxLen]
+		}
+		return boltPutJSON(tx.Bucket(boltProfileHistBucket), []byte(name), revisions)
+	})
+}
+
+func (b *boltBackend) ListProfileRevisions(name string) ([]StashProfileRevision, error) {
+	var revisions []StashProfileRevision
+	err := b.db.View(func(tx *bolt.Tx) error {
+		revisions = b.loadProfileRevisions(tx, name)
+		return nil
+	})
+	return revisions, err
+}
+
+// ---- 管理员 ----
+
+func (b *boltBackend) CountAdmins() (int, error) {
+	count := 0
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltAdminsBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+func (b *boltBackend) GetAdmin(username string) (*adminRecord, error) {
+	var record *adminRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var r adminRecord
+		found, err := boltGetJSON(tx.Bucket(boltAdminsBucket), []byte(username), &r)
+		if err != nil {
+			return fmt.Errorf("invalid admin record for %s: %w", username, err)
+		}
+		if found {
+			record = &r
+		}
+		return nil
+	})
+	return record, err
+}
+
+func (b *boltBackend) ListAdmins() ([]adminRecord, error) {
+	var records []adminRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAdminsBucket).ForEach(func(k, v []byte) error {
+			var record adminRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				log.Printf("Warning: skip invalid admin record %s: %v", k, err)
+				return nil
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *boltBackend) CreateAdmin(record adminRecord) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAdminsBucket)
+		if bucket.Get([]byte(record.Username)) != nil {
+			return errAdminExists
+		}
+		return boltPutJSON(bucket, []byte(record.Username), record)
+	})
+}
+
+func (b *boltBackend) UpdateAdmin(username string, fn func(record *adminRecord) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAdminsBucket)
+		var record adminRecord
+		found, err := boltGetJSON(bucket, []byte(username), &record)
+		if err != nil {
+			return fmt.Errorf("invalid admin record for %s: %w", username, err)
+		}
+		if !found {
+			return ErrAdminNotFound
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+		return boltPutJSON(bucket, []byte(username), record)
+	})
+}
+
+func (b *boltBackend) RenameAdmin(oldUsername string, record adminRecord) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAdminsBucket)
+		if bucket.Get([]byte(record.Username)) != nil {
+			return errAdminExists
+		}
+		if err := bucket.Delete([]byte(oldUsername)); err != nil {
+			return err
+		}
+		return boltPutJSON(bucket, []byte(record.Username), record)
+	})
+}
+
+func (b *boltBackend) DeleteAdmin(username string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAdminsBucket).Delete([]byte(username))
+	})
+}
+
+func (b *boltBackend) GetAdminAPIToken(id string) (*adminAPITokenRecord, error) {
+	var record *adminAPITokenRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var r adminAPITokenRecord
+		found, err := boltGetJSON(tx.Bucket(boltAPITokensBucket), []byte(id), &r)
+		if found && err == nil {
+			record = &r
+		}
+		return err
+	})
+	return record, err
+}
+
+func (b *boltBackend) FindAdminAPIToken(hash string) (*adminAPITokenRecord, error) {
+	var id []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(boltAPITokenHashBucket).Get([]byte(hash)); raw != nil {
+			id = append([]byte(nil), raw...)
+		}
+		return nil
+	})
+	if err != nil || id == nil {
+		return nil, err
+	}
+	return b.GetAdminAPIToken(string(id))
+}
+
+func (b *boltBackend) ListAdminAPITokens() ([]adminAPITokenRecord, error) {
+	var records []adminAPITokenRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAPITokensBucket).ForEach(func(k, v []byte) error {
+			var record adminAPITokenRecord
+			if err := json.Unmarshal(v, &record); err == nil {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *boltBackend) SaveAdminAPIToken(record adminAPITokenRecord) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := boltPutJSON(tx.Bucket(boltAPITokensBucket), []byte(record.ID), record); err != nil {
+			return err
+		}
+		return tx.Bucket(boltAPITokenHashBucket).Put([]byte(record.TokenHash), []byte(record.ID))
+	})
+}
+
+func (b *boltBackend) DeleteAdminAPITokens(records ...adminAPITokenRecord) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, record := range records {
+			if err := tx.Bucket(boltAPITokensBucket).Delete([]byte(record.ID)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltAPITokenHashBucket).Delete([]byte(record.TokenHash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ---- 会话 ----
+
+func (b *boltBackend) SaveSession(token string, session Session, ttl time.Duration) error {
+	now := time.Now()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSessionsBucket)
+
+		// 顺带清理已过期的会话，避免数据文件无限增长。
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var s boltSession
+			if err := json.Unmarshal(v, &s); err != nil || s.ExpiresAt <= now.UnixMilli() {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return boltPutJSON(bucket, []byte(token), boltSession{
+			Session:   session,
+			ExpiresAt: now.Add(ttl).UnixMilli(),
+		})
+	})
+}
+
+func (b *boltBackend) GetSession(token string) (*Session, error) {
+	var session *Session
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var s boltSession
+		found, err := boltGetJSON(tx.Bucket(boltSessionsBucket), []byte(token), &s)
+		if err != nil || !found {
+			return err
+		}
+		if s.ExpiresAt > time.Now().UnixMilli() {
+			session = &s.Session
+		}
+		return nil
+	})
+	return session, err
+}
+
+func (b *boltBackend) ListSessionTokens(username string) ([]string, error) {
+	var tokens []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSessionsBucket).ForEach(func(k, v []byte) error {
+			var s boltSession
+			if err := json.Unmarshal(v, &s); err == nil && s.Username == username {
+				tokens = append(tokens, string(k))
+			}
+			return nil
+		})
+	})
+	return tokens, err
+}
+
+func (b *boltBackend) DeleteSessions(_ string, tokens ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSessionsBucket)
+		for _, token := range tokens {
+			if err := bucket.Delete([]byte(token)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ---- 订阅用户 ----
+
+func boltLoadSubscriber(tx *bolt.Tx, username string) (*Subscriber, error) {
+	var record boltSubscriber
+	found, err := boltGetJSON(tx.Bucket(boltSubscribersBucket), []byte(username), &record)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	var stats FetchStats
+	if _, err := boltGetJSON(tx.Bucket(boltFetchStatsBucket), []byte(username), &stats); err != nil {
+		stats = FetchStats{}
+	}
+	return &Subscriber{
+		Username:       username,
+		Token:          record.Token,
+		ProfileName:    record.ProfileName,
+		Disabled:       record.Disabled,
+		DisabledReason: record.DisabledReason,
+		Tags:           record.Tags,
+		Vars:           record.Vars,
+		Override:       record.Override,
+		FetchStats:     stats,
+	}, nil
+}
+
+func (b *boltBackend) updateSubscriber(username string, fn func(record *boltSubscriber)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSubscribersBucket)
+		var record boltSubscriber
+		found, err := boltGetJSON(bucket, []byte(username), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errSubscriberNotFound
+		}
+		fn(&record)
+		return boltPutJSON(bucket, []byte(username), record)
+	})
+}
+
+func (b *boltBackend) ListSubscribers() ([]Subscriber, error) {
+	var subscribers []Subscriber
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSubscribersBucket).ForEach(func(k, _ []byte) error {
+			subscriber, err := boltLoadSubscriber(tx, string(k))
+			if err != nil || subscriber == nil {
+				return err
+			}
+			subscribers = append(subscribers, *subscriber)
+			return nil
+		})
+	})
+	return subscribers, err
+}
+
+func (b *boltBackend) GetSubscriber(username string) (*Subscriber, error) {
+	var subscriber *Subscriber
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		subscriber, err = boltLoadSubscriber(tx, username)
+		return err
+	})
+	return subscriber, err
+}
+
+func (b *boltBackend) FindSubscriberByToken(token string) (*Subscriber, error) {
+	var subscriber *Subscriber
+	err := b.db.View(func(tx *bolt.Tx) error {
+		username := tx.Bucket(boltSubscriberTokBucket).Get([]byte(token))
+		if username == nil {
+			return nil
+		}
+		var err error
+		subscriber, err = boltLoadSubscriber(tx, string(username))
+		return err
+	})
+	return subscriber, err
+}
+
+func (b *boltBackend) CreateSubscriber(subscriber Subscriber) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltSubscribersBucket)
+		tokens := tx.Bucket(boltSubscriberTokBucket)
+		if users.Get([]byte(subscriber.Username)) != nil {
+			return errSubscriberExists
+		}
+		if tokens.Get([]byte(subscriber.Token)) != nil {
+			return errTokenExists
+		}
+
+		if err := boltPutJSON(users, []byte(subscriber.Username), boltSubscriber{
+			Token:       subscriber.Token,
+			ProfileName: subscriber.ProfileName,
+			Tags:        subscriber.Tags,
+			Vars:        subscriber.Vars,
+			Override:    subscriber.Override,
+		}); err != nil {
+			return err
+		}
+		return tokens.Put([]byte(subscriber.Token), []byte(subscriber.Username))
+	})
+}
+
+func (b *boltBackend) SetSubscriberProfile(username, profileName string) error {
+	return b.updateSubscriber(username, func(record *boltSubscriber) {
+		record.ProfileName = profileName
+	})
+}
+
+func (b *boltBackend) SetSubscriberAttributes(username string, tags []string, vars map[string]string) error {
+	return b.updateSubscriber(username, func(record *boltSubscriber) {
+		record.Tags = tags
+		record.Vars = vars
+	})
+}
+
+func (b *boltBackend) SetSubscriberOverride(username, content string) error {
+	return b.updateSubscriber(username, func(record *boltSubscriber) {
+		record.Override = content
+	})
+}
+
+func (b *boltBackend) SetSubscriberDisabled(username string, disabled bool, reason string) error {
+	return b.updateSubscriber(username, func(record *boltSubscriber) {
+		record.Disabled = disabled
+		record.DisabledReason = ""
+		if disabled {
+			record.DisabledReason = reason
+		}
+	})
+}
+
+func (b *boltBackend) DeleteSubscriber(username string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltSubscribersBucket)
+		var record boltSubscriber
+		found, err := boltGetJSON(users, []byte(username), &record)
+		if err != nil {
+			return err
+		}
+		if found && record.Token != "" {
+			if err := tx.Bucket(boltSubscriberTokBucket).Delete([]byte(record.Token)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(boltFetchStatsBucket).Delete([]byte(username)); err != nil {
+			return err
+		}
+		return users.Delete([]byte(username))
+	})
+	if err != nil {
+		return err
+	}
+	return b.ResetFetchTracking(username)
+}
+
+// ---- 拉取统计与告警 ----
+
+func (b *boltBackend) RecordFetch(username string, stats FetchStats, now time.Time, window time.Duration) (FetchUsage, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return boltPutJSON(tx.Bucket(boltFetchStatsBucket), []byte(username), stats)
+	})
+	if err != nil {
+		return FetchUsage{}, err
+	}
+	return b.recordFetchWindow(username, stats.LastIP, stats.LastClient, now, window), nil
+}
+
+func (b *boltBackend) GetFetchStats(username string) (FetchStats, error) {
+	var stats FetchStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if _, err := boltGetJSON(tx.Bucket(boltFetchStatsBucket), []byte(username), &stats); err != nil {
+			stats = FetchStats{}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func (b *boltBackend) loadLeakAlerts(tx *bolt.Tx) []LeakAlert {
+	alerts := []LeakAlert{}
+	if _, err := boltGetJSON(tx.Bucket(boltMetaBucket), boltLeakAlertsKey, &alerts); err != nil {
+		return []LeakAlert{}
+	}
+	return alerts
+}
+
+func (b *boltBackend) AddLeakAlert(alert LeakAlert, maxLen int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		alerts := append([]LeakAlert{alert}, b.loadLeakAlerts(tx)...)
+		if len(alerts) > maxLen {
+			alerts = alerts[:maxLen]
+		}
+		return boltPutJSON(tx.Bucket(boltMetaBucket), boltLeakAlertsKey, alerts)
+	})
+}
+
+func (b *boltBackend) ListLeakAlerts() ([]LeakAlert, error) {
+	var alerts []LeakAlert
+	err := b.db.View(func(tx *bolt.Tx) error {
+		alerts = b.loadLeakAlerts(tx)
+		return nil
+	})
+	return alerts, err
+}
+
+func (b *boltBackend) ClearLeakAlerts() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Delete(boltLeakAlertsKey)
+	})
+}
+
+// ---- 节点缓存 ----
+
+func (b *boltBackend) SaveProxyCache(url string, proxies []model.ProxyNode, updatedAt time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return boltPutJSON(tx.Bucket(boltProxyCacheBucket), []byte(url), boltProxyCache{
+			Proxies:   proxies,
+			UpdatedAt: updatedAt.Unix(),
+		})
+	})
+}
+
+func (b *boltBackend) GetProxyCache(url string) ([]model.ProxyNode, int64, bool, error) {
+	var cache boltProxyCache
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltGetJSON(tx.Bucket(boltProxyCacheBucket), []byte(url), &cache)
+		return err
+	})
+	if err != nil || !found {
+		return nil, 0, false, err
+	}
+	return cache.Proxies, cache.UpdatedAt, true, nil
+}
+
+func (b *boltBackend) SaveProxyFetchState(url string, state ProxyFetchState) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return boltPutJSON(tx.Bucket(boltProxyFetchBucket), []byte(url), state)
+	})
+}
+
+func (b *boltBackend) GetProxyFetchState(url string) (ProxyFetchState, bool, error) {
+	var state ProxyFetchState
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltGetJSON(tx.Bucket(boltProxyFetchBucket), []byte(url), &state)
+		return err
+	})
+	return state, found, err
+}
+
+func (b *boltBackend) SetProxyCacheLastRunAt(t time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Put(boltLastRunKey, []byte(strconv.FormatInt(t.Unix(), 10)))
+	})
+}
+
+func (b *boltBackend) GetProxyCacheLastRunAt() (int64, error) {
+	var ts int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(boltMetaBucket).Get(boltLastRunKey); raw != nil {
+			ts, _ = strconv.ParseInt(string(raw), 10, 64)
+		}
+		return nil
+	})
+	return ts, err
+}
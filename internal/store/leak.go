@@ -0,0 +1,176 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	leakAlertsMaxLen         = 200
+	clientFingerprintMaxSize = 200
+)
+
+// FetchStats 记录订阅用户最近一次拉取配置的信息。
+type FetchStats struct {
+	LastFetchAt int64  `json:"last_fetch_at"`
+	LastIP      string `json:"last_ip"`
+	LastClient  string `json:"last_client"`
+	Count       int64  `json:"count"`
+}
+
+// FetchUsage 表示窗口内订阅 token 的使用情况。
+type FetchUsage struct {
+	DistinctIPs     int64 `json:"distinct_ips"`
+	DistinctClients int64 `json:"distinct_clients"`
+}
+
+// LeakAlert 表示一次疑似 token 泄露告警。
+type LeakAlert struct {
+	Username        string   `json:"username"`
+	CreatedAt       int64    `json:"created_at"`
+	DistinctIPs     int64    `json:"distinct_ips"`
+	DistinctClients int64    `json:"distinct_clients"`
+	IPs             []string `json:"ips"`
+	Clients         []string `json:"clients"`
+	Reason          string   `json:"reason"`
+	AutoDisabled    bool     `json:"auto_disabled"`
+}
+
+func normalizeClientFingerprint(client string) string {
+	client = strings.TrimSpace(client)
+	if client == "" {
+		return "unknown"
+	}
+	if len(client) > clientFingerprintMaxSize {
+		client = client[:clientFingerprintMaxSize]
+	}
+	return client
+}
+
+// RecordSubscriberFetch 记录一次订阅拉取，并返回窗口内的不同 IP / 客户端数量。
+func RecordSubscriberFetch(username, ip, client string, now time.Time, window time.Duration) (FetchUsage, error) {
+	if backend == nil {
+		return FetchUsage{}, errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return FetchUsage{}, fmt.Errorf("username is required")
+	}
+
+	stats, err := backend.GetFetchStats(username)
+	if err != nil {
+		return FetchUsage{}, err
+	}
+	stats.LastFetchAt = now.Unix()
+	stats.LastIP = ip
+	stats.LastClient = normalizeClientFingerprint(client)
+	stats.Count++
+
+	return backend.RecordFetch(username, stats, now, window)
+}
+
+// ListSubscriberFetchSources 返回窗口内使用过该 token 的 IP 和客户端列表（按最近使用排序）。
+func ListSubscriberFetchSources(username string) (ips []string, clients []string, err error) {
+	if backend == nil {
+		return nil, nil, errStoreNotInitialized
+	}
+	return backend.ListFetchSources(username)
+}
+
+// GetSubscriberFetchStats 获取订阅用户最近一次拉取信息。
+func GetSubscriberFetchStats(username string) (FetchStats, error) {
+	if backend == nil {
+		return FetchStats{}, errStoreNotInitialized
+	}
+	return backend.GetFetchStats(username)
+}
+
+// MarkSubscriberFlagged 标记订阅用户在窗口内已告警。
+// 返回 true 表示本次为首次标记（应当产生告警）。
+func MarkSubscriberFlagged(username string, window time.Duration) (bool, error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+	return backend.MarkFetchFlagged(username, window)
+}
+
+// ResetSubscriberFetchTracking 清空订阅用户的拉取统计窗口与告警标记。
+func ResetSubscriberFetchTracking(username string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return backend.ResetFetchTracking(username)
+}
+
+// AddLeakAlert 写入一条泄露告警，仅保留最近 leakAlertsMaxLen 条。
+func AddLeakAlert(alert LeakAlert) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return backend.AddLeakAlert(alert, leakAlertsMaxLen)
+}
+
+// ListLeakAlerts 获取泄露告警列表（最新在前）。
+func ListLeakAlerts() ([]LeakAlert, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+	return backend.ListLeakAlerts()
+}
+
+// ClearLeakAlerts 清空泄露告警列表。
+func ClearLeakAlerts() error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return backend.ClearLeakAlerts()
+}
+
+// SetSubscriberDisabled 启用/禁用订阅用户 token。
+func SetSubscriberDisabled(username string, disabled bool, reason string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return err
+	}
+	if subscriber == nil {
+		return errSubscriberNotFound
+	}
+
+	if !disabled {
+		if err := backend.SetSubscriberDisabled(username, false, ""); err != nil {
+			return err
+		}
+		// 重新启用后从零开始统计，避免立即再次触发告警。
+		return ResetSubscriberFetchTracking(username)
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = "manual"
+	}
+	return backend.SetSubscriberDisabled(username, true, reason)
+}
+
+// IsSubscriberDisabled 检查订阅用户 token 是否被禁用。
+func IsSubscriberDisabled(username string) (bool, error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil || subscriber == nil {
+		return false, err
+	}
+	return subscriber.Disabled, nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordSubscriberFetchWindow(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		if _, err := AddSubscriber("alice", ""); err != nil {
+			t.Fatal(err)
+		}
+		// ListSubscriberFetchSources 按当前时间裁剪窗口，最后一次拉取需接近现在
+		start := time.Now().Add(-76 * time.Minute)
+		window := time.Hour
+		record := func(ip, client string, at time.Duration) FetchUsage {
+			t.Helper()
+			usage, err := RecordSubscriberFetch("alice", ip, client, start.Add(at), window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return usage
+		}
+
+		record("198.51.100.1", "Stash/2.0", 0)
+		record("198.51.100.1", "Stash/2.0", 10*time.Minute)
+		usage := record("198.51.100.2", "ClashX", 20*time.Minute)
+		if usage.DistinctIPs != 2 || usage.DistinctClients != 2 {
+			t.Errorf("usage within window = %+v", usage)
+		}
+
+		// 超出窗口的来源不再计入
+		usage = record("198.51.100.3", "ClashX", 75*time.Minute)
+		if usage.DistinctIPs != 2 || usage.DistinctClients != 1 {
+			t.Errorf("usage after window = %+v", usage)
+		}
+		ips, clients, err := ListSubscriberFetchSources("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 2 || ips[0] != "198.51.100.3" || len(clients) != 1 {
+			t.Errorf("sources = %v %v", ips, clients)
+		}
+
+		stats, err := GetSubscriberFetchStats("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Count != 4 || stats.LastIP != "198.51.100.3" || stats.LastFetchAt != start.Add(75*time.Minute).Unix() {
+			t.Errorf("stats = %+v", stats)
+		}
+	})
+}
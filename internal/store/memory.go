@@ -0,0 +1,576 @@
+package store
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"my-stash-rule/internal/model"
+)
+
+// memoryBackend 进程内存存储后端，重启后数据全部丢失，用于测试和本地试用。
+type memoryBackend struct {
+	mu sync.RWMutex
+
+	subscribeURLs []string
+	profiles      map[string]string
+	profileRevs   map[string][]StashProfileRevision
+
+	admins         map[string]adminRecord
+	apiTokens      map[string]adminAPITokenRecord // id -> record
+	apiTokenHashes map[string]string              // sha256(token) -> id
+	sessions       map[string]memorySession
+
+	subscribers      map[string]Subscriber // username -> subscriber（不含拉取统计）
+	subscriberTokens map[string]string     // token -> username
+	fetchStats       map[string]FetchStats
+	leakAlerts       []LeakAlert
+
+	proxyCaches    map[string]memoryProxyCache
+	proxyFetches   map[string]ProxyFetchState
+	proxyLastRunAt int64
+
+	*volatileState
+}
+
+type memorySession struct {
+	session   Session
+	expiresAt time.Time
+}
+
+type memoryProxyCache struct {
+	proxies   []byte // json，读取时解码出副本，避免调用方修改缓存
+	updatedAt int64
+}
+
+// NewMemoryBackend 创建一个空的内存存储后端。
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		subscribeURLs:    []string{},
+		profiles:         map[string]string{},
+		profileRevs:      map[string][]StashProfileRevision{},
+		admins:           map[string]adminRecord{},
+		apiTokens:        map[string]adminAPITokenRecord{},
+		apiTokenHashes:   map[string]string{},
+		sessions:         map[string]memorySession{},
+		subscribers:      map[string]Subscriber{},
+		subscriberTokens: map[string]string{},
+		fetchStats:       map[string]FetchStats{},
+		leakAlerts:       []LeakAlert{},
+		proxyCaches:      map[string]memoryProxyCache{},
+		proxyFetches:     map[string]ProxyFetchState{},
+		volatileState:    newVolatileState(),
+	}
+}
+
+func (m *memoryBackend) Name() string {
+	return "memory"
+}
+
+func (m *memoryBackend) Close() error {
+	return nil
+}
+
+func cloneAdminRecord(record adminRecord) adminRecord {
+	record.RecoveryCodes = append([]string(nil), record.RecoveryCodes...)
+	return record
+}
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// ---- 订阅链接与模板 ----
+
+func (m *memoryBackend) GetSubscribeURLs() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string{}, m.subscribeURLs...), nil
+}
+
+func (m *memoryBackend) SaveSubscribeURLs(urls []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribeURLs = append([]string{}, urls...)
+	return nil
+}
+
+func (m *memoryBackend) ListProfiles() (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	profiles := make(map[string]string, len(m.profiles))
+	for name, content := range m.profiles {
+		profiles[name] = content
+	}
+	return profiles, nil
+}
+
+func (m *memoryBackend) GetProfile(name string) (string, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	content, found := m.profiles[name]
+	return content, found, nil
+}
+
+func (m *memoryBackend) SaveProfile(name, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profiles[name] = content
+	return nil
+}
+
+// reassignSubscribersLocked 将绑定 from 模板的订阅用户改绑到 to，调用方需持有写锁。
+func (m *memoryBackend) reassignSubscribersLocked(from, to string) {
+	for username, subscriber := range m.subscribers {
+		if subscriber.ProfileName == from {
+			subscriber.ProfileName = to
+			m.subscribers[username] = subscriber
+		}
+	}
+}
+
+func (m *memoryBackend) DeleteProfile(name, reassignTo string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reassignTo != "" {
+		if _, found := m.profiles[reassignTo]; !found {
+			return reassignTargetNotFound(reassignTo)
+		}
+		m.reassignSubscribersLocked(name, reassignTo)
+	} else {
+		var usernames []string
+		for username, subscriber := range m.subscribers {
+			if subscriber.ProfileName == name {
+				usernames = append(usernames, username)
+			}
+		}
+		if err := profileInUseError(name, usernames); err != nil {
+			return err
+		}
+	}
+	delete(m.profiles, name)
+	delete(m.profileRevs, name)
+	return nil
+}
+
+func (m *memoryBackend) RenameProfile(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.profiles[newName]; exists {
+		return errProfileExists
+	}
+	content, found := m.profiles[oldName]
+	if !found {
+		return ErrStashProfileNotFound
+	}
+	m.profiles[newName] = content
+	delete(m.profiles, oldName)
+	delete(m.profileRevs, newName)
+	if revisions, ok := m.profileRevs[oldName]; ok {
+		m.profileRevs[newName] = revisions
+		delete(m.profileRevs, oldName)
+	}
+	m.reassignSubscribersLocked(oldName, newName)
+	return nil
+}
+
+func (m *memoryBackend) AddProfileRevision(name string, revision StashProfileRevision, maxLen int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revision.Version = nextProfileRevisionVersion(m.profileRevs[name])
+	revisions := append([]StashProfileRevision{revision}, m.profileRevs[name]...)
+	if len(revisions) > maxLen {
+		revisions = revisions[:maxLen]
+	}
+	m.profileRevs[name] = revisions
+	return nil
+}
+
+func (m *memoryBackend) ListProfileRevisions(name string) ([]StashProfileRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]StashProfileRevision{}, m.profileRevs[name]...), nil
+}
+
+// ---- 管理员 ----
+
+func (m *memoryBackend) CountAdmins() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.admins), nil
+}
+
+func (m *memoryBackend) GetAdmin(username string) (*adminRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, found := m.admins[username]
+	if !found {
+		return nil, nil
+	}
+	record = cloneAdminRecord(record)
+	return &record, nil
+}
+
+func (m *memoryBackend) ListAdmins() ([]adminRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records := make([]adminRecord, 0, len(m.admins))
+	for _, record := range m.admins {
+		records = append(records, cloneAdminRecord(record))
+	}
+	return records, nil
+}
+
+func (m *memoryBackend) CreateAdmin(record adminRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.admins[record.Username]; exists {
+		return errAdminExists
+	}
+	m.admins[record.Username] = cloneAdminRecord(record)
+	return nil
+}
+
+func (m *memoryBackend) UpdateAdmin(username string, fn func(record *adminRecord) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, found := m.admins[username]
+	if !found {
+		return ErrAdminNotFound
+	}
+	record = cloneAdminRecord(record)
+	if err := fn(&record); err != nil {
+		return err
+	}
+	m.admins[username] = record
+	return nil
+}
+
+func (m *memoryBackend) RenameAdmin(oldUsername string, record adminRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.admins[record.Username]; exists {
+		return errAdminExists
+	}
+	delete(m.admins, oldUsername)
+	m.admins[record.Username] = cloneAdminRecord(record)
+	return nil
+}
+
+func (m *memoryBackend) DeleteAdmin(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.admins, username)
+	return nil
+}
+
+func (m *memoryBackend) GetAdminAPIToken(id string) (*adminAPITokenRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, found := m.apiTokens[id]
+	if !found {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (m *memoryBackend) FindAdminAPIToken(hash string) (*adminAPITokenRecord, error) {
+	m.mu.RLock()
+	id, found := m.apiTokenHashes[hash]
+	m.mu.RUnlock()
+	if !found {
+		return nil, nil
+	}
+	return m.GetAdminAPIToken(id)
+}
+
+func (m *memoryBackend) ListAdminAPITokens() ([]adminAPITokenRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records := make([]adminAPITokenRecord, 0, len(m.apiTokens))
+	for _, record := range m.apiTokens {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (m *memoryBackend) SaveAdminAPIToken(record adminAPITokenRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiTokens[record.ID] = record
+	m.apiTokenHashes[record.TokenHash] = record.ID
+	return nil
+}
+
+func (m *memoryBackend) DeleteAdminAPITokens(records ...adminAPITokenRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, record := range records {
+		delete(m.apiTokens, record.ID)
+		delete(m.apiTokenHashes, record.TokenHash)
+	}
+	return nil
+}
+
+// ---- 会话 ----
+
+func (m *memoryBackend) SaveSession(token string, session Session, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for key, s := range m.sessions {
+		if !now.Before(s.expiresAt) {
+			delete(m.sessions, key)
+		}
+	}
+	m.sessions[token] = memorySession{session: session, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (m *memoryBackend) GetSession(token string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, found := m.sessions[token]
+	if !found || !time.Now().Before(s.expiresAt) {
+		return nil, nil
+	}
+	session := s.session
+	return &session, nil
+}
+
+func (m *memoryBackend) ListSessionTokens(username string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var tokens []string
+	for token, s := range m.sessions {
+		if s.session.Username == username {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *memoryBackend) DeleteSessions(_ string, tokens ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, token := range tokens {
+		delete(m.sessions, token)
+	}
+	return nil
+}
+
+// ---- 订阅用户 ----
+
+// subscriberLocked 返回带拉取统计的订阅用户副本，调用方需持有锁。
+func (m *memoryBackend) subscriberLocked(username string) *Subscriber {
+	subscriber, found := m.subscribers[username]
+	if !found {
+		return nil
+	}
+	subscriber.Tags = append([]string(nil), subscriber.Tags...)
+	subscriber.Vars = cloneStringMap(subscriber.Vars)
+	subscriber.FetchStats = m.fetchStats[username]
+	return &subscriber
+}
+
+func (m *memoryBackend) ListSubscribers() ([]Subscriber, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	subscribers := make([]Subscriber, 0, len(m.subscribers))
+	for username := range m.subscribers {
+		subscribers = append(subscribers, *m.subscriberLocked(username))
+	}
+	return subscribers, nil
+}
+
+func (m *memoryBackend) GetSubscriber(username string) (*Subscriber, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.subscriberLocked(username), nil
+}
+
+func (m *memoryBackend) FindSubscriberByToken(token string) (*Subscriber, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	username, found := m.subscriberTokens[token]
+	if !found {
+		return nil, nil
+	}
+	return m.subscriberLocked(username), nil
+}
+
+func (m *memoryBackend) CreateSubscriber(subscriber Subscriber) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.subscribers[subscriber.Username]; exists {
+		return errSubscriberExists
+	}
+	if _, exists := m.subscriberTokens[subscriber.Token]; exists {
+		return errTokenExists
+	}
+	m.subscribers[subscriber.Username] = Subscriber{
+		Username:    subscriber.Username,
+		Token:       subscriber.Token,
+		ProfileName: subscriber.ProfileName,
+		Tags:        append([]string(nil), subscriber.Tags...),
+		Vars:        cloneStringMap(subscriber.Vars),
+		Override:    subscriber.Override,
+	}
+	m.subscriberTokens[subscriber.Token] = subscriber.Username
+	return nil
+}
+
+func (m *memoryBackend) updateSubscriber(username string, fn func(subscriber *Subscriber)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subscriber, found := m.subscribers[username]
+	if !found {
+		return errSubscriberNotFound
+	}
+	fn(&subscriber)
+	m.subscribers[username] = subscriber
+	return nil
+}
+
+func (m *memoryBackend) SetSubscriberProfile(username, profileName string) error {
+	return m.updateSubscriber(username, func(subscriber *Subscriber) {
+		subscriber.ProfileName = profileName
+	})
+}
+
+func (m *memoryBackend) SetSubscriberAttributes(username string, tags []string, vars map[string]string) error {
+	return m.updateSubscriber(username, func(subscriber *Subscriber) {
+		subscriber.Tags = append([]string(nil), tags...)
+		subscriber.Vars = cloneStringMap(vars)
+	})
+}
+
+func (m *memoryBackend) SetSubscriberOverride(username, content string) error {
+	return m.updateSubscriber(username, func(subscriber *Subscriber) {
+		subscriber.Override = content
+	})
+}
+
+func (m *memoryBackend) SetSubscriberDisabled(username string, disabled bool, reason string) error {
+	return m.updateSubscriber(username, func(subscriber *Subscriber) {
+		subscriber.Disabled = disabled
+		subscriber.DisabledReason = ""
+		if disabled {
+			subscriber.DisabledReason = reason
+		}
+	})
+}
+
+func (m *memoryBackend) DeleteSubscriber(username string) error {
+	m.mu.Lock()
+	if subscriber, found := m.subscribers[username]; found {
+		delete(m.subscriberTokens, subscriber.Token)
+	}
+	delete(m.subscribers, username)
+	delete(m.fetchStats, username)
+	m.mu.Unlock()
+
+	return m.ResetFetchTracking(username)
+}
+
+// ---- 拉取统计与告警 ----
+
+func (m *memoryBackend) RecordFetch(username string, stats FetchStats, now time.Time, window time.Duration) (FetchUsage, error) {
+	m.mu.Lock()
+	m.fetchStats[username] = stats
+	m.mu.Unlock()
+
+	return m.recordFetchWindow(username, stats.LastIP, stats.LastClient, now, window), nil
+}
+
+func (m *memoryBackend) GetFetchStats(username string) (FetchStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fetchStats[username], nil
+}
+
+func (m *memoryBackend) AddLeakAlert(alert LeakAlert, maxLen int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leakAlerts = append([]LeakAlert{alert}, m.leakAlerts...)
+	if len(m.leakAlerts) > maxLen {
+		m.leakAlerts = m.leakAlerts[:maxLen]
+	}
+	return nil
+}
+
+func (m *memoryBackend) ListLeakAlerts() ([]LeakAlert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]LeakAlert{}, m.leakAlerts...), nil
+}
+
+func (m *memoryBackend) ClearLeakAlerts() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leakAlerts = []LeakAlert{}
+	return nil
+}
+
+// ---- 节点缓存 ----
+
+func (m *memoryBackend) SaveProxyCache(url string, proxies []model.ProxyNode, updatedAt time.Time) error {
+	encoded, err := json.Marshal(proxies)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxyCaches[url] = memoryProxyCache{proxies: encoded, updatedAt: updatedAt.Unix()}
+	return nil
+}
+
+func (m *memoryBackend) GetProxyCache(url string) ([]model.ProxyNode, int64, bool, error) {
+	m.mu.RLock()
+	cache, found := m.proxyCaches[url]
+	m.mu.RUnlock()
+	if !found {
+		return nil, 0, false, nil
+	}
+
+	var proxies []model.ProxyNode
+	if err := json.Unmarshal(cache.proxies, &proxies); err != nil {
+		return nil, 0, false, err
+	}
+	return proxies, cache.updatedAt, true, nil
+}
+
+func (m *memoryBackend) SaveProxyFetchState(url string, state ProxyFetchState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxyFetches[url] = state
+	return nil
+}
+
+func (m *memoryBackend) GetProxyFetchState(url string) (ProxyFetchState, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state, found := m.proxyFetches[url]
+	return state, found, nil
+}
+
+func (m *memoryBackend) SetProxyCacheLastRunAt(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxyLastRunAt = t.Unix()
+	return nil
+}
+
+func (m *memoryBackend) GetProxyCacheLastRunAt() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.proxyLastRunAt, nil
+}
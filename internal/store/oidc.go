@@ -0,0 +1,28 @@
+package store
+
+import (
+	"time"
+)
+
+// OIDCLoginState 保存一次 OIDC 授权请求的校验信息。
+type OIDCLoginState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// SaveOIDCState 保存授权请求的 state，ttl 后自动失效。
+func SaveOIDCState(state string, data OIDCLoginState, ttl time.Duration) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return backend.SaveOIDCState(state, data, ttl)
+}
+
+// ConsumeOIDCState 读取并删除 state（一次性），不存在时返回 nil。
+func ConsumeOIDCState(state string) (*OIDCLoginState, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+	return backend.ConsumeOIDCState(state)
+}
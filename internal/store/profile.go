@@ -0,0 +1,441 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultStashProfileName 默认配置模板名。
+	DefaultStashProfileName = "default"
+	defaultStashProfileYAML = "{}\n"
+
+	// stashProfileRevisionsMaxLen 每个模板保留的历史版本数。
+	stashProfileRevisionsMaxLen = 30
+)
+
+var (
+	ErrStashProfileNotFound         = errors.New("stash profile not found")
+	ErrStashProfileRevisionNotFound = errors.New("stash profile revision not found")
+	// ErrStashProfileConflict 并发修改导致事务多次重试仍未成功。
+	ErrStashProfileConflict = errors.New("stash profile was modified concurrently, please retry")
+)
+
+// StashProfile 表示一个可编辑的 Stash 配置模板（YAML 内容）。
+type StashProfile struct {
+	Name      string `json:"name"`
+	Content   string `json:"content"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// StashProfileRevision 模板的一个历史版本。
+type StashProfileRevision struct {
+	Version   int    `json:"version"`
+	Content   string `json:"content"`
+	Author    string `json:"author"`
+	CreatedAt int64  `json:"created_at"`
+	Note      string `json:"note,omitempty"`
+}
+
+func normalizeProfileName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return DefaultStashProfileName
+	}
+	return name
+}
+
+// DefaultStashProfileNameIfEmpty 返回非空模板名，空则返回默认模板名。
+func DefaultStashProfileNameIfEmpty(name string) string {
+	return normalizeProfileName(name)
+}
+
+func normalizeProfileContent(content string) string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return defaultStashProfileYAML
+	}
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content
+}
+
+// ParseStashProfileContent 解析配置模板 YAML，并确保根节点为 map。
+// 含模板语法的内容使用示例数据渲染，用于保存时校验。
+func ParseStashProfileContent(content string) (map[string]interface{}, error) {
+	return RenderStashProfileMap(content, SampleProfileTemplateData())
+}
+
+// RenderStashProfileMap 使用 data 渲染模板后解析为 map。
+func RenderStashProfileMap(content string, data ProfileTemplateData) (map[string]interface{}, error) {
+	content, err := RenderStashProfileContent(normalizeProfileContent(content), data)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid yaml: %w", err)
+	}
+	if parsed == nil {
+		parsed = map[string]interface{}{}
+	}
+	return parsed, nil
+}
+
+// InitDefaultStashProfile 初始化默认配置模板。
+func InitDefaultStashProfile() error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	_, found, err := backend.GetProfile(DefaultStashProfileName)
+	if err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+	if err := backend.SaveProfile(DefaultStashProfileName, defaultStashProfileYAML); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// ListStashProfiles 获取全部模板列表。
+func ListStashProfiles() ([]StashProfile, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	if err := InitDefaultStashProfile(); err != nil {
+		return nil, err
+	}
+
+	m, err := backend.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]StashProfile, 0, len(m))
+	for name, content := range m {
+		profiles = append(profiles, StashProfile{
+			Name:      name,
+			Content:   content,
+			IsDefault: name == DefaultStashProfileName,
+		})
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].IsDefault != profiles[j].IsDefault {
+			return profiles[i].IsDefault
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles, nil
+}
+
+// ValidateStashProfileExists 检查模板是否存在。
+func ValidateStashProfileExists(name string) (bool, error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+
+	_, found, err := backend.GetProfile(normalizeProfileName(name))
+	return found, err
+}
+
+// GetStashProfileYAML 获取模板原始 YAML。
+func GetStashProfileYAML(name string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	content, found, err := backend.GetProfile(normalizeProfileName(name))
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", ErrStashProfileNotFound
+	}
+	return content, nil
+}
+
+// GetStashProfileMap 获取模板并解析为 map。
+func GetStashProfileMap(name string) (map[string]interface{}, error) {
+	content, err := GetStashProfileYAML(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStashProfileContent(content)
+}
+
+// GetStashProfileMapFor 获取模板并使用 data 渲染后解析为 map。
+func GetStashProfileMapFor(name string, data ProfileTemplateData) (map[string]interface{}, error) {
+	content, err := GetStashProfileYAML(name)
+	if err != nil {
+		return nil, err
+	}
+	return RenderStashProfileMap(content, data)
+}
+
+// CreateStashProfile 创建模板（不存在时），author 记录到历史版本。
+func CreateStashProfile(name, content, author string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	name = normalizeProfileName(name)
+	if name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if _, err := ParseStashProfileContent(content); err != nil {
+		return err
+	}
+
+	exists, err := ValidateStashProfileExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("stash profile already exists")
+	}
+
+	content = normalizeProfileContent(content)
+	if err := validateStashProfileExtends(name, content); err != nil {
+		return err
+	}
+	if err := backend.SaveProfile(name, content); err != nil {
+		return err
+	}
+	markDataChanged()
+	return addStashProfileRevision(name, content, author, "")
+}
+
+// UpdateStashProfile 更新模板（存在时），内容有变化时记录一个历史版本。
+func UpdateStashProfile(name, content, author string) error {
+	return updateStashProfile(name, content, author, "")
+}
+
+func updateStashProfile(name, content, author, note string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	name = normalizeProfileName(name)
+	if name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if _, err := ParseStashProfileContent(content); err != nil {
+		return err
+	}
+
+	previous, found, err := backend.GetProfile(name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrStashProfileNotFound
+	}
+
+	content = normalizeProfileContent(content)
+	if err := validateStashProfileExtends(name, content); err != nil {
+		return err
+	}
+	if err := backend.SaveProfile(name, content); err != nil {
+		return err
+	}
+	markDataChanged()
+	if content == previous {
+		return nil
+	}
+
+	// 启用历史记录前创建的模板没有历史，先把修改前的内容记为基线版本。
+	revisions, err := backend.ListProfileRevisions(name)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		if err := addStashProfileRevision(name, previous, "", "baseline"); err != nil {
+			return err
+		}
+	}
+	return addStashProfileRevision(name, content, author, note)
+}
+
+// addStashProfileRevision 记录一个历史版本，版本号由后端分配。
+func addStashProfileRevision(name, content, author, note string) error {
+	return backend.AddProfileRevision(name, StashProfileRevision{
+		Content:   content,
+		Author:    author,
+		CreatedAt: time.Now().Unix(),
+		Note:      note,
+	}, stashProfileRevisionsMaxLen)
+}
+
+// nextProfileRevisionVersion 返回下一个版本号（revisions 最新在前），供各后端在事务内使用。
+func nextProfileRevisionVersion(revisions []StashProfileRevision) int {
+	if len(revisions) == 0 {
+		return 1
+	}
+	return revisions[0].Version + 1
+}
+
+// ListStashProfileRevisions 获取模板历史版本（最新在前）。
+func ListStashProfileRevisions(name string) ([]StashProfileRevision, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	name = normalizeProfileName(name)
+	exists, err := ValidateStashProfileExists(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrStashProfileNotFound
+	}
+	return backend.ListProfileRevisions(name)
+}
+
+// GetStashProfileRevision 获取模板的指定历史版本。
+func GetStashProfileRevision(name string, version int) (StashProfileRevision, error) {
+	revisions, err := ListStashProfileRevisions(name)
+	if err != nil {
+		return StashProfileRevision{}, err
+	}
+	for _, revision := range revisions {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+	return StashProfileRevision{}, ErrStashProfileRevisionNotFound
+}
+
+// RollbackStashProfile 将模板恢复为指定历史版本的内容，回滚本身记录为一个新版本。
+func RollbackStashProfile(name string, version int, author string) error {
+	revision, err := GetStashProfileRevision(name, version)
+	if err != nil {
+		return err
+	}
+	return updateStashProfile(name, revision.Content, author, fmt.Sprintf("rollback to v%d", version))
+}
+
+// StashProfileInUseError 模板仍被订阅用户使用。
+type StashProfileInUseError struct {
+	Name        string
+	Subscribers []string
+}
+
+func (e *StashProfileInUseError) Error() string {
+	return fmt.Sprintf("stash profile %s is in use by subscriber %s", e.Name, strings.Join(e.Subscribers, ", "))
+}
+
+// DeleteStashProfile 删除模板及其历史版本。默认模板不允许删除；
+// 仍被订阅用户使用时，reassignTo 为空返回 *StashProfileInUseError，否则在同一事务中将这些用户改绑到 reassignTo。
+func DeleteStashProfile(name, reassignTo string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	name = normalizeProfileName(name)
+	if name == DefaultStashProfileName {
+		return fmt.Errorf("cannot delete default stash profile")
+	}
+
+	exists, err := ValidateStashProfileExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrStashProfileNotFound
+	}
+	if err := ensureStashProfileNotExtended(name); err != nil {
+		return err
+	}
+
+	reassignTo = strings.TrimSpace(reassignTo)
+	if reassignTo != "" {
+		if reassignTo == name {
+			return fmt.Errorf("cannot reassign subscribers to the profile being deleted")
+		}
+		// 改绑目标是否存在由后端在删除的同一事务中检查。
+		if err := backend.DeleteProfile(name, reassignTo); err != nil {
+			return err
+		}
+		markDataChanged()
+		return nil
+	}
+	// 是否仍被使用由后端在删除的同一事务中检查，避免检查后新绑定的订阅用户指向已删除的模板。
+	if err := backend.DeleteProfile(name, ""); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// reassignTargetNotFound 改绑目标模板不存在时的错误，供各后端在事务内使用。
+func reassignTargetNotFound(reassignTo string) error {
+	return fmt.Errorf("reassign target %s: %w", reassignTo, ErrStashProfileNotFound)
+}
+
+// profileInUseError 绑定了订阅用户时返回 *StashProfileInUseError，供各后端在事务内使用。
+func profileInUseError(name string, usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	sort.Strings(usernames)
+	return &StashProfileInUseError{Name: name, Subscribers: usernames}
+}
+
+// ensureStashProfileNotExtended 模板被其他模板继承时不允许删除或重命名。
+func ensureStashProfileNotExtended(name string) error {
+	children, err := stashProfileChildren(name)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return fmt.Errorf("stash profile %s is extended by %s", name, strings.Join(children, ", "))
+	}
+	return nil
+}
+
+// RenameStashProfile 重命名模板，历史版本与绑定的订阅用户一并迁移。默认模板不允许重命名。
+func RenameStashProfile(oldName, newName string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	oldName = normalizeProfileName(oldName)
+	newName = strings.TrimSpace(newName)
+	if oldName == DefaultStashProfileName {
+		return fmt.Errorf("cannot rename default stash profile")
+	}
+	if newName == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if newName == oldName {
+		return nil
+	}
+	if err := ensureStashProfileNotExtended(oldName); err != nil {
+		return err
+	}
+
+	err := backend.RenameProfile(oldName, newName)
+	if errors.Is(err, errProfileExists) {
+		return fmt.Errorf("stash profile already exists")
+	}
+	if err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
@@ -0,0 +1,305 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileExtendsKey 模板中声明继承关系的顶层字段，如 `extends: [base-cn, gaming]`。
+const ProfileExtendsKey = "extends"
+
+// ProfileExtends 解析模板声明的父模板（字符串或字符串列表）。
+// declared 为 false 表示未声明 extends。
+func ProfileExtends(parsed map[string]interface{}) (parents []string, declared bool, err error) {
+	raw, declared := parsed[ProfileExtendsKey]
+	if !declared || raw == nil {
+		return nil, declared, nil
+	}
+
+	var items []interface{}
+	switch v := raw.(type) {
+	case string:
+		items = []interface{}{v}
+	case []interface{}:
+		items = v
+	default:
+		return nil, true, fmt.Errorf("%s must be a profile name or a list of profile names", ProfileExtendsKey)
+	}
+
+	seen := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		name, ok := item.(string)
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, true, fmt.Errorf("%s must be a profile name or a list of profile names", ProfileExtendsKey)
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		parents = append(parents, name)
+	}
+	return parents, true, nil
+}
+
+// profileExtendsBlock 从未渲染的模板文本中截取顶层 extends 字段（连同其后缩进的列表项），
+// count 为顶层 extends 出现的次数。
+func profileExtendsBlock(content string) (block string, count int) {
+	var lines []string
+	capturing := false
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, ProfileExtendsKey+":") {
+			count++
+			capturing = true
+			lines = append(lines, line)
+			continue
+		}
+		if capturing {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") ||
+				strings.HasPrefix(line, "-") || strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+				continue
+			}
+			capturing = false
+		}
+	}
+	return strings.Join(lines, "\n"), count
+}
+
+// staticProfileExtends 不渲染模板，直接从原始文本读取 extends，
+// 使继承关系不依赖示例数据，某个模板渲染失败也不影响其他模板解析继承链。
+func staticProfileExtends(content string) (parents []string, declared bool, err error) {
+	block, count := profileExtendsBlock(normalizeProfileContent(content))
+	if count == 0 {
+		return nil, false, nil
+	}
+	if count > 1 {
+		return nil, true, fmt.Errorf("%s must be declared only once", ProfileExtendsKey)
+	}
+	if strings.Contains(block, "{{") {
+		return nil, true, fmt.Errorf("%s cannot use template expressions", ProfileExtendsKey)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(block), &parsed); err != nil {
+		return nil, true, fmt.Errorf("invalid %s: %w", ProfileExtendsKey, err)
+	}
+	return ProfileExtends(parsed)
+}
+
+// profileParents 返回模板的父模板：未声明 extends 的非默认模板隐式继承 default，
+// 与引入继承前 “default + 用户模板” 的合并方式保持一致。
+func profileParents(name, content string) ([]string, error) {
+	parents, declared, err := staticProfileExtends(content)
+	if err != nil {
+		return nil, err
+	}
+	if !declared && name != DefaultStashProfileName {
+		return []string{DefaultStashProfileName}, nil
+	}
+	return parents, nil
+}
+
+// profileGraph 模板名 -> 父模板列表。
+type profileGraph map[string][]string
+
+func buildProfileGraph(profiles map[string]string) (profileGraph, error) {
+	graph := make(profileGraph, len(profiles))
+	for name, content := range profiles {
+		parents, err := profileParents(name, content)
+		if err != nil {
+			return nil, fmt.Errorf("stash profile %s: %w", name, err)
+		}
+		graph[name] = parents
+	}
+	return graph, nil
+}
+
+// loadProfileGraph 从 name 出发按需读取模板，只包含其继承链涉及的模板；drafts 优先于已保存内容。
+// 不存在的模板不放入图中，由 chain 报告缺失。
+func loadProfileGraph(name string, drafts map[string]string) (profileGraph, error) {
+	graph := profileGraph{}
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if _, loaded := graph[current]; loaded {
+			continue
+		}
+
+		content, found := drafts[current]
+		if !found {
+			var err error
+			content, found, err = backend.GetProfile(current)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !found {
+			continue
+		}
+
+		parents, err := profileParents(current, content)
+		if err != nil {
+			return nil, fmt.Errorf("stash profile %s: %w", current, err)
+		}
+		graph[current] = parents
+		queue = append(queue, parents...)
+	}
+	return graph, nil
+}
+
+// chain 按合并顺序（先父后子，同级按声明顺序）返回 name 的继承链，检测循环与缺失的父模板。
+func (g profileGraph) chain(name string) ([]string, error) {
+	var (
+		order    []string
+		done     = map[string]bool{}
+		visiting []string
+	)
+
+	var visit func(current string) error
+	visit = func(current string) error {
+		for i, v := range visiting {
+			if v == current {
+				cycle := append(append([]string{}, visiting[i:]...), current)
+				return fmt.Errorf("extends cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if done[current] {
+			return nil
+		}
+		parents, ok := g[current]
+		if !ok {
+			if len(visiting) == 0 {
+				return ErrStashProfileNotFound
+			}
+			return fmt.Errorf("stash profile %s extends unknown profile %s", visiting[len(visiting)-1], current)
+		}
+
+		visiting = append(visiting, current)
+		for _, parent := range parents {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		visiting = visiting[:len(visiting)-1]
+
+		done[current] = true
+		order = append(order, current)
+		return nil
+	}
+
+	if err := visit(name); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// SortStashProfilesByExtends 校验一组模板的继承关系，并按 “父模板在前” 的顺序返回模板名。
+func SortStashProfilesByExtends(profiles map[string]string) ([]string, error) {
+	graph, err := buildProfileGraph(profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	order := make([]string, 0, len(names))
+	added := make(map[string]bool, len(names))
+	for _, name := range names {
+		chain, err := graph.chain(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range chain {
+			if !added[item] {
+				added[item] = true
+				order = append(order, item)
+			}
+		}
+	}
+	return order, nil
+}
+
+// ResolveStashProfileChain 返回模板的继承链（先父后子，最后是模板本身）。
+func ResolveStashProfileChain(name string) ([]string, error) {
+	return ResolveStashProfileChainWithDrafts(name, nil)
+}
+
+// ResolveStashProfileChainWithDrafts 同 ResolveStashProfileChain，drafts（模板名 -> 未保存内容）优先于已保存内容，
+// 可包含尚未创建的模板。
+func ResolveStashProfileChainWithDrafts(name string, drafts map[string]string) ([]string, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	normalized := make(map[string]string, len(drafts))
+	for draftName, content := range drafts {
+		normalized[normalizeProfileName(draftName)] = content
+	}
+	name = normalizeProfileName(name)
+	graph, err := loadProfileGraph(name, normalized)
+	if err != nil {
+		return nil, err
+	}
+	return graph.chain(name)
+}
+
+// validateStashProfileExtends 校验保存 name 为 content 后它的继承链。
+// extends 必须是静态的顶层字段：按示例数据渲染后的值也要与原文一致，不能由模板条件生成。
+func validateStashProfileExtends(name, content string) error {
+	parents, declared, err := staticProfileExtends(content)
+	if err != nil {
+		return err
+	}
+	rendered, err := ParseStashProfileContent(content)
+	if err != nil {
+		return err
+	}
+	renderedParents, renderedDeclared, err := ProfileExtends(rendered)
+	if err != nil {
+		return err
+	}
+	if declared != renderedDeclared || strings.Join(parents, "\n") != strings.Join(renderedParents, "\n") {
+		return fmt.Errorf("%s must be a plain top-level field, not generated by template expressions", ProfileExtendsKey)
+	}
+
+	graph, err := loadProfileGraph(name, map[string]string{name: content})
+	if err != nil {
+		return err
+	}
+	_, err = graph.chain(name)
+	return err
+}
+
+// stashProfileChildren 返回直接继承 name 的其他模板。
+func stashProfileChildren(name string) ([]string, error) {
+	profiles, err := backend.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+	graph, err := buildProfileGraph(profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var children []string
+	for child, parents := range graph {
+		for _, parent := range parents {
+			if parent == name && child != name {
+				children = append(children, child)
+				break
+			}
+		}
+	}
+	sort.Strings(children)
+	return children, nil
+}
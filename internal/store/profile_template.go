@@ -0,0 +1,227 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+const (
+	// maxRenderedProfileSize 模板渲染结果的大小上限，防止循环生成超大配置。
+	maxRenderedProfileSize = 1 << 20
+	// maxProfileTemplateIterations 按实际数据估算的 range 循环总次数上限（嵌套循环按乘积计算）。
+	maxProfileTemplateIterations = 100000
+)
+
+// profileTemplateTimeout 单次模板渲染的时间上限。
+var profileTemplateTimeout = 2 * time.Second
+
+// ProfileTemplateData 模板渲染时可访问的数据，如 {{ .Username }}、{{ .Vars.dns }}、{{ .NodeCount }}。
+type ProfileTemplateData struct {
+	Username string
+	Tags     []string
+	Vars     map[string]string
+	// NodeCount 当前缓存中的节点总数。
+	NodeCount int
+	// Regions 有节点的地区代码（如 HK、JP），RegionNodes 为各地区节点数。
+	Regions     []string
+	RegionNodes map[string]int
+}
+
+// HasTag 订阅用户是否带有指定标签。
+func (d ProfileTemplateData) HasTag(tag string) bool {
+	for _, item := range d.Tags {
+		if item == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRegion 是否有指定地区的节点。
+func (d ProfileTemplateData) HasRegion(region string) bool {
+	return d.RegionNodes[region] > 0
+}
+
+// SampleProfileTemplateData 保存模板时用于校验的示例数据（无标签、无变量、无节点）。
+func SampleProfileTemplateData() ProfileTemplateData {
+	return ProfileTemplateData{
+		Username:    "sample",
+		Vars:        map[string]string{},
+		RegionNodes: map[string]int{},
+	}
+}
+
+// profileTemplateFuncs 模板可用的函数：仅做字符串处理，不提供文件、环境变量等访问能力。
+var profileTemplateFuncs = template.FuncMap{
+	"default": func(def, value interface{}) interface{} {
+		if value == nil {
+			return def
+		}
+		if s, ok := value.(string); ok && s == "" {
+			return def
+		}
+		return value
+	},
+	// quote 输出 YAML 可用的双引号字符串。
+	"quote": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(fmt.Sprint(value))
+		return string(encoded), err
+	},
+	"join":      func(sep string, items []string) string { return strings.Join(items, sep) },
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// IsStashProfileTemplate 内容中包含 {{ 的模板需要先渲染再解析。
+func IsStashProfileTemplate(content string) bool {
+	return strings.Contains(content, "{{")
+}
+
+// RenderStashProfileContent 使用 data 渲染模板；不含模板语法的内容原样返回。
+func RenderStashProfileContent(content string, data ProfileTemplateData) (string, error) {
+	if !IsStashProfileTemplate(content) {
+		return content, nil
+	}
+
+	tmpl, err := template.New("profile").
+		Option("missingkey=zero").
+		Funcs(profileTemplateFuncs).
+		Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	if err := checkProfileTemplateTree(tmpl, data); err != nil {
+		return "", err
+	}
+
+	// 循环次数已在执行前按数据限制，超时只作为兜底：输出写入时检查截止时间，超时后写入失败，
+	// Execute 随即中止返回，不会留下仍在渲染的 goroutine。
+	out := &limitedBuffer{limit: maxRenderedProfileSize, deadline: time.Now().Add(profileTemplateTimeout)}
+	if err := tmpl.Execute(out, data); err != nil {
+		if errors.Is(err, errProfileRenderTimeout) {
+			return "", fmt.Errorf("render template: timed out after %s", profileTemplateTimeout)
+		}
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// profileTemplateRangeFields 允许 range 的数据字段。
+var profileTemplateRangeFields = map[string]bool{"Tags": true, "Regions": true, "Vars": true, "RegionNodes": true}
+
+// checkProfileTemplateTree 限制模板的循环规模：
+//   - range 只能作用于数据字段（如 {{ range .Tags }}、{{ range $.Regions }}），
+//     不能作用于数字、变量或函数结果（如 {{ $n := 100000000 }}{{ range $n }}）；
+//   - 不允许 {{ template }} / {{ block }} 调用，避免递归展开；
+//   - 按 data 中集合的长度估算循环总次数（嵌套按乘积），超过上限时拒绝。
+func checkProfileTemplateTree(tmpl *template.Template, data ProfileTemplateData) error {
+	// 字段所在的上下文不一定是根数据，按最长的集合估算。
+	longest := len(data.Tags)
+	for _, n := range []int{len(data.Regions), len(data.Vars), len(data.RegionNodes)} {
+		if n > longest {
+			longest = n
+		}
+	}
+
+	iterations := 0
+	var walk func(node parse.Node, multiplier int) error
+	walk = func(node parse.Node, multiplier int) error {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return nil
+			}
+			for _, child := range n.Nodes {
+				if err := walk(child, multiplier); err != nil {
+					return err
+				}
+			}
+		case *parse.RangeNode:
+			if !isProfileTemplateRangeField(n.Pipe) {
+				return fmt.Errorf("invalid template: range is only allowed over .Tags, .Regions, .Vars or .RegionNodes")
+			}
+			// 空集合也按 1 次计，保证嵌套层数本身受限。
+			inner := multiplier * max(longest, 1)
+			if inner > maxProfileTemplateIterations {
+				return fmt.Errorf("invalid template: too many loop iterations (max %d)", maxProfileTemplateIterations)
+			}
+			if iterations += inner; iterations > maxProfileTemplateIterations {
+				return fmt.Errorf("invalid template: too many loop iterations (max %d)", maxProfileTemplateIterations)
+			}
+			if err := walk(n.List, inner); err != nil {
+				return err
+			}
+			return walk(n.ElseList, multiplier)
+		case *parse.IfNode:
+			if err := walk(n.List, multiplier); err != nil {
+				return err
+			}
+			return walk(n.ElseList, multiplier)
+		case *parse.WithNode:
+			if err := walk(n.List, multiplier); err != nil {
+				return err
+			}
+			return walk(n.ElseList, multiplier)
+		case *parse.TemplateNode:
+			return fmt.Errorf("invalid template: template invocation is not allowed")
+		}
+		return nil
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := walk(t.Tree.Root, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isProfileTemplateRangeField pipe 是否为单个数据字段，如 .Tags 或 $.Regions（可带变量声明）。
+func isProfileTemplateRangeField(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return len(arg.Ident) == 1 && profileTemplateRangeFields[arg.Ident[0]]
+	case *parse.VariableNode:
+		return len(arg.Ident) == 2 && arg.Ident[0] == "$" && profileTemplateRangeFields[arg.Ident[1]]
+	}
+	return false
+}
+
+var (
+	errRenderedProfileTooLarge = errors.New("rendered profile is too large")
+	errProfileRenderTimeout    = errors.New("render timed out")
+)
+
+// limitedBuffer 超过 limit 或 deadline 后写入失败，从而中止模板执行。
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	deadline time.Time
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errRenderedProfileTooLarge
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return 0, errProfileRenderTimeout
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderStashProfileContent(t *testing.T) {
+	data := ProfileTemplateData{
+		Username:    "alice",
+		Tags:        []string{"vip"},
+		Vars:        map[string]string{"port": "7891"},
+		NodeCount:   3,
+		Regions:     []string{"HK", "JP"},
+		RegionNodes: map[string]int{"HK": 2, "JP": 1},
+	}
+	content := `mixed-port: {{ .Vars.port | default "7890" }}
+dns-server: {{ .Vars.dns | default "1.1.1.1" | quote }}
+{{- if .HasTag "vip" }}
+mode: global
+{{- end }}
+comment: {{ printf "%s has %d nodes in %s" .Username .NodeCount (join "," .Regions) | quote }}
+jp: {{ .HasRegion "JP" }}
+`
+	parsed, err := RenderStashProfileMap(content, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed["mixed-port"] != 7891 || parsed["dns-server"] != "1.1.1.1" || parsed["mode"] != "global" || parsed["jp"] != true {
+		t.Errorf("unexpected rendered profile: %v", parsed)
+	}
+	if parsed["comment"] != "alice has 3 nodes in HK,JP" {
+		t.Errorf("comment = %v", parsed["comment"])
+	}
+
+	// 示例数据：无标签、无变量
+	parsed, err = ParseStashProfileContent(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed["mixed-port"] != 7890 || parsed["mode"] != nil {
+		t.Errorf("unexpected sample rendering: %v", parsed)
+	}
+
+	// 不含模板语法的内容原样解析
+	if parsed, err := ParseStashProfileContent("mode: rule"); err != nil || parsed["mode"] != "rule" {
+		t.Errorf("plain profile = %v, %v", parsed, err)
+	}
+}
+
+func TestRenderStashProfileContentErrors(t *testing.T) {
+	cases := map[string]string{
+		"{{ if .Username }}mode: rule":    "invalid template",
+		"mode: {{ .Missing }}":            "render template",
+		"mode: {{ env \"HOME\" }}":        "function \"env\" not defined",
+		"{{ range 100000000 }}x{{ end }}": "range is only allowed",
+		// 先赋值给变量再 range 同样会被拒绝。
+		"{{ $n := 300000000 }}{{ range $n }}{{ end }}":                            "range is only allowed",
+		"{{ range $i, $r := .Regions }}{{ $r }}{{ end }}":                         "",
+		"{{ range (split .Vars.list) }}{{ end }}":                                 "function \"split\" not defined",
+		"{{ define \"x\" }}{{ template \"x\" . }}{{ end }}{{ template \"x\" . }}": "template invocation",
+		"{{ range .Regions }}{{ end }}mode: [":                                    "invalid yaml",
+		"{{ range .Tags }}{{ .Username }}{{ end }}":                               "",
+	}
+	for content, want := range cases {
+		_, err := ParseStashProfileContent(content)
+		if want == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", content, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error = %v, want %q", content, err, want)
+		}
+	}
+
+	content := "{{ range .Tags }}{{ range $.Tags }}comment: padding-padding\n{{ end }}{{ end }}"
+	big := ProfileTemplateData{Tags: make([]string, 300)}
+	if _, err := RenderStashProfileContent(content, big); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+	// 嵌套循环按数据长度的乘积估算，超过上限时不执行。
+	huge := ProfileTemplateData{Tags: make([]string, 2000)}
+	if _, err := RenderStashProfileContent(content, huge); err == nil || !strings.Contains(err.Error(), "too many loop iterations") {
+		t.Errorf("expected iteration limit error, got %v", err)
+	}
+
+	// 超过截止时间后输出写入失败，Execute 同步中止，不依赖后台 goroutine。
+	timeout := profileTemplateTimeout
+	profileTemplateTimeout = -time.Second
+	defer func() { profileTemplateTimeout = timeout }()
+	if _, err := RenderStashProfileContent("{{ range .Tags }}x{{ end }}", big); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestUpdateSubscriberAttributes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		if err := InitDefaultStashProfile(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := AddSubscriber("alice", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := UpdateSubscriberAttributes("alice", []string{" vip ", "cn", "vip", ""}, map[string]string{"port": "7891"}); err != nil {
+			t.Fatal(err)
+		}
+		subscriber, err := GetSubscriber("alice")
+		if err != nil || subscriber == nil {
+			t.Fatalf("get subscriber: %v", err)
+		}
+		if strings.Join(subscriber.Tags, ",") != "cn,vip" || subscriber.Vars["port"] != "7891" {
+			t.Errorf("unexpected attributes: %v %v", subscriber.Tags, subscriber.Vars)
+		}
+
+		if err := UpdateSubscriberAttributes("alice", nil, map[string]string{"bad-name": "x"}); err == nil {
+			t.Error("expected invalid variable name error")
+		}
+		if err := UpdateSubscriberAttributes("nobody", nil, nil); err == nil {
+			t.Error("expected subscriber not found error")
+		}
+
+		if err := UpdateSubscriberAttributes("alice", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		subscriber, _ = GetSubscriber("alice")
+		if len(subscriber.Tags) != 0 || len(subscriber.Vars) != 0 {
+			t.Errorf("attributes should be cleared: %v %v", subscriber.Tags, subscriber.Vars)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// forEachBackend 分别在内存与文件后端上运行测试。
+func forEachBackend(t *testing.T, fn func(t *testing.T)) {
+	t.Run("memory", func(t *testing.T) {
+		UseBackend(NewMemoryBackend())
+		t.Cleanup(func() { _ = CloseStorage() })
+		fn(t)
+	})
+	t.Run("file", func(t *testing.T) {
+		b, err := NewBoltBackend(filepath.Join(t.TempDir(), "stash-rule.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		UseBackend(b)
+		t.Cleanup(func() { _ = CloseStorage() })
+		fn(t)
+	})
+}
+
+func TestRenameAndDeleteStashProfile(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		if err := CreateStashProfile("mobile", "mode: global", "admin"); err != nil {
+			t.Fatal(err)
+		}
+		if err := UpdateStashProfile("mobile", "mode: rule", "admin"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := AddSubscriber("alice", "mobile"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := AddSubscriber("bob", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := RenameStashProfile("mobile", "phone"); err != nil {
+			t.Fatal(err)
+		}
+		if profile, _ := GetSubscriberProfile("alice"); profile != "phone" {
+			t.Errorf("alice profile = %q", profile)
+		}
+		if profile, _ := GetSubscriberProfile("bob"); profile != DefaultStashProfileName {
+			t.Errorf("bob profile = %q", profile)
+		}
+		revisions, err := ListStashProfileRevisions("phone")
+		if err != nil || len(revisions) != 2 || revisions[0].Version != 2 {
+			t.Errorf("revisions after rename = %+v, %v", revisions, err)
+		}
+
+		var inUse *StashProfileInUseError
+		if err := DeleteStashProfile("phone", ""); !errors.As(err, &inUse) || inUse.Subscribers[0] != "alice" {
+			t.Fatalf("expected in-use error, got %v", err)
+		}
+		// 后端在删除的同一事务中检查绑定关系，不依赖调用方事先检查。
+		if err := backend.DeleteProfile("phone", ""); !errors.As(err, &inUse) {
+			t.Fatalf("backend delete: expected in-use error, got %v", err)
+		}
+		// 改绑目标同样在事务内检查，目标不存在时不删除、不改绑。
+		if err := backend.DeleteProfile("phone", "missing"); !errors.Is(err, ErrStashProfileNotFound) {
+			t.Fatalf("backend delete with missing target: got %v", err)
+		}
+		if profile, _ := GetSubscriberProfile("alice"); profile != "phone" {
+			t.Errorf("alice profile after failed reassign = %q", profile)
+		}
+		if exists, _ := ValidateStashProfileExists("phone"); !exists {
+			t.Fatal("in-use profile was deleted")
+		}
+		if err := DeleteStashProfile("phone", DefaultStashProfileName); err != nil {
+			t.Fatal(err)
+		}
+		if profile, _ := GetSubscriberProfile("alice"); profile != DefaultStashProfileName {
+			t.Errorf("alice profile after delete = %q", profile)
+		}
+
+		// 重新创建同名模板时历史从头开始。
+		if err := CreateStashProfile("phone", "mode: direct", "admin"); err != nil {
+			t.Fatal(err)
+		}
+		if revisions, _ := ListStashProfileRevisions("phone"); len(revisions) != 1 || revisions[0].Version != 1 {
+			t.Errorf("history should be cleared with the profile: %+v", revisions)
+		}
+	})
+}
+
+func TestStashProfileExtends(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		profiles := []struct{ name, content string }{
+			{"base-cn", "dns:\n  enable: true"},
+			{"gaming", "extends: base-cn\nmode: global"},
+			{"plain", "mode: direct"},
+			{"bare", "extends: []\nlog-level: debug"},
+			{"combo", "extends: [gaming, base-cn, plain]"},
+		}
+		for _, p := range profiles {
+			if err := CreateStashProfile(p.name, p.content, "admin"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cases := map[string][]string{
+			"plain": {"default", "plain"},
+			"bare":  {"bare"},
+			// base-cn 只出现一次，位置由最先引用它的 gaming 决定。
+			"combo": {"default", "base-cn", "gaming", "plain", "combo"},
+		}
+		for name, want := range cases {
+			chain, err := ResolveStashProfileChain(name)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if strings.Join(chain, ",") != strings.Join(want, ",") {
+				t.Errorf("%s chain = %v, want %v", name, chain, want)
+			}
+		}
+
+		if err := UpdateStashProfile("base-cn", "extends: combo", "admin"); err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Errorf("expected cycle error, got %v", err)
+		}
+		if err := CreateStashProfile("broken", "extends: missing", "admin"); err == nil || !strings.Contains(err.Error(), "unknown profile missing") {
+			t.Errorf("expected unknown profile error, got %v", err)
+		}
+		if err := CreateStashProfile("broken", "extends: {a: b}", "admin"); err == nil {
+			t.Error("expected invalid extends error")
+		}
+		if err := DeleteStashProfile("gaming", ""); err == nil || !strings.Contains(err.Error(), "extended by combo") {
+			t.Errorf("expected extended-by error, got %v", err)
+		}
+		if err := RenameStashProfile("base-cn", "cn"); err == nil {
+			t.Error("renaming an extended profile should fail")
+		}
+		if _, err := ResolveStashProfileChain("missing"); !errors.Is(err, ErrStashProfileNotFound) {
+			t.Errorf("missing profile err = %v", err)
+		}
+	})
+}
+
+func TestStashProfileExtendsIsStaticAndLazy(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		if err := CreateStashProfile("base-cn", "dns:\n  enable: true", "admin"); err != nil {
+			t.Fatal(err)
+		}
+		// 块列表写法同样按原文读取。
+		if err := CreateStashProfile("gaming", "extends:\n  - base-cn\nmode: {{ default \"global\" .Vars.mode }}", "admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		// 某个模板按示例数据渲染失败，不影响其他模板解析继承链和保存。
+		if err := backend.SaveProfile("broken", "mode: {{ .NoSuchField }}\n"); err != nil {
+			t.Fatal(err)
+		}
+		chain, err := ResolveStashProfileChain("gaming")
+		if err != nil || strings.Join(chain, ",") != "default,base-cn,gaming" {
+			t.Fatalf("gaming chain = %v, %v", chain, err)
+		}
+		if err := CreateStashProfile("phone", "extends: gaming", "admin"); err != nil {
+			t.Fatalf("create with an unrelated broken profile: %v", err)
+		}
+
+		// extends 不能由模板生成。
+		for _, content := range []string{
+			"extends: {{ .Vars.parent }}",
+			"{{ if true }}extends: base-cn{{ end }}\nmode: rule",
+			"extends: base-cn\nextends: gaming",
+		} {
+			if err := CreateStashProfile("templated", content, "admin"); err == nil {
+				t.Errorf("templated extends %q should be rejected", content)
+			}
+		}
+	})
+}
+
+func TestProfileRevisionVersionsAreUniqueUnderConcurrency(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		if err := CreateStashProfile("busy", "mode: rule", "admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		const writers = 20
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := addStashProfileRevision("busy", "mode: rule", "admin", ""); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		revisions, err := ListStashProfileRevisions("busy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[int]bool{}
+		for _, revision := range revisions {
+			if seen[revision.Version] {
+				t.Fatalf("duplicate version %d", revision.Version)
+			}
+			seen[revision.Version] = true
+		}
+		if revisions[0].Version != writers+1 {
+			t.Errorf("latest version = %d, want %d", revisions[0].Version, writers+1)
+		}
+	})
+}
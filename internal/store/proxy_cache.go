@@ -0,0 +1,171 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"my-stash-rule/internal/model"
+)
+
+// ProxyCacheStatus 表示单个订阅链接的缓存状态。
+type ProxyCacheStatus struct {
+	URL       string `json:"url"`
+	Count     int    `json:"count"`
+	UpdatedAt int64  `json:"updated_at"`
+	// CheckedAt 最近一次成功拉取（含上游返回 304 未变化）的时间。
+	CheckedAt           int64  `json:"checked_at,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+	LastErrorAt         int64  `json:"last_error_at,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	// NextRetryAt 刷新失败后计划再次尝试的时间，0 表示无需重试。
+	NextRetryAt int64 `json:"next_retry_at,omitempty"`
+}
+
+// ProxyFetchState 单个订阅链接的拉取状态：上游返回的 ETag / Last-Modified 用于下次发送条件请求，
+// 失败时记录最近一次错误与连续失败次数（成功后次数清零，错误保留供排查）。
+type ProxyFetchState struct {
+	ETag                string `json:"etag,omitempty"`
+	LastModified        string `json:"last_modified,omitempty"`
+	CheckedAt           int64  `json:"checked_at,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+	LastErrorAt         int64  `json:"last_error_at,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	NextRetryAt         int64  `json:"next_retry_at,omitempty"`
+}
+
+func normalizeCacheURL(u string) string {
+	return strings.TrimSpace(u)
+}
+
+// SaveProxyCache 保存单个订阅链接的节点缓存。
+func SaveProxyCache(url string, proxies []model.ProxyNode, updatedAt time.Time) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	url = normalizeCacheURL(url)
+	if url == "" {
+		return fmt.Errorf("url is required")
+	}
+	if err := backend.SaveProxyCache(url, proxies, updatedAt); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// GetProxyCache 读取单个订阅链接缓存。
+// found=false 表示无缓存。
+func GetProxyCache(url string) (proxies []model.ProxyNode, updatedAt int64, found bool, err error) {
+	if backend == nil {
+		return nil, 0, false, errStoreNotInitialized
+	}
+
+	url = normalizeCacheURL(url)
+	if url == "" {
+		return nil, 0, false, fmt.Errorf("url is required")
+	}
+	return backend.GetProxyCache(url)
+}
+
+// GetProxyCachesByURLs 批量读取缓存，并返回缺失链接列表。
+func GetProxyCachesByURLs(urls []string) (map[string][]model.ProxyNode, []string, error) {
+	cache := make(map[string][]model.ProxyNode, len(urls))
+	missing := make([]string, 0)
+
+	for _, u := range urls {
+		url := normalizeCacheURL(u)
+		if url == "" {
+			continue
+		}
+
+		proxies, _, found, err := GetProxyCache(url)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !found {
+			missing = append(missing, url)
+			continue
+		}
+		cache[url] = proxies
+	}
+
+	return cache, missing, nil
+}
+
+// SaveProxyFetchState 保存单个订阅链接的拉取状态。
+func SaveProxyFetchState(url string, state ProxyFetchState) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	url = normalizeCacheURL(url)
+	if url == "" {
+		return fmt.Errorf("url is required")
+	}
+	return backend.SaveProxyFetchState(url, state)
+}
+
+// GetProxyFetchState 读取单个订阅链接的拉取状态，从未拉取过时返回零值。
+func GetProxyFetchState(url string) (ProxyFetchState, error) {
+	if backend == nil {
+		return ProxyFetchState{}, errStoreNotInitialized
+	}
+
+	url = normalizeCacheURL(url)
+	if url == "" {
+		return ProxyFetchState{}, fmt.Errorf("url is required")
+	}
+	state, _, err := backend.GetProxyFetchState(url)
+	return state, err
+}
+
+// ListProxyCacheStatus 返回给定链接的缓存状态。
+func ListProxyCacheStatus(urls []string) ([]ProxyCacheStatus, error) {
+	statuses := make([]ProxyCacheStatus, 0, len(urls))
+	for _, u := range urls {
+		url := normalizeCacheURL(u)
+		if url == "" {
+			continue
+		}
+		proxies, updatedAt, found, err := GetProxyCache(url)
+		if err != nil {
+			return nil, err
+		}
+		state, err := GetProxyFetchState(url)
+		if err != nil {
+			return nil, err
+		}
+		status := ProxyCacheStatus{
+			URL:                 url,
+			CheckedAt:           state.CheckedAt,
+			LastError:           state.LastError,
+			LastErrorAt:         state.LastErrorAt,
+			ConsecutiveFailures: state.ConsecutiveFailures,
+			NextRetryAt:         state.NextRetryAt,
+		}
+		if found {
+			status.Count = len(proxies)
+			status.UpdatedAt = updatedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// SetProxyCacheLastRunAt 设置最近一次全量刷新时间。
+func SetProxyCacheLastRunAt(t time.Time) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return backend.SetProxyCacheLastRunAt(t)
+}
+
+// GetProxyCacheLastRunAt 获取最近一次全量刷新时间（unix）。
+func GetProxyCacheLastRunAt() (int64, error) {
+	if backend == nil {
+		return 0, errStoreNotInitialized
+	}
+	return backend.GetProxyCacheLastRunAt()
+}
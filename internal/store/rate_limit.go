@@ -0,0 +1,59 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// AllowRequest 基于滑动窗口判断请求是否允许通过。
+// 返回 allowed=false 时 retryAfter 为建议的重试等待时间。
+func AllowRequest(route, key string, limit int, window time.Duration, now time.Time) (allowed bool, retryAfter time.Duration, err error) {
+	if backend == nil {
+		return false, 0, errStoreNotInitialized
+	}
+	return backend.AllowRequest(route+":"+key, limit, window, now)
+}
+
+// loginFailureKey 登录失败按 用户名 + 客户端 IP 计数和锁定，
+// 避免他人从别处反复输错密码把账号在所有来源上锁死。
+func loginFailureKey(username, ip string) string {
+	return strings.ToLower(strings.TrimSpace(username)) + "|" + ip
+}
+
+// GetLoginLockout 返回账号在该 IP 上剩余的锁定时长，0 表示未锁定。
+func GetLoginLockout(username, ip string) (time.Duration, error) {
+	if backend == nil {
+		return 0, errStoreNotInitialized
+	}
+	return backend.LoginLockTTL(loginFailureKey(username, ip))
+}
+
+// RecordLoginFailure 记录一次来自 ip 的登录失败；连续失败达到 maxFailures 时锁定该账号在该 IP 上的登录 lockout 时长。
+// 返回 locked=true 表示本次失败触发了锁定。
+func RecordLoginFailure(username, ip string, maxFailures int, lockout time.Duration) (locked bool, err error) {
+	if backend == nil {
+		return false, errStoreNotInitialized
+	}
+
+	key := loginFailureKey(username, ip)
+	failures, err := backend.IncrLoginFailures(key, lockout)
+	if err != nil {
+		return false, err
+	}
+	if maxFailures <= 0 || failures < int64(maxFailures) {
+		return false, nil
+	}
+
+	if err := backend.LockLogin(key, lockout); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ClearLoginFailures 登录成功后清除该 IP 上的失败计数。
+func ClearLoginFailures(username, ip string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+	return backend.ClearLoginFailures(loginFailureKey(username, ip))
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func decodeAdminRecord(username, raw string) (*adminRecord, error) {
+	var record adminRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, fmt.Errorf("invalid admin record for %s: %w", username, err)
+	}
+	return &record, nil
+}
+
+func (r *redisBackend) CountAdmins() (int, error) {
+	count, err := r.rdb.HLen(ctx, redisAdminsKey).Result()
+	return int(count), err
+}
+
+func (r *redisBackend) GetAdmin(username string) (*adminRecord, error) {
+	raw, err := r.rdb.HGet(ctx, redisAdminsKey, username).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeAdminRecord(username, raw)
+}
+
+func (r *redisBackend) ListAdmins() ([]adminRecord, error) {
+	m, err := r.rdb.HGetAll(ctx, redisAdminsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]adminRecord, 0, len(m))
+	for username, raw := range m {
+		record, err := decodeAdminRecord(username, raw)
+		if err != nil {
+			log.Printf("Warning: skip invalid admin record %s: %v", username, err)
+			continue
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (r *redisBackend) CreateAdmin(record adminRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	created, err := r.rdb.HSetNX(ctx, redisAdminsKey, record.Username, string(encoded)).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return errAdminExists
+	}
+	return nil
+}
+
+// UpdateAdmin 以乐观锁方式读取-修改-写回管理员记录。
+func (r *redisBackend) UpdateAdmin(username string, fn func(record *adminRecord) error) error {
+	return r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		raw, err := tx.HGet(ctx, redisAdminsKey, username).Result()
+		if err == redis.Nil {
+			return ErrAdminNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		record, err := decodeAdminRecord(username, raw)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisAdminsKey, username, string(encoded))
+			return nil
+		})
+		return err
+	}, redisAdminsKey)
+}
+
+func (r *redisBackend) RenameAdmin(oldUsername string, record adminRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, redisAdminsKey, record.Username).Result()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errAdminExists
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, redisAdminsKey, oldUsername)
+			pipe.HSet(ctx, redisAdminsKey, record.Username, string(encoded))
+			return nil
+		})
+		return err
+	}, redisAdminsKey)
+}
+
+func (r *redisBackend) DeleteAdmin(username string) error {
+	return r.rdb.HDel(ctx, redisAdminsKey, username).Err()
+}
+
+// migrateLegacyAdmin 将旧版单管理员 hash（stash-rule:admin）迁移为 owner。
+func (r *redisBackend) migrateLegacyAdmin() (bool, error) {
+	legacy, err := r.rdb.HGetAll(ctx, redisLegacyAdminKey).Result()
+	if err != nil {
+		return false, err
+	}
+	if legacy["username"] == "" || legacy["password_hash"] == "" {
+		return false, nil
+	}
+
+	record := adminRecord{
+		Username:     legacy["username"],
+		PasswordHash: legacy["password_hash"],
+		Role:         AdminRoleOwner,
+		CreatedAt:    time.Now().Unix(),
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, redisAdminsKey, record.Username, string(encoded))
+	pipe.Del(ctx, redisLegacyAdminKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	log.Printf("Migrated legacy admin account %s as owner", record.Username)
+	return true, nil
+}
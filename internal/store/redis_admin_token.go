@@ -0,0 +1,79 @@
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisAdminAPITokensKey    = "stash-rule:admin_api_tokens"       // id -> adminAPITokenRecord(json)
+	redisAdminAPITokenHashKey = "stash-rule:admin_api_token_hashes" // sha256(token) -> id
+)
+
+func (r *redisBackend) GetAdminAPIToken(id string) (*adminAPITokenRecord, error) {
+	raw, err := r.rdb.HGet(ctx, redisAdminAPITokensKey, id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record adminAPITokenRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *redisBackend) FindAdminAPIToken(hash string) (*adminAPITokenRecord, error) {
+	id, err := r.rdb.HGet(ctx, redisAdminAPITokenHashKey, hash).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetAdminAPIToken(id)
+}
+
+func (r *redisBackend) ListAdminAPITokens() ([]adminAPITokenRecord, error) {
+	values, err := r.rdb.HGetAll(ctx, redisAdminAPITokensKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]adminAPITokenRecord, 0, len(values))
+	for _, raw := range values {
+		var record adminAPITokenRecord
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (r *redisBackend) SaveAdminAPIToken(record adminAPITokenRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, redisAdminAPITokensKey, record.ID, string(encoded))
+	pipe.HSet(ctx, redisAdminAPITokenHashKey, record.TokenHash, record.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisBackend) DeleteAdminAPITokens(records ...adminAPITokenRecord) error {
+	pipe := r.rdb.TxPipeline()
+	for _, record := range records {
+		pipe.HDel(ctx, redisAdminAPITokensKey, record.ID)
+		pipe.HDel(ctx, redisAdminAPITokenHashKey, record.TokenHash)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
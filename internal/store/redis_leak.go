@@ -0,0 +1,124 @@
+package store
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisFetchIPPrefix      = "stash-rule:fetch_ips:"          // username -> zset(ip, last seen unix)
+	redisFetchClientPrefix  = "stash-rule:fetch_clients:"      // username -> zset(client fingerprint, last seen unix)
+	redisFetchFlaggedPrefix = "stash-rule:fetch_flagged:"      // username -> 1 (TTL = window，避免重复告警)
+	redisFetchStatsKey      = "stash-rule:subscriber_fetch"    // username -> FetchStats(json)
+	redisSubscriberDisabled = "stash-rule:subscriber_disabled" // username -> reason
+	redisLeakAlertsKey      = "stash-rule:leak_alerts"         // list of LeakAlert(json)，最新在前
+)
+
+func (r *redisBackend) RecordFetch(username string, stats FetchStats, now time.Time, window time.Duration) (FetchUsage, error) {
+	encodedStats, err := json.Marshal(stats)
+	if err != nil {
+		return FetchUsage{}, err
+	}
+
+	ipKey := redisFetchIPPrefix + username
+	clientKey := redisFetchClientPrefix + username
+	minScore := strconv.FormatInt(now.Add(-window).Unix(), 10)
+
+	pipe := r.rdb.TxPipeline()
+	if stats.LastIP != "" {
+		pipe.ZAdd(ctx, ipKey, redis.Z{Score: float64(now.Unix()), Member: stats.LastIP})
+	}
+	pipe.ZAdd(ctx, clientKey, redis.Z{Score: float64(now.Unix()), Member: stats.LastClient})
+	pipe.ZRemRangeByScore(ctx, ipKey, "-inf", "("+minScore)
+	pipe.ZRemRangeByScore(ctx, clientKey, "-inf", "("+minScore)
+	pipe.Expire(ctx, ipKey, window)
+	pipe.Expire(ctx, clientKey, window)
+	ipCount := pipe.ZCard(ctx, ipKey)
+	clientCount := pipe.ZCard(ctx, clientKey)
+	pipe.HSet(ctx, redisFetchStatsKey, username, string(encodedStats))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return FetchUsage{}, err
+	}
+
+	return FetchUsage{
+		DistinctIPs:     ipCount.Val(),
+		DistinctClients: clientCount.Val(),
+	}, nil
+}
+
+func (r *redisBackend) ListFetchSources(username string) ([]string, []string, error) {
+	ips, err := r.rdb.ZRevRange(ctx, redisFetchIPPrefix+username, 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	clients, err := r.rdb.ZRevRange(ctx, redisFetchClientPrefix+username, 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ips, clients, nil
+}
+
+func (r *redisBackend) GetFetchStats(username string) (FetchStats, error) {
+	raw, err := r.rdb.HGet(ctx, redisFetchStatsKey, username).Result()
+	if err == redis.Nil {
+		return FetchStats{}, nil
+	}
+	if err != nil {
+		return FetchStats{}, err
+	}
+
+	var stats FetchStats
+	if err := json.Unmarshal([]byte(raw), &stats); err != nil {
+		return FetchStats{}, nil
+	}
+	return stats, nil
+}
+
+func (r *redisBackend) MarkFetchFlagged(username string, window time.Duration) (bool, error) {
+	return r.rdb.SetNX(ctx, redisFetchFlaggedPrefix+username, "1", window).Result()
+}
+
+func (r *redisBackend) ResetFetchTracking(username string) error {
+	return r.rdb.Del(ctx,
+		redisFetchIPPrefix+username,
+		redisFetchClientPrefix+username,
+		redisFetchFlaggedPrefix+username,
+	).Err()
+}
+
+func (r *redisBackend) AddLeakAlert(alert LeakAlert, maxLen int) error {
+	encoded, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.rdb.Pipeline()
+	pipe.LPush(ctx, redisLeakAlertsKey, string(encoded))
+	pipe.LTrim(ctx, redisLeakAlertsKey, 0, int64(maxLen-1))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisBackend) ListLeakAlerts() ([]LeakAlert, error) {
+	raws, err := r.rdb.LRange(ctx, redisLeakAlertsKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make([]LeakAlert, 0, len(raws))
+	for _, raw := range raws {
+		var alert LeakAlert
+		if err := json.Unmarshal([]byte(raw), &alert); err != nil {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+func (r *redisBackend) ClearLeakAlerts() error {
+	return r.rdb.Del(ctx, redisLeakAlertsKey).Err()
+}
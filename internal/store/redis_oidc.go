@@ -0,0 +1,34 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisOIDCStatePrefix = "stash-rule:oidc_state:" // state -> OIDCLoginState(json)
+
+func (r *redisBackend) SaveOIDCState(state string, data OIDCLoginState, ttl time.Duration) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return r.rdb.Set(ctx, redisOIDCStatePrefix+state, string(encoded), ttl).Err()
+}
+
+func (r *redisBackend) ConsumeOIDCState(state string) (*OIDCLoginState, error) {
+	raw, err := r.rdb.GetDel(ctx, redisOIDCStatePrefix+state).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data OIDCLoginState
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
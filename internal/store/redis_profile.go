@@ -1,205 +1,177 @@
 package store
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
-	"sort"
-	"strings"
 
 	"github.com/redis/go-redis/v9"
-	"gopkg.in/yaml.v3"
 )
 
-const (
-	// DefaultStashProfileName 默认配置模板名。
-	DefaultStashProfileName = "default"
-	defaultStashProfileYAML = "{}\n"
-)
-
-var ErrStashProfileNotFound = errors.New("stash profile not found")
-
-// StashProfile 表示一个可编辑的 Stash 配置模板（YAML 内容）。
-type StashProfile struct {
-	Name      string `json:"name"`
-	Content   string `json:"content"`
-	IsDefault bool   `json:"is_default"`
-}
-
-func normalizeProfileName(name string) string {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return DefaultStashProfileName
-	}
-	return name
-}
+const redisProfileHistoryPrefix = "stash-rule:stash_profile_history:" // profileName -> list of StashProfileRevision(json)，最新在前
 
-// DefaultStashProfileNameIfEmpty 返回非空模板名，空则返回默认模板名。
-func DefaultStashProfileNameIfEmpty(name string) string {
-	return normalizeProfileName(name)
-}
+// redisProfileWatchRetries WATCH 的键被并发修改时模板事务的最大尝试次数。
+const redisProfileWatchRetries = 5
 
-func normalizeProfileContent(content string) string {
-	content = strings.TrimSpace(content)
-	if content == "" {
-		return defaultStashProfileYAML
-	}
-	if !strings.HasSuffix(content, "\n") {
-		content += "\n"
+// watchProfiles 执行模板相关的 WATCH 事务，遇到并发修改时重试，多次失败后返回 ErrStashProfileConflict。
+func (r *redisBackend) watchProfiles(fn func(tx *redis.Tx) error, keys ...string) error {
+	for attempt := 0; attempt < redisProfileWatchRetries; attempt++ {
+		err := r.rdb.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
 	}
-	return content
+	return ErrStashProfileConflict
 }
 
-// ParseStashProfileContent 解析配置模板 YAML，并确保根节点为 map。
-func ParseStashProfileContent(content string) (map[string]interface{}, error) {
-	content = normalizeProfileContent(content)
-
-	var parsed map[string]interface{}
-	if err := yaml.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, fmt.Errorf("invalid yaml: %w", err)
-	}
-	if parsed == nil {
-		parsed = map[string]interface{}{}
-	}
-	return parsed, nil
+func (r *redisBackend) ListProfiles() (map[string]string, error) {
+	return r.rdb.HGetAll(ctx, redisProfileKey).Result()
 }
 
-// InitDefaultStashProfile 初始化默认配置模板。
-func InitDefaultStashProfile() error {
-	if rdb == nil {
-		return fmt.Errorf("redis not initialized")
+func (r *redisBackend) GetProfile(name string) (string, bool, error) {
+	content, err := r.rdb.HGet(ctx, redisProfileKey, name).Result()
+	if err == redis.Nil {
+		return "", false, nil
 	}
-
-	exists, err := rdb.HExists(ctx, redisProfileKey, DefaultStashProfileName).Result()
 	if err != nil {
-		return err
-	}
-	if exists {
-		return nil
-	}
-
-	if err := rdb.HSet(ctx, redisProfileKey, DefaultStashProfileName, defaultStashProfileYAML).Err(); err != nil {
-		return err
+		return "", false, err
 	}
-
-	return nil
+	return content, true, nil
 }
 
-// ListStashProfiles 获取全部模板列表。
-func ListStashProfiles() ([]StashProfile, error) {
-	if rdb == nil {
-		return nil, fmt.Errorf("redis not initialized")
-	}
-
-	if err := InitDefaultStashProfile(); err != nil {
-		return nil, err
-	}
+func (r *redisBackend) SaveProfile(name, content string) error {
+	return r.rdb.HSet(ctx, redisProfileKey, name, content).Err()
+}
 
-	m, err := rdb.HGetAll(ctx, redisProfileKey).Result()
+// boundSubscribers 返回绑定到指定模板的订阅用户。
+func boundSubscribers(tx *redis.Tx, profileName string) ([]string, error) {
+	profiles, err := tx.HGetAll(ctx, redisUserProfileKey).Result()
 	if err != nil {
 		return nil, err
 	}
-
-	profiles := make([]StashProfile, 0, len(m))
-	for name, content := range m {
-		profiles = append(profiles, StashProfile{
-			Name:      name,
-			Content:   content,
-			IsDefault: name == DefaultStashProfileName,
-		})
-	}
-
-	sort.Slice(profiles, func(i, j int) bool {
-		if profiles[i].IsDefault != profiles[j].IsDefault {
-			return profiles[i].IsDefault
+	var usernames []string
+	for username, name := range profiles {
+		if name == profileName {
+			usernames = append(usernames, username)
 		}
-		return profiles[i].Name < profiles[j].Name
-	})
-
-	return profiles, nil
-}
-
-// ValidateStashProfileExists 检查模板是否存在。
-func ValidateStashProfileExists(name string) (bool, error) {
-	if rdb == nil {
-		return false, fmt.Errorf("redis not initialized")
 	}
-
-	name = normalizeProfileName(name)
-	return rdb.HExists(ctx, redisProfileKey, name).Result()
+	return usernames, nil
 }
 
-// GetStashProfileYAML 获取模板原始 YAML。
-func GetStashProfileYAML(name string) (string, error) {
-	if rdb == nil {
-		return "", fmt.Errorf("redis not initialized")
-	}
-
-	name = normalizeProfileName(name)
-	content, err := rdb.HGet(ctx, redisProfileKey, name).Result()
-	if err == redis.Nil {
-		return "", ErrStashProfileNotFound
-	}
-	if err != nil {
-		return "", err
-	}
-	return content, nil
-}
+func (r *redisBackend) DeleteProfile(name, reassignTo string) error {
+	return r.watchProfiles(func(tx *redis.Tx) error {
+		usernames, err := boundSubscribers(tx, name)
+		if err != nil {
+			return err
+		}
+		if reassignTo != "" {
+			exists, err := tx.HExists(ctx, redisProfileKey, reassignTo).Result()
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return reassignTargetNotFound(reassignTo)
+			}
+		} else {
+			if err := profileInUseError(name, usernames); err != nil {
+				return err
+			}
+		}
 
-// GetStashProfileMap 获取模板并解析为 map。
-func GetStashProfileMap(name string) (map[string]interface{}, error) {
-	content, err := GetStashProfileYAML(name)
-	if err != nil {
-		return nil, err
-	}
-	return ParseStashProfileContent(content)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, redisProfileKey, name)
+			pipe.Del(ctx, redisProfileHistoryPrefix+name)
+			for _, username := range usernames {
+				pipe.HSet(ctx, redisUserProfileKey, username, reassignTo)
+			}
+			return nil
+		})
+		return err
+	}, redisProfileKey, redisUserProfileKey)
 }
 
-// CreateStashProfile 创建模板（不存在时）。
-func CreateStashProfile(name, content string) error {
-	if rdb == nil {
-		return fmt.Errorf("redis not initialized")
-	}
-
-	name = normalizeProfileName(name)
-	if name == "" {
-		return fmt.Errorf("profile name is required")
-	}
-	if _, err := ParseStashProfileContent(content); err != nil {
-		return err
-	}
+func (r *redisBackend) RenameProfile(oldName, newName string) error {
+	return r.watchProfiles(func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, redisProfileKey, newName).Result()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errProfileExists
+		}
+		content, err := tx.HGet(ctx, redisProfileKey, oldName).Result()
+		if err == redis.Nil {
+			return ErrStashProfileNotFound
+		}
+		if err != nil {
+			return err
+		}
+		hasHistory, err := tx.Exists(ctx, redisProfileHistoryPrefix+oldName).Result()
+		if err != nil {
+			return err
+		}
+		usernames, err := boundSubscribers(tx, oldName)
+		if err != nil {
+			return err
+		}
 
-	exists, err := rdb.HExists(ctx, redisProfileKey, name).Result()
-	if err != nil {
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisProfileKey, newName, content)
+			pipe.HDel(ctx, redisProfileKey, oldName)
+			pipe.Del(ctx, redisProfileHistoryPrefix+newName)
+			if hasHistory > 0 {
+				pipe.Rename(ctx, redisProfileHistoryPrefix+oldName, redisProfileHistoryPrefix+newName)
+			}
+			for _, username := range usernames {
+				pipe.HSet(ctx, redisUserProfileKey, username, newName)
+			}
+			return nil
+		})
 		return err
-	}
-	if exists {
-		return fmt.Errorf("stash profile already exists")
-	}
-
-	return rdb.HSet(ctx, redisProfileKey, name, normalizeProfileContent(content)).Err()
+	}, redisProfileKey, redisUserProfileKey, redisProfileHistoryPrefix+oldName)
 }
 
-// UpdateStashProfile 更新模板（存在时）。
-func UpdateStashProfile(name, content string) error {
-	if rdb == nil {
-		return fmt.Errorf("redis not initialized")
-	}
+func (r *redisBackend) AddProfileRevision(name string, revision StashProfileRevision, maxLen int) error {
+	key := redisProfileHistoryPrefix + name
+	return r.watchProfiles(func(tx *redis.Tx) error {
+		var previous []StashProfileRevision
+		latest, err := tx.LIndex(ctx, key, 0).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			var head StashProfileRevision
+			if json.Unmarshal([]byte(latest), &head) == nil {
+				previous = append(previous, head)
+			}
+		}
+		revision.Version = nextProfileRevisionVersion(previous)
+		encoded, err := json.Marshal(revision)
+		if err != nil {
+			return err
+		}
 
-	name = normalizeProfileName(name)
-	if name == "" {
-		return fmt.Errorf("profile name is required")
-	}
-	if _, err := ParseStashProfileContent(content); err != nil {
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, key, string(encoded))
+			pipe.LTrim(ctx, key, 0, int64(maxLen-1))
+			return nil
+		})
 		return err
-	}
+	}, key)
+}
 
-	exists, err := rdb.HExists(ctx, redisProfileKey, name).Result()
+func (r *redisBackend) ListProfileRevisions(name string) ([]StashProfileRevision, error) {
+	raws, err := r.rdb.LRange(ctx, redisProfileHistoryPrefix+name, 0, -1).Result()
 	if err != nil {
-		return err
-	}
-	if !exists {
-		return ErrStashProfileNotFound
+		return nil, err
 	}
 
-	return rdb.HSet(ctx, redisProfileKey, name, normalizeProfileContent(content)).Err()
+	revisions := make([]StashProfileRevision, 0, len(raws))
+	for _, raw := range raws {
+		var revision StashProfileRevision
+		if err := json.Unmarshal([]byte(raw), &revision); err != nil {
+			continue
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
 }
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisRateLimitPrefix = "stash-rule:ratelimit:"  // route:key -> zset(request id, unix ms)
+	redisLoginFailPrefix = "stash-rule:login_fail:" // username -> 连续失败次数
+	redisLoginLockPrefix = "stash-rule:login_lock:" // username -> 1 (TTL = 锁定时长)
+)
+
+// slidingWindowScript 原子地执行滑动窗口限流：
+// 清理窗口外请求 -> 计数 -> 未超限时记录本次请求；超限时返回最早请求距窗口结束的毫秒数。
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+if count < limit then
+	redis.call("ZADD", key, now, member)
+	redis.call("PEXPIRE", key, window)
+	return {1, 0}
+end
+
+local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+local retry = window
+if oldest[2] then
+	retry = tonumber(oldest[2]) + window - now
+end
+return {0, retry}
+`)
+
+func (r *redisBackend) AllowRequest(key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return false, 0, err
+	}
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + hex.EncodeToString(b)
+
+	res, err := slidingWindowScript.Run(ctx, r.rdb, []string{redisRateLimitPrefix + key},
+		now.UnixMilli(), window.Milliseconds(), limit, member,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit result")
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func (r *redisBackend) LoginLockTTL(username string) (time.Duration, error) {
+	ttl, err := r.rdb.PTTL(ctx, redisLoginLockPrefix+username).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *redisBackend) IncrLoginFailures(username string, ttl time.Duration) (int64, error) {
+	failKey := redisLoginFailPrefix + username
+
+	pipe := r.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, failKey)
+	pipe.Expire(ctx, failKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *redisBackend) LockLogin(username string, ttl time.Duration) error {
+	pipe := r.rdb.TxPipeline()
+	pipe.Set(ctx, redisLoginLockPrefix+username, "1", ttl)
+	pipe.Del(ctx, redisLoginFailPrefix+username)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisBackend) ClearLoginFailures(username string) error {
+	return r.rdb.Del(ctx, redisLoginFailPrefix+username).Err()
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func (r *redisBackend) SaveSession(token string, session Session, ttl time.Duration) error {
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.rdb.TxPipeline()
+	pipe.Set(ctx, redisSessionPrefix+token, string(encoded), ttl)
+	pipe.SAdd(ctx, redisUserSessionKey+session.Username, token)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisBackend) GetSession(token string) (*Session, error) {
+	raw, err := r.rdb.Get(ctx, redisSessionPrefix+token).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		// 旧版 session 的值为用户名字符串，CreatedAt 为 0，由 ValidateSession 作废。
+		session = Session{Username: raw}
+	}
+	return &session, nil
+}
+
+func (r *redisBackend) ListSessionTokens(username string) ([]string, error) {
+	return r.rdb.SMembers(ctx, redisUserSessionKey+username).Result()
+}
+
+func (r *redisBackend) DeleteSessions(username string, tokens ...string) error {
+	pipe := r.rdb.TxPipeline()
+	for _, token := range tokens {
+		pipe.Del(ctx, redisSessionPrefix+token)
+		if username != "" {
+			pipe.SRem(ctx, redisUserSessionKey+username, token)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -1,191 +1,212 @@
 package store
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"fmt"
-	"sort"
-	"strings"
+	"encoding/json"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// Subscriber 表示一个订阅用户
-type Subscriber struct {
-	Username    string `json:"username"`
-	Token       string `json:"token"`
-	ProfileName string `json:"profile_name"`
-}
+const (
+	redisSubscriberAttrsKey     = "stash-rule:subscriber_attributes" // username -> subscriberAttributes(json)
+	redisSubscriberOverridesKey = "stash-rule:subscriber_overrides"  // username -> override yaml
+)
 
-func generateRandomToken() (string, error) {
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(b), nil
+// subscriberAttributes 订阅用户的标签与自定义变量。
+type subscriberAttributes struct {
+	Tags []string          `json:"tags,omitempty"`
+	Vars map[string]string `json:"vars,omitempty"`
 }
 
-// AddSubscriber 创建订阅用户并返回随机 token
-func AddSubscriber(username, profileName string) (string, error) {
-	if rdb == nil {
-		return "", fmt.Errorf("redis not initialized")
-	}
-
-	username = strings.TrimSpace(username)
-	profileName = normalizeProfileName(profileName)
-	if username == "" {
-		return "", fmt.Errorf("username is required")
+func decodeSubscriberAttributes(raw string) subscriberAttributes {
+	var attrs subscriberAttributes
+	if raw != "" {
+		_ = json.Unmarshal([]byte(raw), &attrs)
 	}
+	return attrs
+}
 
-	profileExists, err := ValidateStashProfileExists(profileName)
+func (r *redisBackend) ListSubscribers() ([]Subscriber, error) {
+	tokenMap, err := r.rdb.HGetAll(ctx, redisUserTokenKey).Result()
 	if err != nil {
-		return "", err
-	}
-	if !profileExists {
-		return "", fmt.Errorf("stash profile not found")
+		return nil, err
 	}
-
-	exists, err := rdb.HExists(ctx, redisUserTokenKey, username).Result()
+	profileMap, err := r.rdb.HGetAll(ctx, redisUserProfileKey).Result()
 	if err != nil {
-		return "", err
-	}
-	if exists {
-		return "", fmt.Errorf("subscriber already exists")
+		return nil, err
 	}
-
-	// Retry until token is unique.
-	for i := 0; i < 5; i++ {
-		token, err := generateRandomToken()
-		if err != nil {
-			return "", err
-		}
-
-		tokenExists, err := rdb.HExists(ctx, redisTokenKey, token).Result()
-		if err != nil {
-			return "", err
-		}
-		if tokenExists {
-			continue
-		}
-
-		pipe := rdb.Pipeline()
-		pipe.HSet(ctx, redisTokenKey, token, username)
-		pipe.HSet(ctx, redisUserTokenKey, username, token)
-		pipe.HSet(ctx, redisUserProfileKey, username, profileName)
-		if _, err := pipe.Exec(ctx); err != nil {
-			return "", err
-		}
-		return token, nil
+	disabledMap, err := r.rdb.HGetAll(ctx, redisSubscriberDisabled).Result()
+	if err != nil {
+		return nil, err
 	}
-
-	return "", fmt.Errorf("failed to generate unique token")
-}
-
-// ListSubscribers 获取所有订阅用户
-func ListSubscribers() ([]Subscriber, error) {
-	if rdb == nil {
-		return nil, fmt.Errorf("redis not initialized")
+	statsMap, err := r.rdb.HGetAll(ctx, redisFetchStatsKey).Result()
+	if err != nil {
+		return nil, err
 	}
-
-	tokenMap, err := rdb.HGetAll(ctx, redisUserTokenKey).Result()
+	attrsMap, err := r.rdb.HGetAll(ctx, redisSubscriberAttrsKey).Result()
 	if err != nil {
 		return nil, err
 	}
-	profileMap, err := rdb.HGetAll(ctx, redisUserProfileKey).Result()
+	overrideMap, err := r.rdb.HGetAll(ctx, redisSubscriberOverridesKey).Result()
 	if err != nil {
 		return nil, err
 	}
 
 	subscribers := make([]Subscriber, 0, len(tokenMap))
 	for username, token := range tokenMap {
-		profileName := normalizeProfileName(profileMap[username])
+		reason, disabled := disabledMap[username]
+		var stats FetchStats
+		if raw, ok := statsMap[username]; ok {
+			_ = json.Unmarshal([]byte(raw), &stats)
+		}
+		attrs := decodeSubscriberAttributes(attrsMap[username])
 		subscribers = append(subscribers, Subscriber{
-			Username:    username,
-			Token:       token,
-			ProfileName: profileName,
+			Username:       username,
+			Token:          token,
+			ProfileName:    profileMap[username],
+			Disabled:       disabled,
+			DisabledReason: reason,
+			Tags:           attrs.Tags,
+			Vars:           attrs.Vars,
+			Override:       overrideMap[username],
+			FetchStats:     stats,
 		})
 	}
-
-	sort.Slice(subscribers, func(i, j int) bool {
-		return subscribers[i].Username < subscribers[j].Username
-	})
-
 	return subscribers, nil
 }
 
-// GetSubscriberProfile 获取订阅用户绑定的模板名。
-func GetSubscriberProfile(username string) (string, error) {
-	if rdb == nil {
-		return "", fmt.Errorf("redis not initialized")
-	}
-
-	profileName, err := rdb.HGet(ctx, redisUserProfileKey, username).Result()
+func (r *redisBackend) GetSubscriber(username string) (*Subscriber, error) {
+	token, err := r.rdb.HGet(ctx, redisUserTokenKey, username).Result()
 	if err == redis.Nil {
-		return DefaultStashProfileName, nil
+		return nil, nil
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if strings.TrimSpace(profileName) == "" {
-		return DefaultStashProfileName, nil
+	pipe := r.rdb.Pipeline()
+	profile := pipe.HGet(ctx, redisUserProfileKey, username)
+	reason := pipe.HGet(ctx, redisSubscriberDisabled, username)
+	rawAttrs := pipe.HGet(ctx, redisSubscriberAttrsKey, username)
+	override := pipe.HGet(ctx, redisSubscriberOverridesKey, username)
+	_, _ = pipe.Exec(ctx)
+	for _, cmd := range []*redis.StringCmd{profile, reason, rawAttrs, override} {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+	stats, err := r.GetFetchStats(username)
+	if err != nil {
+		return nil, err
 	}
-	return profileName, nil
+
+	attrs := decodeSubscriberAttributes(rawAttrs.Val())
+
+	return &Subscriber{
+		Username:       username,
+		Token:          token,
+		ProfileName:    profile.Val(),
+		Disabled:       reason.Err() == nil,
+		DisabledReason: reason.Val(),
+		Tags:           attrs.Tags,
+		Vars:           attrs.Vars,
+		Override:       override.Val(),
+		FetchStats:     stats,
+	}, nil
 }
 
-// UpdateSubscriberProfile 更新订阅用户绑定的模板名。
-func UpdateSubscriberProfile(username, profileName string) error {
-	if rdb == nil {
-		return fmt.Errorf("redis not initialized")
+func (r *redisBackend) FindSubscriberByToken(token string) (*Subscriber, error) {
+	username, err := r.rdb.HGet(ctx, redisTokenKey, token).Result()
+	if err == redis.Nil {
+		return nil, nil
 	}
-
-	username = strings.TrimSpace(username)
-	profileName = normalizeProfileName(profileName)
-	if username == "" {
-		return fmt.Errorf("username is required")
+	if err != nil {
+		return nil, err
 	}
+	return r.GetSubscriber(username)
+}
 
-	userExists, err := rdb.HExists(ctx, redisUserTokenKey, username).Result()
+func (r *redisBackend) CreateSubscriber(subscriber Subscriber) error {
+	exists, err := r.rdb.HExists(ctx, redisUserTokenKey, subscriber.Username).Result()
 	if err != nil {
 		return err
 	}
-	if !userExists {
-		return fmt.Errorf("subscriber not found")
+	if exists {
+		return errSubscriberExists
 	}
 
-	profileExists, err := ValidateStashProfileExists(profileName)
+	tokenExists, err := r.rdb.HExists(ctx, redisTokenKey, subscriber.Token).Result()
 	if err != nil {
 		return err
 	}
-	if !profileExists {
-		return fmt.Errorf("stash profile not found")
+	if tokenExists {
+		return errTokenExists
+	}
+
+	pipe := r.rdb.Pipeline()
+	pipe.HSet(ctx, redisTokenKey, subscriber.Token, subscriber.Username)
+	pipe.HSet(ctx, redisUserTokenKey, subscriber.Username, subscriber.Token)
+	pipe.HSet(ctx, redisUserProfileKey, subscriber.Username, subscriber.ProfileName)
+	if len(subscriber.Tags) > 0 || len(subscriber.Vars) > 0 {
+		encoded, err := json.Marshal(subscriberAttributes{Tags: subscriber.Tags, Vars: subscriber.Vars})
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, redisSubscriberAttrsKey, subscriber.Username, string(encoded))
+	}
+	if subscriber.Override != "" {
+		pipe.HSet(ctx, redisSubscriberOverridesKey, subscriber.Username, subscriber.Override)
 	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
 
-	return rdb.HSet(ctx, redisUserProfileKey, username, profileName).Err()
+func (r *redisBackend) SetSubscriberProfile(username, profileName string) error {
+	return r.rdb.HSet(ctx, redisUserProfileKey, username, profileName).Err()
 }
 
-// DeleteSubscriber 删除订阅用户及其 token/profile 绑定。
-func DeleteSubscriber(username string) error {
-	if rdb == nil {
-		return fmt.Errorf("redis not initialized")
+func (r *redisBackend) SetSubscriberAttributes(username string, tags []string, vars map[string]string) error {
+	if len(tags) == 0 && len(vars) == 0 {
+		return r.rdb.HDel(ctx, redisSubscriberAttrsKey, username).Err()
 	}
+	encoded, err := json.Marshal(subscriberAttributes{Tags: tags, Vars: vars})
+	if err != nil {
+		return err
+	}
+	return r.rdb.HSet(ctx, redisSubscriberAttrsKey, username, string(encoded)).Err()
+}
 
-	username = strings.TrimSpace(username)
-	if username == "" {
-		return fmt.Errorf("username is required")
+func (r *redisBackend) SetSubscriberOverride(username, content string) error {
+	if content == "" {
+		return r.rdb.HDel(ctx, redisSubscriberOverridesKey, username).Err()
 	}
+	return r.rdb.HSet(ctx, redisSubscriberOverridesKey, username, content).Err()
+}
 
-	token, err := rdb.HGet(ctx, redisUserTokenKey, username).Result()
-	if err == redis.Nil {
-		return fmt.Errorf("subscriber not found")
+func (r *redisBackend) SetSubscriberDisabled(username string, disabled bool, reason string) error {
+	if !disabled {
+		return r.rdb.HDel(ctx, redisSubscriberDisabled, username).Err()
 	}
-	if err != nil {
+	return r.rdb.HSet(ctx, redisSubscriberDisabled, username, reason).Err()
+}
+
+func (r *redisBackend) DeleteSubscriber(username string) error {
+	token, err := r.rdb.HGet(ctx, redisUserTokenKey, username).Result()
+	if err != nil && err != redis.Nil {
 		return err
 	}
 
-	pipe := rdb.Pipeline()
+	pipe := r.rdb.Pipeline()
 	pipe.HDel(ctx, redisUserTokenKey, username)
 	pipe.HDel(ctx, redisUserProfileKey, username)
+	pipe.HDel(ctx, redisSubscriberDisabled, username)
+	pipe.HDel(ctx, redisSubscriberAttrsKey, username)
+	pipe.HDel(ctx, redisSubscriberOverridesKey, username)
+	pipe.HDel(ctx, redisFetchStatsKey, username)
+	pipe.Del(ctx,
+		redisFetchIPPrefix+username,
+		redisFetchClientPrefix+username,
+		redisFetchFlaggedPrefix+username,
+	)
 	if token != "" {
 		pipe.HDel(ctx, redisTokenKey, token)
 	}
@@ -193,33 +214,3 @@ func DeleteSubscriber(username string) error {
 	_, err = pipe.Exec(ctx)
 	return err
 }
-
-// ValidateAPIToken 验证订阅 token，返回用户名
-func ValidateAPIToken(token string) (string, error) {
-	if rdb == nil {
-		return "", fmt.Errorf("redis not initialized")
-	}
-	username, err := rdb.HGet(ctx, redisTokenKey, token).Result()
-	if err == redis.Nil {
-		return "", nil
-	}
-	if err != nil {
-		return "", err
-	}
-	return username, nil
-}
-
-// GetAPIToken 获取订阅用户 token
-func GetAPIToken(username string) (string, error) {
-	if rdb == nil {
-		return "", fmt.Errorf("redis not initialized")
-	}
-	token, err := rdb.HGet(ctx, redisUserTokenKey, username).Result()
-	if err == redis.Nil {
-		return "", nil
-	}
-	if err != nil {
-		return "", err
-	}
-	return token, nil
-}
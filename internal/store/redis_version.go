@@ -0,0 +1,17 @@
+package store
+
+import "github.com/redis/go-redis/v9"
+
+const redisDataVersionKey = "stash-rule:data_version" // 影响配置生成的数据的版本号
+
+func (r *redisBackend) DataVersion() (int64, error) {
+	version, err := r.rdb.Get(ctx, redisDataVersionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *redisBackend) IncrDataVersion() error {
+	return r.rdb.Incr(ctx, redisDataVersionKey).Err()
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"my-stash-rule/internal/config"
+)
+
+// sessionTouchInterval 限制 last_seen 的写入频率，避免每个请求都写存储。
+const sessionTouchInterval = time.Minute
+
+// Session 表示一个管理员登录会话。
+type Session struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// sessionID 由 token 派生出可对外展示的会话 ID，避免泄露 token 本身。
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SessionID 返回 token 对应的会话 ID。
+func SessionID(token string) string {
+	return sessionID(token)
+}
+
+func sessionTTL(session Session, now time.Time) time.Duration {
+	ttl := config.GetSessionIdleTimeout()
+	remaining := time.Unix(session.CreatedAt, 0).Add(config.GetSessionMaxLifetime()).Sub(now)
+	if remaining < ttl {
+		ttl = remaining
+	}
+	return ttl
+}
+
+func getSession(token string) (*Session, error) {
+	session, err := backend.GetSession(token)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	session.ID = sessionID(token)
+	return session, nil
+}
+
+// CreateSession 创建 Session 并返回 Token
+func CreateSession(username, ip, userAgent string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	token, err := generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := Session{
+		Username:   username,
+		CreatedAt:  now.Unix(),
+		LastSeenAt: now.Unix(),
+		IP:         ip,
+		UserAgent:  normalizeClientFingerprint(userAgent),
+	}
+	if err := backend.SaveSession(token, session, sessionTTL(session, now)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ValidateSession 验证 Session，返回用户名。
+// 有效 session 会顺延空闲超时（滑动过期），但不超过最长有效期。
+func ValidateSession(token string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	session, err := getSession(token)
+	if err != nil {
+		return "", err
+	}
+	if session == nil {
+		return "", nil // Invalid session
+	}
+	// 旧版 session 只存了用户名，不在会话索引中，RevokeAllSessions 无法撤销，直接作废。
+	if session.CreatedAt == 0 {
+		return "", DeleteSession(token)
+	}
+
+	now := time.Now()
+	if now.Unix()-session.LastSeenAt >= int64(sessionTouchInterval.Seconds()) {
+		ttl := sessionTTL(*session, now)
+		if ttl <= 0 {
+			return "", DeleteSession(token)
+		}
+		session.LastSeenAt = now.Unix()
+		if err := backend.SaveSession(token, *session, ttl); err != nil {
+			return "", err
+		}
+	}
+
+	return session.Username, nil
+}
+
+// DeleteSession 删除单个 session（登出）。
+func DeleteSession(token string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	session, err := getSession(token)
+	if err != nil {
+		return err
+	}
+
+	username := ""
+	if session != nil {
+		username = session.Username
+	}
+	return backend.DeleteSessions(username, token)
+}
+
+// ListSessions 列出管理员的全部有效 session（最近活跃在前），并清理已过期的索引。
+func ListSessions(username string) ([]Session, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	tokens, err := backend.ListSessionTokens(username)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	var stale []string
+	for _, token := range tokens {
+		session, err := getSession(token)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil || session.Username != username {
+			stale = append(stale, token)
+			continue
+		}
+		sessions = append(sessions, *session)
+	}
+	if len(stale) > 0 {
+		if err := backend.DeleteSessions(username, stale...); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+	})
+	return sessions, nil
+}
+
+// RevokeSession 按会话 ID 撤销管理员的某个 session。
+func RevokeSession(username, id string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	id = strings.TrimSpace(id)
+	tokens, err := backend.ListSessionTokens(username)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if sessionID(token) == id {
+			return backend.DeleteSessions(username, token)
+		}
+	}
+	return fmt.Errorf("session not found")
+}
+
+// RevokeAllSessions 撤销管理员的全部 session，exceptToken 非空时保留该 session。
+func RevokeAllSessions(username, exceptToken string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	tokens, err := backend.ListSessionTokens(username)
+	if err != nil {
+		return err
+	}
+
+	revoke := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token != exceptToken {
+			revoke = append(revoke, token)
+		}
+	}
+	if len(revoke) == 0 {
+		return nil
+	}
+	return backend.DeleteSessions(username, revoke...)
+}
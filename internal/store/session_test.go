@@ -0,0 +1,112 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func useMemorySessions(t *testing.T) *memoryBackend {
+	t.Helper()
+	m := NewMemoryBackend().(*memoryBackend)
+	UseBackend(m)
+	t.Cleanup(func() { _ = CloseStorage() })
+	return m
+}
+
+func TestValidateSessionSlidesIdleTimeout(t *testing.T) {
+	m := useMemorySessions(t)
+	t.Setenv("SESSION_IDLE_TIMEOUT", "1h")
+	t.Setenv("SESSION_MAX_LIFETIME", "24h")
+
+	token, err := CreateSession("admin", "192.0.2.10", "test-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟 50 分钟前最后活跃：剩余 10 分钟空闲时间。
+	now := time.Now()
+	session, _ := m.GetSession(token)
+	session.CreatedAt = now.Add(-50 * time.Minute).Unix()
+	session.LastSeenAt = session.CreatedAt
+	if err := m.SaveSession(token, *session, 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	username, err := ValidateSession(token)
+	if err != nil || username != "admin" {
+		t.Fatalf("ValidateSession = %q, %v; want admin", username, err)
+	}
+	if expiresIn := time.Until(m.sessions[token].expiresAt); expiresIn < 59*time.Minute {
+		t.Errorf("idle timeout not extended: expires in %v", expiresIn)
+	}
+	if got, _ := m.GetSession(token); got.LastSeenAt < now.Unix() {
+		t.Errorf("last_seen_at not updated: %d", got.LastSeenAt)
+	}
+
+	// 顺延不超过最长有效期。
+	session, _ = m.GetSession(token)
+	session.CreatedAt = now.Add(-(24*time.Hour - 5*time.Minute)).Unix()
+	session.LastSeenAt = now.Add(-2 * time.Minute).Unix()
+	if err := m.SaveSession(token, *session, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateSession(token); err != nil {
+		t.Fatal(err)
+	}
+	if expiresIn := time.Until(m.sessions[token].expiresAt); expiresIn > 6*time.Minute {
+		t.Errorf("session extended past max lifetime: expires in %v", expiresIn)
+	}
+}
+
+func TestValidateSessionRejectsLegacySessions(t *testing.T) {
+	m := useMemorySessions(t)
+
+	// 旧版 session 没有创建时间，也不在会话索引中。
+	if err := m.SaveSession("legacy-token", Session{Username: "admin"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	username, err := ValidateSession("legacy-token")
+	if err != nil || username != "" {
+		t.Fatalf("ValidateSession = %q, %v; want rejected", username, err)
+	}
+	if session, _ := m.GetSession("legacy-token"); session != nil {
+		t.Error("legacy session should be deleted")
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	useMemorySessions(t)
+
+	first, _ := CreateSession("admin", "192.0.2.10", "a")
+	second, _ := CreateSession("admin", "192.0.2.11", "b")
+	third, _ := CreateSession("admin", "192.0.2.12", "c")
+	other, _ := CreateSession("editor", "192.0.2.13", "d")
+
+	sessions, err := ListSessions("admin")
+	if err != nil || len(sessions) != 3 {
+		t.Fatalf("ListSessions = %d sessions, %v; want 3", len(sessions), err)
+	}
+
+	if err := RevokeSession("admin", SessionID(first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeSession("admin", SessionID(other)); err == nil {
+		t.Error("revoking another admin's session by id should fail")
+	}
+	if username, _ := ValidateSession(first); username != "" {
+		t.Error("revoked session is still valid")
+	}
+
+	if err := RevokeAllSessions("admin", third); err != nil {
+		t.Fatal(err)
+	}
+	if username, _ := ValidateSession(second); username != "" {
+		t.Error("session should be revoked by RevokeAllSessions")
+	}
+	if username, _ := ValidateSession(third); username != "admin" {
+		t.Error("current session should be kept")
+	}
+	if username, _ := ValidateSession(other); username != "editor" {
+		t.Error("other admin's session should be kept")
+	}
+}
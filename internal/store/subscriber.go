@@ -0,0 +1,418 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Subscriber 表示一个订阅用户
+type Subscriber struct {
+	Username       string `json:"username"`
+	Token          string `json:"token"`
+	ProfileName    string `json:"profile_name"`
+	Disabled       bool   `json:"disabled"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	// Tags / Vars 供模板渲染使用，如 {{ if .HasTag "vip" }}、{{ .Vars.dns }}。
+	Tags []string          `json:"tags,omitempty"`
+	Vars map[string]string `json:"vars,omitempty"`
+	// Override 订阅用户专属的覆盖配置（YAML），在模板之后最后合并。
+	Override   string     `json:"override,omitempty"`
+	FetchStats FetchStats `json:"fetch_stats"`
+}
+
+func generateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddSubscriber 创建订阅用户并返回随机 token
+func AddSubscriber(username, profileName string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	profileName = normalizeProfileName(profileName)
+	if username == "" {
+		return "", fmt.Errorf("username is required")
+	}
+
+	profileExists, err := ValidateStashProfileExists(profileName)
+	if err != nil {
+		return "", err
+	}
+	if !profileExists {
+		return "", fmt.Errorf("stash profile not found")
+	}
+
+	// Retry until token is unique.
+	for i := 0; i < 5; i++ {
+		token, err := generateRandomToken()
+		if err != nil {
+			return "", err
+		}
+
+		err = backend.CreateSubscriber(Subscriber{
+			Username:    username,
+			Token:       token,
+			ProfileName: profileName,
+		})
+		if errors.Is(err, errTokenExists) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		markDataChanged()
+		return token, nil
+	}
+
+	return "", fmt.Errorf("failed to generate unique token")
+}
+
+// RestoreSubscriber 按备份内容创建订阅用户，保留原 token 与禁用状态；token 为空时生成新 token。
+func RestoreSubscriber(subscriber Subscriber) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	subscriber.Username = strings.TrimSpace(subscriber.Username)
+	subscriber.ProfileName = normalizeProfileName(subscriber.ProfileName)
+	if subscriber.Username == "" {
+		return "", fmt.Errorf("username is required")
+	}
+	tags, vars, err := NormalizeSubscriberAttributes(subscriber.Tags, subscriber.Vars)
+	if err != nil {
+		return "", err
+	}
+	subscriber.Tags, subscriber.Vars = tags, vars
+	if subscriber.Override, err = NormalizeSubscriberOverride(subscriber.Override); err != nil {
+		return "", err
+	}
+	if subscriber.Token == "" {
+		token, err := generateRandomToken()
+		if err != nil {
+			return "", err
+		}
+		subscriber.Token = token
+	}
+
+	profileExists, err := ValidateStashProfileExists(subscriber.ProfileName)
+	if err != nil {
+		return "", err
+	}
+	if !profileExists {
+		return "", fmt.Errorf("stash profile not found")
+	}
+
+	if err := backend.CreateSubscriber(subscriber); err != nil {
+		return "", err
+	}
+	markDataChanged()
+	if subscriber.Disabled {
+		if err := backend.SetSubscriberDisabled(subscriber.Username, true, subscriber.DisabledReason); err != nil {
+			return "", err
+		}
+	}
+	return subscriber.Token, nil
+}
+
+// ListSubscribers 获取所有订阅用户
+func ListSubscribers() ([]Subscriber, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+
+	subscribers, err := backend.ListSubscribers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range subscribers {
+		subscribers[i].ProfileName = normalizeProfileName(subscribers[i].ProfileName)
+	}
+
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].Username < subscribers[j].Username
+	})
+
+	return subscribers, nil
+}
+
+// GetSubscriberProfile 获取订阅用户绑定的模板名。
+func GetSubscriberProfile(username string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return "", err
+	}
+	if subscriber == nil {
+		return DefaultStashProfileName, nil
+	}
+	return normalizeProfileName(subscriber.ProfileName), nil
+}
+
+// UpdateSubscriberProfile 更新订阅用户绑定的模板名。
+func UpdateSubscriberProfile(username, profileName string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	profileName = normalizeProfileName(profileName)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return err
+	}
+	if subscriber == nil {
+		return errSubscriberNotFound
+	}
+
+	profileExists, err := ValidateStashProfileExists(profileName)
+	if err != nil {
+		return err
+	}
+	if !profileExists {
+		return fmt.Errorf("stash profile not found")
+	}
+
+	if err := backend.SetSubscriberProfile(username, profileName); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// subscriberVarNamePattern 自定义变量名需能在模板中以 .Vars.name 引用。
+var subscriberVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NormalizeSubscriberAttributes 整理标签（去空白、去重、排序）并校验变量名。
+func NormalizeSubscriberAttributes(tags []string, vars map[string]string) ([]string, map[string]string, error) {
+	seen := make(map[string]struct{}, len(tags))
+	var normalizedTags []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if _, dup := seen[tag]; dup {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalizedTags = append(normalizedTags, tag)
+	}
+	sort.Strings(normalizedTags)
+
+	var normalizedVars map[string]string
+	for name, value := range vars {
+		name = strings.TrimSpace(name)
+		if !subscriberVarNamePattern.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid variable name %q: use letters, digits and underscores", name)
+		}
+		if normalizedVars == nil {
+			normalizedVars = make(map[string]string, len(vars))
+		}
+		normalizedVars[name] = value
+	}
+	return normalizedTags, normalizedVars, nil
+}
+
+// UpdateSubscriberAttributes 更新订阅用户的标签与自定义变量（整体覆盖）。
+func UpdateSubscriberAttributes(username string, tags []string, vars map[string]string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	tags, vars, err := NormalizeSubscriberAttributes(tags, vars)
+	if err != nil {
+		return err
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return err
+	}
+	if subscriber == nil {
+		return errSubscriberNotFound
+	}
+	if err := backend.SetSubscriberAttributes(username, tags, vars); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// NormalizeSubscriberOverride 校验订阅用户的覆盖配置：需为 YAML map（可使用模板语法），不支持 extends。
+// 空内容表示不覆盖。
+func NormalizeSubscriberOverride(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", nil
+	}
+	parsed, err := ParseStashProfileContent(content)
+	if err != nil {
+		return "", err
+	}
+	if _, declared := parsed[ProfileExtendsKey]; declared {
+		return "", fmt.Errorf("%s is not allowed in subscriber override", ProfileExtendsKey)
+	}
+	return content + "\n", nil
+}
+
+// UpdateSubscriberOverride 更新订阅用户的覆盖配置，content 为空时清除。
+func UpdateSubscriberOverride(username, content string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	content, err := NormalizeSubscriberOverride(content)
+	if err != nil {
+		return err
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return err
+	}
+	if subscriber == nil {
+		return errSubscriberNotFound
+	}
+	if err := backend.SetSubscriberOverride(username, content); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// UpdateSubscriberProfileAndOverride 同时更新订阅用户绑定的模板与覆盖配置，为 nil 的项保持不变。
+// 先完成全部校验（用户、模板是否存在，覆盖配置是否有效）再写入，任一项无效时都不做修改。
+func UpdateSubscriberProfileAndOverride(username string, profileName, override *string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return err
+	}
+	if subscriber == nil {
+		return errSubscriberNotFound
+	}
+
+	var normalizedProfile, normalizedOverride string
+	if profileName != nil {
+		normalizedProfile = normalizeProfileName(*profileName)
+		profileExists, err := ValidateStashProfileExists(normalizedProfile)
+		if err != nil {
+			return err
+		}
+		if !profileExists {
+			return fmt.Errorf("stash profile not found")
+		}
+	}
+	if override != nil {
+		if normalizedOverride, err = NormalizeSubscriberOverride(*override); err != nil {
+			return err
+		}
+	}
+
+	defer markDataChanged()
+	if profileName != nil {
+		if err := backend.SetSubscriberProfile(username, normalizedProfile); err != nil {
+			return err
+		}
+	}
+	if override != nil {
+		return backend.SetSubscriberOverride(username, normalizedOverride)
+	}
+	return nil
+}
+
+// GetSubscriber 获取订阅用户，不存在时返回 nil。
+func GetSubscriber(username string) (*Subscriber, error) {
+	if backend == nil {
+		return nil, errStoreNotInitialized
+	}
+	return backend.GetSubscriber(username)
+}
+
+// DeleteSubscriber 删除订阅用户及其 token/profile 绑定。
+func DeleteSubscriber(username string) error {
+	if backend == nil {
+		return errStoreNotInitialized
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil {
+		return err
+	}
+	if subscriber == nil {
+		return errSubscriberNotFound
+	}
+	if err := backend.DeleteSubscriber(username); err != nil {
+		return err
+	}
+	markDataChanged()
+	return nil
+}
+
+// ValidateAPIToken 验证订阅 token，返回用户名。
+// 已被禁用的订阅用户视为无效 token。
+func ValidateAPIToken(token string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	subscriber, err := backend.FindSubscriberByToken(token)
+	if err != nil {
+		return "", err
+	}
+	if subscriber == nil || subscriber.Disabled {
+		return "", nil
+	}
+	return subscriber.Username, nil
+}
+
+// GetAPIToken 获取订阅用户 token
+func GetAPIToken(username string) (string, error) {
+	if backend == nil {
+		return "", errStoreNotInitialized
+	}
+
+	subscriber, err := backend.GetSubscriber(username)
+	if err != nil || subscriber == nil {
+		return "", err
+	}
+	return subscriber.Token, nil
+}
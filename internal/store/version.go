@@ -0,0 +1,20 @@
+package store
+
+import "log"
+
+// DataVersion 返回影响配置生成的数据（订阅链接、模板、订阅用户、节点缓存）的当前版本号，
+// 版本号不变时据此生成的配置也不变。
+func DataVersion() (int64, error) {
+	if backend == nil {
+		return 0, errStoreNotInitialized
+	}
+	return backend.DataVersion()
+}
+
+// markDataChanged 在上述数据写入成功后递增版本号。
+// 递增失败时只记录日志：写入本身已经生效，已生成配置的缓存会在下一次修改后失效。
+func markDataChanged() {
+	if err := backend.IncrDataVersion(); err != nil {
+		log.Printf("Failed to bump data version: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// volatileSweepInterval 清理过期短期数据的最小间隔。
+const volatileSweepInterval = time.Minute
+
+// volatileState 在进程内存中保存限流窗口、登录失败计数、拉取窗口与 OIDC state 等短期数据，
+// 供不依赖 Redis 的单实例后端使用；进程重启后这些数据会丢失，不影响持久数据。
+type volatileState struct {
+	mu sync.Mutex
+
+	rateWindows   map[string]*rateWindow
+	loginFailures map[string]expiringCounter
+	loginLocks    map[string]time.Time
+	oidcStates    map[string]expiringOIDCState
+	fetchWindows  map[string]*fetchWindow
+	fetchFlagged  map[string]time.Time
+	lastSweep     time.Time
+	dataVersion   int64
+}
+
+type rateWindow struct {
+	hits   []time.Time
+	window time.Duration
+}
+
+type expiringCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+type expiringOIDCState struct {
+	data      OIDCLoginState
+	expiresAt time.Time
+}
+
+type fetchWindow struct {
+	ips     map[string]time.Time
+	clients map[string]time.Time
+	window  time.Duration
+}
+
+func newVolatileState() *volatileState {
+	return &volatileState{
+		rateWindows:   map[string]*rateWindow{},
+		loginFailures: map[string]expiringCounter{},
+		loginLocks:    map[string]time.Time{},
+		oidcStates:    map[string]expiringOIDCState{},
+		fetchWindows:  map[string]*fetchWindow{},
+		fetchFlagged:  map[string]time.Time{},
+	}
+}
+
+// sweepLocked 定期清理全部过期数据，调用方需持有锁。
+func (v *volatileState) sweepLocked(now time.Time) {
+	if now.Sub(v.lastSweep) < volatileSweepInterval {
+		return
+	}
+	v.lastSweep = now
+
+	for key, w := range v.rateWindows {
+		if w.prune(now); len(w.hits) == 0 {
+			delete(v.rateWindows, key)
+		}
+	}
+	for key, c := range v.loginFailures {
+		if !now.Before(c.expiresAt) {
+			delete(v.loginFailures, key)
+		}
+	}
+	for key, until := range v.loginLocks {
+		if !now.Before(until) {
+			delete(v.loginLocks, key)
+		}
+	}
+	for key, s := range v.oidcStates {
+		if !now.Before(s.expiresAt) {
+			delete(v.oidcStates, key)
+		}
+	}
+	for key, w := range v.fetchWindows {
+		if w.prune(now); len(w.ips) == 0 && len(w.clients) == 0 {
+			delete(v.fetchWindows, key)
+		}
+	}
+	for key, until := range v.fetchFlagged {
+		if !now.Before(until) {
+			delete(v.fetchFlagged, key)
+		}
+	}
+}
+
+func (w *rateWindow) prune(now time.Time) {
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.hits) && !w.hits[i].After(cutoff) {
+		i++
+	}
+	w.hits = w.hits[i:]
+}
+
+func (v *volatileState) AllowRequest(key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sweepLocked(now)
+
+	w := v.rateWindows[key]
+	if w == nil {
+		w = &rateWindow{window: window}
+		v.rateWindows[key] = w
+	}
+	w.window = window
+	w.prune(now)
+
+	if len(w.hits) < limit {
+		w.hits = append(w.hits, now)
+		return true, 0, nil
+	}
+
+	retry := window
+	if len(w.hits) > 0 {
+		retry = w.hits[0].Add(window).Sub(now)
+	}
+	return false, retry, nil
+}
+
+func (v *volatileState) IncrLoginFailures(username string, ttl time.Duration) (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	v.sweepLocked(now)
+
+	c := v.loginFailures[username]
+	if !now.Before(c.expiresAt) {
+		c.count = 0
+	}
+	c.count++
+	c.expiresAt = now.Add(ttl)
+	v.loginFailures[username] = c
+	return c.count, nil
+}
+
+func (v *volatileState) ClearLoginFailures(username string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.loginFailures, username)
+	return nil
+}
+
+func (v *volatileState) LockLogin(username string, ttl time.Duration) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.loginLocks[username] = time.Now().Add(ttl)
+	delete(v.loginFailures, username)
+	return nil
+}
+
+func (v *volatileState) LoginLockTTL(username string) (time.Duration, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	until, ok := v.loginLocks[username]
+	if !ok {
+		return 0, nil
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		delete(v.loginLocks, username)
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+func (v *volatileState) SaveOIDCState(state string, data OIDCLoginState, ttl time.Duration) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	v.sweepLocked(now)
+
+	v.oidcStates[state] = expiringOIDCState{data: data, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (v *volatileState) ConsumeOIDCState(state string) (*OIDCLoginState, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.oidcStates[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(v.oidcStates, state)
+	if !time.Now().Before(s.expiresAt) {
+		return nil, nil
+	}
+	data := s.data
+	return &data, nil
+}
+
+func (w *fetchWindow) prune(now time.Time) {
+	cutoff := now.Add(-w.window)
+	for ip, seen := range w.ips {
+		if seen.Before(cutoff) {
+			delete(w.ips, ip)
+		}
+	}
+	for client, seen := range w.clients {
+		if seen.Before(cutoff) {
+			delete(w.clients, client)
+		}
+	}
+}
+
+// recordFetchWindow 记录一次拉取的 IP / 客户端，并返回窗口内的去重数量。
+func (v *volatileState) recordFetchWindow(username, ip, client string, now time.Time, window time.Duration) FetchUsage {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sweepLocked(now)
+
+	w := v.fetchWindows[username]
+	if w == nil {
+		w = &fetchWindow{ips: map[string]time.Time{}, clients: map[string]time.Time{}}
+		v.fetchWindows[username] = w
+	}
+	w.window = window
+	if ip != "" {
+		w.ips[ip] = now
+	}
+	w.clients[client] = now
+	w.prune(now)
+
+	return FetchUsage{
+		DistinctIPs:     int64(len(w.ips)),
+		DistinctClients: int64(len(w.clients)),
+	}
+}
+
+func sortedByLastSeen(m map[string]time.Time) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !m[keys[i]].Equal(m[keys[j]]) {
+			return m[keys[i]].After(m[keys[j]])
+		}
+		return keys[i] > keys[j]
+	})
+	return keys
+}
+
+func (v *volatileState) ListFetchSources(username string) ([]string, []string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w := v.fetchWindows[username]
+	if w == nil {
+		return []string{}, []string{}, nil
+	}
+	w.prune(time.Now())
+	return sortedByLastSeen(w.ips), sortedByLastSeen(w.clients), nil
+}
+
+func (v *volatileState) MarkFetchFlagged(username string, window time.Duration) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if until, ok := v.fetchFlagged[username]; ok && now.Before(until) {
+		return false, nil
+	}
+	v.fetchFlagged[username] = now.Add(window)
+	return true, nil
+}
+
+func (v *volatileState) ResetFetchTracking(username string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.fetchWindows, username)
+	delete(v.fetchFlagged, username)
+	return nil
+}
+
+func (v *volatileState) DataVersion() (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.dataVersion, nil
+}
+
+func (v *volatileState) IncrDataVersion() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.dataVersion++
+	return nil
+}
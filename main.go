@@ -13,31 +13,14 @@ import (
 func main() {
 	config.LoadEnv()
 
-	// Initialize Redis
-	if err := store.InitRedis(); err != nil {
-		log.Fatal("Failed to initialize Redis:", err)
+	// Initialize storage backend
+	if err := store.InitStorage(); err != nil {
+		log.Fatal("Failed to initialize storage:", err)
 	}
 	service.StartDailyProxyCacheScheduler()
+	service.StartBackupScheduler()
 
-	http.HandleFunc("/", handler.HandleGetConfig)
-	http.HandleFunc("/health", handler.HandleHealthCheck)
-
-	// Auth routes
-	http.HandleFunc("/login", handler.HandleLogin)
-	http.HandleFunc("/logout", handler.HandleLogout)
-
-	// Protected routes
-	http.HandleFunc("/admin", handler.AdminAuthMiddleware(handler.HandleAdminPage))
-	http.HandleFunc("/admin/config", handler.AdminAuthMiddleware(handler.HandleAdminConfigPage))
-	http.HandleFunc("/admin/profiles", handler.AdminAuthMiddleware(handler.HandleAdminProfilesPage))
-	http.HandleFunc("/admin/subscribers", handler.AdminAuthMiddleware(handler.HandleAdminSubscribersPage))
-	http.HandleFunc("/admin/account", handler.AdminAuthMiddleware(handler.HandleAdminAccountPage))
-	http.HandleFunc("/api/config", handler.AdminAuthMiddleware(handler.HandleConfigAPI))
-	http.HandleFunc("/api/proxy/cache", handler.AdminAuthMiddleware(handler.HandleProxyCacheAPI))
-	http.HandleFunc("/api/stash/profiles", handler.AdminAuthMiddleware(handler.HandleStashProfilesAPI))
-	http.HandleFunc("/api/admin/profile", handler.AdminAuthMiddleware(handler.HandleAdminProfileAPI))
-	http.HandleFunc("/api/subscribers", handler.AdminAuthMiddleware(handler.HandleSubscribersAPI))
-	http.HandleFunc("/api/user/info", handler.AdminAuthMiddleware(handler.HandleGetUserInfo))
+	handler.RegisterRoutes(http.DefaultServeMux)
 
 	port := config.GetPort()
 	addr := ":" + port